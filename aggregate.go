@@ -0,0 +1,148 @@
+package csvstruct
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// AggregateOpts specifies options for Aggregate.
+type AggregateOpts struct {
+	GroupBy []string // columns whose values identify a group
+	Fields  []string // numeric columns to sum, min and max within each group
+
+	Decode DecodeOpts // options for reading the input
+	Encode EncodeOpts // options for writing the summary
+}
+
+// Aggregate reads the rows of r and writes to w one summary row per distinct
+// combination of the GroupBy columns, in the order the groups are first seen.
+//
+// Each summary row holds the GroupBy values, the number of rows in the
+// group as "count", and for each of Fields the columns "<field>_sum",
+// "<field>_min" and "<field>_max". Empty cells are ignored; other cells of
+// Fields must parse as numbers.
+//
+// Only the running totals for each group are held in memory, not the rows.
+func Aggregate(w io.Writer, r io.Reader, opts AggregateOpts) error {
+	d := NewDecoder(r).Opts(opts.Decode).(*decoder)
+	if err := d.readHeader(); err != nil {
+		return err
+	}
+	gcols, err := columnIndexes(d.hm, opts.GroupBy)
+	if err != nil {
+		return err
+	}
+	fcols, err := columnIndexes(d.hm, opts.Fields)
+	if err != nil {
+		return err
+	}
+
+	groups := map[string]*group{}
+	order := []*group{}
+	for {
+		rec, err := d.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		key := make([]string, len(gcols))
+		for i, c := range gcols {
+			if c < len(rec) {
+				key[i] = rec[c]
+			}
+		}
+		// Join with a byte that can't appear unescaped in a single key.
+		k := strings.Join(key, "\x00")
+		g, ok := groups[k]
+		if !ok {
+			g = &group{key: key, stats: make([]fieldStats, len(fcols))}
+			groups[k] = g
+			order = append(order, g)
+		}
+		g.count++
+		for i, c := range fcols {
+			if c >= len(rec) || rec[c] == "" {
+				continue
+			}
+			f, err := strconv.ParseFloat(rec[c], 64)
+			if err != nil {
+				return fmt.Errorf("row %d: column %q: %v", d.rows, opts.Fields[i], err)
+			}
+			g.stats[i].add(f)
+		}
+	}
+
+	header := append([]string(nil), opts.GroupBy...)
+	header = append(header, "count")
+	for _, f := range opts.Fields {
+		header = append(header, f+"_sum", f+"_min", f+"_max")
+	}
+	e := NewEncoder(w).Opts(opts.Encode).(*encoder)
+	if e.err != nil {
+		return e.err
+	}
+	if err := e.setHeader(header); err != nil {
+		return err
+	}
+	for _, g := range order {
+		row := append([]string(nil), g.key...)
+		row = append(row, strconv.Itoa(g.count))
+		for _, s := range g.stats {
+			if s.n == 0 {
+				row = append(row, "0", "", "")
+				continue
+			}
+			row = append(row, formatNumber(s.sum), formatNumber(s.min), formatNumber(s.max))
+		}
+		if err := e.writeRow(row); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// group holds the running totals for one group of rows.
+type group struct {
+	key   []string
+	count int
+	stats []fieldStats
+}
+
+// fieldStats holds the running totals for one field within a group.
+type fieldStats struct {
+	n             int
+	sum, min, max float64
+}
+
+func (s *fieldStats) add(f float64) {
+	if s.n == 0 || f < s.min {
+		s.min = f
+	}
+	if s.n == 0 || f > s.max {
+		s.max = f
+	}
+	s.sum += f
+	s.n++
+}
+
+// columnIndexes returns the index in hm of each of the named columns.
+func columnIndexes(hm map[string]int, names []string) ([]int, error) {
+	idx := make([]int, len(names))
+	for i, n := range names {
+		c, ok := hm[n]
+		if !ok {
+			return nil, fmt.Errorf("no column %q", n)
+		}
+		idx[i] = c
+	}
+	return idx, nil
+}
+
+// formatNumber formats f without trailing zeros, so that whole numbers are
+// written as integers.
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
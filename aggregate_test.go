@@ -0,0 +1,38 @@
+package csvstruct
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	in := `region,product,qty,price
+east,apple,3,1.5
+west,apple,1,1.25
+east,pear,2,
+east,apple,5,0.5
+`
+	want := `region,count,qty_sum,qty_min,qty_max,price_sum,price_min,price_max
+east,3,10,2,5,2,0.5,1.5
+west,1,1,1,1,1.25,1.25,1.25
+`
+	var buf bytes.Buffer
+	if err := Aggregate(&buf, strings.NewReader(in), AggregateOpts{
+		GroupBy: []string{"region"},
+		Fields:  []string{"qty", "price"},
+	}); err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	buf.Reset()
+	if err := Aggregate(&buf, strings.NewReader("a,b\nx,y\n"), AggregateOpts{Fields: []string{"b"}}); err == nil {
+		t.Errorf("Aggregate: expected error for non-numeric field")
+	}
+	if err := Aggregate(&buf, strings.NewReader("a,b\nx,y\n"), AggregateOpts{GroupBy: []string{"c"}}); err == nil {
+		t.Errorf("Aggregate: expected error for missing column")
+	}
+}
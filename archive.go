@@ -0,0 +1,62 @@
+package csvstruct
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"time"
+)
+
+// ZipEntries returns a function suitable for ShardOpts.Create that writes
+// each file as an entry of zw. The caller is responsible for closing zw once
+// the Encoder is closed.
+func ZipEntries(zw *zip.Writer) func(name string) (io.WriteCloser, error) {
+	return func(name string) (io.WriteCloser, error) {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// TarEntries returns a function suitable for ShardOpts.Create that writes
+// each file as an entry of tw. Since tar headers record the size of each
+// entry, entries are buffered in memory until they are closed. The caller is
+// responsible for closing tw once the Encoder is closed.
+func TarEntries(tw *tar.Writer) func(name string) (io.WriteCloser, error) {
+	return func(name string) (io.WriteCloser, error) {
+		return &tarEntry{tw: tw, name: name}, nil
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// tarEntry buffers the contents of a tar entry until it is closed.
+type tarEntry struct {
+	tw   *tar.Writer
+	name string
+	buf  bytes.Buffer
+}
+
+func (t *tarEntry) Write(p []byte) (int, error) { return t.buf.Write(p) }
+
+func (t *tarEntry) Close() error {
+	if err := t.tw.WriteHeader(&tar.Header{
+		Name:    t.name,
+		Mode:    0644,
+		Size:    int64(t.buf.Len()),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := t.buf.WriteTo(t.tw)
+	return err
+}
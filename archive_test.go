@@ -0,0 +1,85 @@
+package csvstruct
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+var archiveRows = []struct{ A, B string }{{"a", "b"}, {"c", "d"}, {"e", "f"}}
+
+var archiveWant = map[string]string{
+	"data/part-1.csv": "A,B\na,b\nc,d\n",
+	"data/part-2.csv": "A,B\ne,f\n",
+}
+
+func encodeArchive(t *testing.T, create func(string) (io.WriteCloser, error)) {
+	e := NewShardEncoder("data/part-%d.csv", ShardOpts{MaxRowsPerFile: 2, Create: create})
+	for _, r := range archiveRows {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestZipEntries(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	encodeArchive(t, ZipEntries(zw))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip Close: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	if len(zr.File) != len(archiveWant) {
+		t.Errorf("got %d entries, want %d", len(zr.File), len(archiveWant))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Open(%s): %v", f.Name, err)
+		}
+		got, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if want := archiveWant[f.Name]; string(got) != want {
+			t.Errorf("%s: got %q, want %q", f.Name, got, want)
+		}
+	}
+}
+
+func TestTarEntries(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	encodeArchive(t, TarEntries(tw))
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar Close: %v", err)
+	}
+
+	tr := tar.NewReader(&buf)
+	n := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		n++
+		got, _ := ioutil.ReadAll(tr)
+		if want := archiveWant[h.Name]; string(got) != want {
+			t.Errorf("%s: got %q, want %q", h.Name, got, want)
+		}
+	}
+	if n != len(archiveWant) {
+		t.Errorf("got %d entries, want %d", n, len(archiveWant))
+	}
+}
@@ -27,6 +27,25 @@ func BenchmarkDecode(b *testing.B) {
 	}
 }
 
+func BenchmarkDecodeReuseRecord(b *testing.B) {
+	in, _ := ioutil.ReadAll(generateCSV())
+	b.ReportAllocs()
+	b.ResetTimer()
+	d := NewDecoder(nil).Opts(DecodeOpts{ReuseRecord: true})
+	var r struct{ A, B, C string }
+	for i := 0; i < b.N; i++ {
+		d.Reset(strings.NewReader(string(in)))
+		for {
+			if err := d.DecodeNext(&r); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Errorf("DecodeNext(%q): %v", in, err)
+				return
+			}
+		}
+	}
+}
+
 func BenchmarkCSVRead(b *testing.B) {
 	in := generateCSV()
 	b.ResetTimer()
@@ -58,6 +77,24 @@ func BenchmarkEncode(b *testing.B) {
 	}
 }
 
+func BenchmarkEncodeStringMap(b *testing.B) {
+	rows := []map[string]string{}
+	for i := 0; i < numRows; i++ {
+		rows = append(rows, map[string]string{"A": randString(), "B": randString(), "C": randString()})
+	}
+	b.ResetTimer()
+
+	e := NewEncoder(ioutil.Discard)
+	for i := 0; i < b.N; i++ {
+		for _, r := range rows {
+			if err := e.EncodeNext(r); err != nil {
+				b.Errorf("EncodeNext(%v): %v", r, err)
+				return
+			}
+		}
+	}
+}
+
 func BenchmarkCSVWrite(b *testing.B) {
 	d := [][]string{}
 	for i := 0; i < numRows; i++ {
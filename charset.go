@@ -0,0 +1,96 @@
+package csvstruct
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Charset identifies the character encoding of a CSV stream.
+type Charset int
+
+const (
+	UTF8        Charset = iota // UTF-8 (the default)
+	Latin1                     // ISO-8859-1
+	Windows1252                // Windows code page 1252
+	UTF16LE                    // UTF-16, little-endian
+	UTF16BE                    // UTF-16, big-endian
+	ShiftJIS                   // Shift JIS, for input only
+)
+
+// charsetNames maps IANA character set names to Charsets.
+var charsetNames = map[string]Charset{
+	"utf-8":        UTF8,
+	"utf8":         UTF8,
+	"iso-8859-1":   Latin1,
+	"latin1":       Latin1,
+	"windows-1252": Windows1252,
+	"cp1252":       Windows1252,
+	"utf-16le":     UTF16LE,
+	"utf-16be":     UTF16BE,
+	"shift_jis":    ShiftJIS,
+	"sjis":         ShiftJIS,
+}
+
+// lookupCharset returns the Charset with the given name, ignoring case.
+func lookupCharset(name string) (Charset, bool) {
+	cs, ok := charsetNames[strings.ToLower(name)]
+	return cs, ok
+}
+
+// mimeName returns the IANA name of cs, for Content-Type headers.
+func (cs Charset) mimeName() string {
+	switch cs {
+	case Latin1:
+		return "iso-8859-1"
+	case Windows1252:
+		return "windows-1252"
+	case UTF16LE:
+		return "utf-16le"
+	case UTF16BE:
+		return "utf-16be"
+	case ShiftJIS:
+		return "shift_jis"
+	}
+	return "utf-8"
+}
+
+// textEncoding returns the encoding of cs, or nil for UTF8.
+func (cs Charset) textEncoding() encoding.Encoding {
+	switch cs {
+	case Latin1:
+		return charmap.ISO8859_1
+	case Windows1252:
+		return charmap.Windows1252
+	case UTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case UTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	case ShiftJIS:
+		return japanese.ShiftJIS
+	}
+	return nil
+}
+
+// encodeRune returns the byte encoding r in cs, a single-byte Charset, and
+// whether there is one.
+func encodeRune(cs Charset, r rune) (byte, bool) {
+	if cm, ok := cs.textEncoding().(*charmap.Charmap); ok {
+		return cm.EncodeRune(r)
+	}
+	return 0, false
+}
+
+// decodeCharset returns a Reader that transcodes r from cs to UTF-8.
+func decodeCharset(r io.Reader, cs Charset) io.Reader {
+	enc := cs.textEncoding()
+	if enc == nil {
+		return r
+	}
+	return transform.NewReader(r, enc.NewDecoder())
+}
@@ -0,0 +1,167 @@
+package csvstruct
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecode_Charset(t *testing.T) {
+	type row struct{ Name, City string }
+	want := row{"José", "Zürich €"}
+	for _, c := range []struct {
+		cs Charset
+		s  string
+	}{
+		{UTF8, "Name,City\nJosé,Zürich €"},
+		{Latin1, "Name,City\nJos\xe9,Z\xfcrich \xa4"},
+		{Windows1252, "Name,City\nJos\xe9,Z\xfcrich \x80"},
+		{UTF16LE, "N\x00a\x00m\x00e\x00,\x00C\x00i\x00t\x00y\x00\n\x00J\x00o\x00s\x00\xe9\x00,\x00Z\x00\xfc\x00r\x00i\x00c\x00h\x00 \x00\xac\x20"},
+		{UTF16BE, "\x00N\x00a\x00m\x00e\x00,\x00C\x00i\x00t\x00y\x00\n\x00J\x00o\x00s\x00\xe9\x00,\x00Z\x00\xfc\x00r\x00i\x00c\x00h\x00 \x20\xac"},
+	} {
+		var r row
+		d := NewDecoder(strings.NewReader(c.s)).Opts(DecodeOpts{Charset: c.cs})
+		if err := d.DecodeNext(&r); err != nil {
+			t.Errorf("DecodeNext(%q): %v", c.s, err)
+			continue
+		}
+		// Latin-1 has no euro sign; 0xa4 is the generic currency sign.
+		w := want
+		if c.cs == Latin1 {
+			w.City = "Zürich ¤"
+		}
+		if r != w {
+			t.Errorf("DecodeNext(%q) with charset %d: got %v, want %v", c.s, c.cs, r, w)
+		}
+		if !isDone(d) {
+			t.Errorf("decoder unexpectedly not done")
+		}
+	}
+}
+
+func TestDecode_ShiftJIS(t *testing.T) {
+	// The second byte of ソ is 0x5c, the backslash in ASCII.
+	s := "Name,City\n\x83\x5c\x83\x6e\x83\x4c,\x93\x8c\x8b\x9e"
+	var r struct{ Name, City string }
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{Charset: ShiftJIS})
+	if err := d.DecodeNext(&r); err != nil {
+		t.Fatalf("DecodeNext(%q): %v", s, err)
+	}
+	if r.Name != "ソハキ" || r.City != "東京" {
+		t.Errorf("DecodeNext(%q): got %v", s, r)
+	}
+	if cs, ok := lookupCharset("Shift_JIS"); !ok || cs != ShiftJIS {
+		t.Errorf("lookupCharset(Shift_JIS): got %d, %t", cs, ok)
+	}
+}
+
+func TestDecode_UTF16Surrogates(t *testing.T) {
+	// U+1F600 is encoded as the surrogate pair D83D DE00.
+	s := "A\x00\n\x00\x3d\xd8\x00\xde"
+	var r struct{ A string }
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{Charset: UTF16LE})
+	if err := d.DecodeNext(&r); err != nil {
+		t.Errorf("DecodeNext(%q): %v", s, err)
+	}
+	if want := "\U0001F600"; r.A != want {
+		t.Errorf("DecodeNext(%q): got %q, want %q", s, r.A, want)
+	}
+}
+
+func TestDecode_BOM(t *testing.T) {
+	type row struct{ Name, City string }
+	want := row{"José", "Zürich"}
+	for _, c := range []struct {
+		cs Charset
+		s  string
+	}{
+		{UTF8, "\xef\xbb\xbfName,City\nJosé,Zürich"},
+		{UTF8, "\xff\xfeN\x00a\x00m\x00e\x00,\x00C\x00i\x00t\x00y\x00\n\x00J\x00o\x00s\x00\xe9\x00,\x00Z\x00\xfc\x00r\x00i\x00c\x00h\x00"},
+		{UTF8, "\xfe\xff\x00N\x00a\x00m\x00e\x00,\x00C\x00i\x00t\x00y\x00\n\x00J\x00o\x00s\x00\xe9\x00,\x00Z\x00\xfc\x00r\x00i\x00c\x00h"},
+		// A BOM overrides the configured charset.
+		{Latin1, "\xef\xbb\xbfName,City\nJosé,Zürich"},
+		{UTF16LE, "\xff\xfeN\x00a\x00m\x00e\x00,\x00C\x00i\x00t\x00y\x00\n\x00J\x00o\x00s\x00\xe9\x00,\x00Z\x00\xfc\x00r\x00i\x00c\x00h\x00"},
+	} {
+		var r row
+		d := NewDecoder(strings.NewReader(c.s))
+		if c.cs != UTF8 {
+			d = d.Opts(DecodeOpts{Charset: c.cs})
+		}
+		if err := d.DecodeNext(&r); err != nil {
+			t.Errorf("DecodeNext(%q): %v", c.s, err)
+			continue
+		}
+		if r != want {
+			t.Errorf("DecodeNext(%q): got %v, want %v", c.s, r, want)
+		}
+	}
+}
+
+func TestDecode_InvalidUTF8(t *testing.T) {
+	s := "A,B\nok,b\xffad"
+	for _, c := range []struct {
+		policy  InvalidUTF8Policy
+		want    string
+		wantErr bool
+	}{
+		{KeepInvalid, "b\xffad", false},
+		{RejectInvalid, "", true},
+		{ReplaceInvalid, "b\ufffdad", false},
+		{StripInvalid, "bad", false},
+	} {
+		var r struct{ A, B string }
+		err := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{InvalidUTF8: c.policy}).DecodeNext(&r)
+		if c.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "line 2") {
+				t.Errorf("policy %d: expected positional error, got %v", c.policy, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("policy %d: %v", c.policy, err)
+		}
+		if r.B != c.want {
+			t.Errorf("policy %d: got %q, want %q", c.policy, r.B, c.want)
+		}
+	}
+}
+
+func TestEncode_Charset(t *testing.T) {
+	type row struct{ Name, City string }
+	for _, c := range []struct {
+		opts    EncodeOpts
+		in      row
+		want    string
+		wantErr bool
+	}{
+		{EncodeOpts{Charset: Latin1}, row{"José", "Zürich ¤"}, "Name,City\nJos\xe9,Z\xfcrich \xa4\n", false},
+		{EncodeOpts{Charset: Windows1252}, row{"José", "Zürich €"}, "Name,City\nJos\xe9,Z\xfcrich \x80\n", false},
+		{EncodeOpts{Charset: Latin1}, row{"José", "Zürich €"}, "", true},
+		{EncodeOpts{Charset: Latin1, Unmappable: "?"}, row{"José", "Zürich €"}, "Name,City\nJos\xe9,Z\xfcrich ?\n", false},
+		{EncodeOpts{Charset: Windows1252, Comma: ';'}, row{"a;b", "Ω"}, "", true},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(c.opts)
+		err := e.EncodeNext(c.in)
+		if c.wantErr {
+			if err == nil || !strings.Contains(err.Error(), `column "City"`) {
+				t.Errorf("%+v: expected error naming the column, got %v", c.opts, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: EncodeNext: %v", c.opts, err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.opts, got, c.want)
+		}
+
+		// The output decodes back to the original.
+		var r row
+		if err := NewDecoder(&buf).Opts(DecodeOpts{Charset: c.opts.Charset}).DecodeNext(&r); err != nil {
+			t.Errorf("DecodeNext: %v", err)
+		} else if c.opts.Unmappable == "" && r != c.in {
+			t.Errorf("round trip: got %v, want %v", r, c.in)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/ImJasonH/csvstruct"
+)
+
+func convert(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	from := fs.String("from", "csv", "input format: csv, tsv, jsonl or json")
+	to := fs.String("to", "csv", "output format: csv, tsv, jsonl or json")
+	comma := fs.String("comma", ",", "input field delimiter for csv")
+	outComma := fs.String("out-comma", ",", "output field delimiter for csv")
+	crlf := fs.Bool("crlf", false, "use \\r\\n as the output line terminator")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	in, err := input(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dopts := csvstruct.DecodeOpts{}
+	if dopts.Comma, err = formatComma(*from, *comma); err != nil {
+		return err
+	}
+	eopts := csvstruct.EncodeOpts{UseCRLF: *crlf}
+	if eopts.Comma, err = formatComma(*to, *outComma); err != nil {
+		return err
+	}
+
+	var r io.Reader = in
+	if *from == "json" {
+		if r, err = jsonArrayToLines(r); err != nil {
+			return err
+		}
+	}
+	out := bufio.NewWriter(stdout)
+	var w io.Writer = out
+	var lines bytes.Buffer
+	if *to == "json" {
+		w = &lines
+	}
+
+	switch {
+	case isCSV(*from) && isCSV(*to):
+		err = reformat(r, w, dopts, eopts)
+	case isCSV(*from):
+		err = csvstruct.ToJSONLines(r, w, dopts)
+	case isCSV(*to):
+		err = csvstruct.FromJSONLines(r, w, eopts)
+	default:
+		_, err = io.Copy(w, r)
+	}
+	if err != nil {
+		return err
+	}
+	if *to == "json" {
+		writeJSONArray(out, lines.Bytes())
+	}
+	return out.Flush()
+}
+
+func isCSV(format string) bool { return format == "csv" || format == "tsv" }
+
+// formatComma returns the delimiter to use for the given format.
+func formatComma(format, comma string) (rune, error) {
+	switch format {
+	case "csv":
+		return delimiter(comma)
+	case "tsv":
+		return '\t', nil
+	case "jsonl", "json":
+		return ',', nil
+	default:
+		return 0, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// reformat copies CSV records from r to w, changing only the dialect.
+func reformat(r io.Reader, w io.Writer, dopts csvstruct.DecodeOpts, eopts csvstruct.EncodeOpts) error {
+	d := csvstruct.NewDecoder(r).Opts(dopts)
+	cw := csv.NewWriter(w)
+	cw.Comma = eopts.Comma
+	cw.UseCRLF = eopts.UseCRLF
+	header, err := d.Header()
+	if err != nil {
+		return err
+	}
+	cw.Write(header)
+	for {
+		if err := d.DecodeNext(nil); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		cw.Write(d.LastRecord())
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonArrayToLines converts a JSON array of objects to JSON Lines.
+func jsonArrayToLines(r io.Reader) (io.Reader, error) {
+	var objs []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&objs); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, o := range objs {
+		if err := json.Compact(&buf, o); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	return &buf, nil
+}
+
+// writeJSONArray writes JSON Lines as a JSON array.
+func writeJSONArray(w io.Writer, lines []byte) {
+	io.WriteString(w, "[")
+	for i, l := range bytes.Split(bytes.TrimSpace(lines), []byte("\n")) {
+		if len(l) == 0 {
+			continue
+		}
+		if i > 0 {
+			io.WriteString(w, ",\n")
+		}
+		w.Write(l)
+	}
+	io.WriteString(w, "]\n")
+}
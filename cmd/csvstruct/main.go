@@ -0,0 +1,66 @@
+// Command csvstruct converts, inspects and validates CSV files.
+//
+// Usage:
+//
+//	csvstruct convert [-from csv|tsv|jsonl|json] [-to csv|tsv|jsonl|json] [-comma c] [-out-comma c] [-crlf] [file]
+//	csvstruct infer [-comma c] [file]
+//	csvstruct validate -schema schema.json [-comma c] [file]
+//
+// Input is read from file, or from standard input if no file is given.
+// Output is written to standard output.
+//
+// infer prints a schema describing the type of each column, which validate
+// checks files against. Schemas are JSON documents of the form:
+//
+//	{"columns": [{"name": "zip", "type": "string"}, {"name": "age", "type": "int"}]}
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "csvstruct:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: csvstruct convert|infer|validate [flags] [file]")
+	}
+	switch args[0] {
+	case "convert":
+		return convert(args[1:], stdin, stdout)
+	case "infer":
+		return infer(args[1:], stdin, stdout)
+	case "validate":
+		return validate(args[1:], stdin, stdout)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// input returns the file named by the remaining arguments, or stdin.
+func input(args []string, stdin io.Reader) (io.ReadCloser, error) {
+	switch len(args) {
+	case 0:
+		return io.NopCloser(stdin), nil
+	case 1:
+		return os.Open(args[0])
+	default:
+		return nil, fmt.Errorf("too many arguments: %v", args)
+	}
+}
+
+// delimiter returns the single rune in s.
+func delimiter(s string) (rune, error) {
+	r := []rune(s)
+	if len(r) != 1 {
+		return 0, fmt.Errorf("delimiter must be a single character, got %q", s)
+	}
+	return r[0], nil
+}
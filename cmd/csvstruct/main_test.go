@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const people = "name,zip,age,score\nAlice,02134,30,9.5\nBob,94043,25,7\n"
+
+func TestConvert(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		in   string
+		want string
+	}{{
+		[]string{"convert", "-to", "tsv"},
+		people,
+		"name\tzip\tage\tscore\nAlice\t02134\t30\t9.5\nBob\t94043\t25\t7\n",
+	}, {
+		[]string{"convert", "-to", "tsv"},
+		"name,zip\n",
+		"name\tzip\n",
+	}, {
+		[]string{"convert", "-comma", ";", "-to", "jsonl"},
+		"a;b\n1;x\n",
+		`{"a":1,"b":"x"}` + "\n",
+	}, {
+		[]string{"convert", "-from", "tsv", "-to", "json"},
+		"a\tb\n1\tx\n2\ty\n",
+		"[{\"a\":1,\"b\":\"x\"},\n{\"a\":2,\"b\":\"y\"}]\n",
+	}, {
+		[]string{"convert", "-from", "json", "-to", "csv", "-crlf"},
+		`[{"b": "x", "a": 1}]`,
+		"a,b\r\n1,x\r\n",
+	}} {
+		var out bytes.Buffer
+		if err := run(c.args, strings.NewReader(c.in), &out); err != nil {
+			t.Errorf("run(%v): %v", c.args, err)
+			continue
+		}
+		if got := out.String(); got != c.want {
+			t.Errorf("run(%v): got %q, want %q", c.args, got, c.want)
+		}
+	}
+}
+
+func TestInferValidate(t *testing.T) {
+	var out bytes.Buffer
+	if err := run([]string{"infer"}, strings.NewReader(people), &out); err != nil {
+		t.Fatalf("infer: %v", err)
+	}
+	for _, want := range []string{`"name": "zip",
+      "type": "string"`, `"name": "age",
+      "type": "int"`, `"name": "score",
+      "type": "float"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("infer: output %s does not contain %s", out.String(), want)
+		}
+	}
+
+	schema := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(schema, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := run([]string{"validate", "-schema", schema}, strings.NewReader(people), &out); err != nil {
+		t.Errorf("validate: %v\n%s", err, out.String())
+	}
+
+	out.Reset()
+	bad := "name,zip,age,score\nCarol,10001,old,x\n"
+	if err := run([]string{"validate", "-schema", schema}, strings.NewReader(bad), &out); err == nil {
+		t.Errorf("validate(%q): expected error", bad)
+	}
+	if got := out.String(); !strings.Contains(got, `row 1: column "age": "old" is not a valid int`) ||
+		!strings.Contains(got, `row 1: column "score": "x" is not a valid float`) {
+		t.Errorf("validate(%q): got %q", bad, got)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/ImJasonH/csvstruct"
+)
+
+func infer(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("infer", flag.ContinueOnError)
+	comma := fs.String("comma", ",", "field delimiter")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	opts, in, err := openCSV(fs, *comma, stdin)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Every column starts out able to be any kind, and is narrowed by
+	// each non-empty cell.
+	d := csvstruct.NewDecoder(in).Opts(opts)
+	header, err := d.Header()
+	if err != nil {
+		return err
+	}
+	fits := map[string]map[csvstruct.Kind]bool{}
+	for _, h := range header {
+		fits[h] = map[csvstruct.Kind]bool{csvstruct.KindInt: true, csvstruct.KindFloat: true, csvstruct.KindBool: true}
+	}
+	for {
+		rec, err := d.Peek()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		for i, v := range rec {
+			if i >= len(header) || v == "" {
+				continue
+			}
+			f := fits[header[i]]
+			// Leading zeros are significant, as in ZIP codes.
+			zeros := len(v) > 1 && v[0] == '0' && v[1] != '.'
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil || zeros {
+				f[csvstruct.KindInt] = false
+			}
+			if _, err := strconv.ParseFloat(v, 64); err != nil || zeros {
+				f[csvstruct.KindFloat] = false
+			}
+			if _, err := strconv.ParseBool(v); err != nil {
+				f[csvstruct.KindBool] = false
+			}
+		}
+		if err := d.DecodeNext(nil); err != nil {
+			return err
+		}
+	}
+
+	s := csvstruct.Schema{Columns: []csvstruct.SchemaColumn{}}
+	for _, h := range header {
+		k := csvstruct.KindString
+		for _, c := range []csvstruct.Kind{csvstruct.KindInt, csvstruct.KindFloat, csvstruct.KindBool} {
+			if fits[h][c] {
+				k = c
+				break
+			}
+		}
+		s.Columns = append(s.Columns, csvstruct.SchemaColumn{Name: h, Type: k})
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s\n", b)
+	return err
+}
+
+func validate(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	comma := fs.String("comma", ",", "field delimiter")
+	schemaFile := fs.String("schema", "", "schema file, as printed by infer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *schemaFile == "" {
+		return fmt.Errorf("-schema is required")
+	}
+	f, err := os.Open(*schemaFile)
+	if err != nil {
+		return err
+	}
+	s, err := csvstruct.LoadSchema(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	types := map[string]csvstruct.Kind{}
+	for _, c := range s.Columns {
+		types[c.Name] = c.Type
+	}
+
+	opts, in, err := openCSV(fs, *comma, stdin)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	opts.TypeOverrides = types
+
+	d := csvstruct.NewDecoder(in).Opts(opts)
+	header, err := d.Header()
+	if err != nil {
+		return err
+	}
+	problems := 0
+	for _, c := range s.Columns {
+		if !contains(header, c.Name) {
+			fmt.Fprintf(stdout, "missing column %q\n", c.Name)
+			problems++
+		}
+	}
+	for {
+		rec, err := d.Peek()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		row := d.Checkpoint().Row + 1
+		m := map[string]interface{}{}
+		if err := d.DecodeNext(&m); err != nil {
+			// Report each bad cell rather than only the first.
+			for i, v := range rec {
+				if i >= len(header) || v == "" {
+					continue
+				}
+				if k, ok := types[header[i]]; ok && !fitsKind(k, v) {
+					fmt.Fprintf(stdout, "row %d: column %q: %q is not a valid %s\n", row, header[i], v, k)
+					problems++
+				}
+			}
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
+
+func fitsKind(k csvstruct.Kind, v string) bool {
+	var err error
+	switch k {
+	case csvstruct.KindInt:
+		_, err = strconv.ParseInt(v, 10, 64)
+	case csvstruct.KindFloat:
+		_, err = strconv.ParseFloat(v, 64)
+	case csvstruct.KindBool:
+		_, err = strconv.ParseBool(v)
+	}
+	return err == nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// openCSV returns decoding options and the input named by fs's arguments.
+func openCSV(fs *flag.FlagSet, comma string, stdin io.Reader) (csvstruct.DecodeOpts, io.ReadCloser, error) {
+	c, err := delimiter(comma)
+	if err != nil {
+		return csvstruct.DecodeOpts{}, nil, err
+	}
+	in, err := input(fs.Args(), stdin)
+	return csvstruct.DecodeOpts{Comma: c}, in, err
+}
@@ -0,0 +1,182 @@
+// Command csvvet reports mistakes in the csv struct tags read by csvstruct,
+// as described by package tagcheck. It runs as a go vet tool:
+//
+//	go vet -vettool=$(which csvvet) ./...
+//
+// or on the named Go files and directories:
+//
+//	csvvet [file.go|dir ...]
+//
+// It exits with status 1 if it finds any mistakes.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ImJasonH/csvstruct/tagcheck"
+)
+
+// errFound is returned by run when mistakes were reported.
+var errFound = errors.New("found mistakes in csv tags")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err == errFound {
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "csvvet:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	// go vet first asks the tool for its version, to cache its results,
+	// and for its flags, of which there are none.
+	if len(args) == 1 {
+		switch args[0] {
+		case "-V=full":
+			return version(stdout)
+		case "-flags":
+			_, err := fmt.Fprintln(stdout, "[]")
+			return err
+		}
+	}
+	if n := len(args); n > 0 && strings.HasSuffix(args[n-1], ".cfg") {
+		// Other flags go vet passes are ignored.
+		jsonOut := false
+		for _, a := range args[:n-1] {
+			jsonOut = jsonOut || a == "-json"
+		}
+		return vet(args[n-1], jsonOut, stdout, stderr)
+	}
+	var files []string
+	for _, a := range args {
+		fi, err := os.Stat(a)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			files = append(files, a)
+			continue
+		}
+		names, err := filepath.Glob(filepath.Join(a, "*.go"))
+		if err != nil {
+			return err
+		}
+		files = append(files, names...)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("usage: csvvet [file.go|dir ...]")
+	}
+	return check(files, stderr)
+}
+
+// version prints the tool's version as go vet expects it, identifying the
+// build by the hash of the executable.
+func version(stdout io.Writer) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(exe)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s version devel comments-go-here buildID=%02x\n", filepath.Base(exe), sha256.Sum256(b))
+	return err
+}
+
+// config is the part of the configuration go vet writes for each package
+// that csvvet uses.
+type config struct {
+	ID         string // the package's ID, which keys JSON output
+	GoFiles    []string
+	Stdout     string // where to write JSON output, if set
+	VetxOnly   bool   // only facts about the package are wanted
+	VetxOutput string // where to write facts about the package
+}
+
+// vet checks the package described by the go vet configuration file cfg.
+// With jsonOut, mistakes are written as JSON, in the form go vet reads, and
+// aren't an error.
+func vet(cfg string, jsonOut bool, stdout, stderr io.Writer) error {
+	b, err := os.ReadFile(cfg)
+	if err != nil {
+		return err
+	}
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("reading %s: %v", cfg, err)
+	}
+	// csvvet records no facts, but go vet expects the file to exist.
+	if c.VetxOutput != "" {
+		if err := os.WriteFile(c.VetxOutput, nil, 0666); err != nil {
+			return err
+		}
+	}
+	if c.VetxOnly {
+		return nil
+	}
+	if !jsonOut {
+		return check(c.GoFiles, stderr)
+	}
+	fset, diags, err := parseAndCheck(c.GoFiles)
+	if err != nil {
+		return err
+	}
+	type diagnostic struct {
+		Posn    string `json:"posn"`
+		Message string `json:"message"`
+	}
+	out := []diagnostic{}
+	for _, d := range diags {
+		out = append(out, diagnostic{fset.Position(d.Pos).String(), d.Message})
+	}
+	b, err = json.Marshal(map[string]map[string][]diagnostic{c.ID: {"csvtag": out}})
+	if err != nil {
+		return err
+	}
+	if c.Stdout != "" {
+		return os.WriteFile(c.Stdout, b, 0666)
+	}
+	_, err = stdout.Write(b)
+	return err
+}
+
+// check parses files and reports the mistakes in them to stderr.
+func check(files []string, stderr io.Writer) error {
+	fset, diags, err := parseAndCheck(files)
+	if err != nil {
+		return err
+	}
+	for _, d := range diags {
+		fmt.Fprintf(stderr, "%s: %s\n", fset.Position(d.Pos), d.Message)
+	}
+	if len(diags) > 0 {
+		return errFound
+	}
+	return nil
+}
+
+// parseAndCheck parses files and returns the mistakes in them.
+func parseAndCheck(files []string) (*token.FileSet, []tagcheck.Diagnostic, error) {
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	for _, name := range files {
+		f, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, nil, err
+		}
+		parsed = append(parsed, f)
+	}
+	return fset, tagcheck.Check(parsed), nil
+}
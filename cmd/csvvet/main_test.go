@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.go")
+	bad := filepath.Join(dir, "bad.go")
+	os.WriteFile(good, []byte("package p\n\ntype A struct {\n\tX int `csv:\"x\"`\n}\n"), 0666)
+	os.WriteFile(bad, []byte("package p\n\ntype B struct {\n\tX int `csv:\"x\"`\n\tY int `csv:\"x\"`\n}\n"), 0666)
+
+	var stderr bytes.Buffer
+	if err := run([]string{good}, nil, &stderr); err != nil {
+		t.Errorf("run(good): %v, %s", err, stderr.String())
+	}
+	if err := run([]string{dir}, nil, &stderr); err != errFound {
+		t.Errorf("run(dir): got %v, want errFound", err)
+	}
+	if want := `bad.go:5:2: field Y: duplicate csv column "x", also used by X`; !strings.Contains(stderr.String(), want) {
+		t.Errorf("got %q, want it to contain %q", stderr.String(), want)
+	}
+
+	// As a vet tool, csvvet reads the package's files from a config file.
+	vetx := filepath.Join(dir, "vetx")
+	cfg := filepath.Join(dir, "vet.cfg")
+	b, _ := json.Marshal(config{GoFiles: []string{bad}, VetxOutput: vetx})
+	os.WriteFile(cfg, b, 0666)
+	stderr.Reset()
+	if err := run([]string{cfg}, nil, &stderr); err != errFound {
+		t.Errorf("run(cfg): got %v, want errFound", err)
+	}
+	if _, err := os.Stat(vetx); err != nil {
+		t.Errorf("vetx output not written: %v", err)
+	}
+
+	// Recent versions of go vet have the tool write JSON to a file.
+	out := filepath.Join(dir, "vet.stdout")
+	b, _ = json.Marshal(config{ID: "p", GoFiles: []string{bad}, Stdout: out})
+	os.WriteFile(cfg, b, 0666)
+	if err := run([]string{"-json", cfg}, nil, nil); err != nil {
+		t.Errorf("run(-json cfg): %v", err)
+	}
+	var tree map[string]map[string][]struct{ Posn, Message string }
+	if b, err := os.ReadFile(out); err != nil {
+		t.Errorf("reading JSON output: %v", err)
+	} else if err := json.Unmarshal(b, &tree); err != nil {
+		t.Errorf("parsing JSON output %s: %v", b, err)
+	} else if d := tree["p"]["csvtag"]; len(d) != 1 || !strings.HasSuffix(d[0].Posn, "bad.go:5:2") {
+		t.Errorf("got JSON diagnostics %+v", d)
+	}
+
+	var stdout bytes.Buffer
+	if err := run([]string{"-flags"}, &stdout, nil); err != nil || stdout.String() != "[]\n" {
+		t.Errorf("run(-flags): got %q, %v", stdout.String(), err)
+	}
+}
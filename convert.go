@@ -0,0 +1,57 @@
+package csvstruct
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Convert maps each of rows to a D by matching the columns of S and D, as
+// named by their csv tags or field names.
+//
+// Values are converted through their CSV representation, so a row converts
+// exactly as if it were encoded and then decoded: an int field may populate
+// a string field, and vice versa if the string holds a number. Columns of D
+// with no counterpart in S are left zero.
+func Convert[S, D any](rows []S) ([]D, error) {
+	st := reflect.TypeOf((*S)(nil)).Elem()
+	dt := reflect.TypeOf((*D)(nil)).Elem()
+	if st.Kind() != reflect.Struct || dt.Kind() != reflect.Struct {
+		return nil, errors.New("must convert between struct types")
+	}
+
+	sfs, err := structFields(st, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	fields := []field{}
+	for _, f := range sfs {
+		// Inline maps have no fixed columns.
+		if !f.inline {
+			fields = append(fields, f)
+		}
+	}
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.name
+	}
+	e := &encoder{}
+	d := &decoder{}
+	d.setHeader(header)
+
+	out := make([]D, len(rows))
+	line := make([]string, len(fields))
+	for i := range rows {
+		rv := reflect.ValueOf(&rows[i]).Elem()
+		for j, f := range fields {
+			s, err := e.format(rv.FieldByIndex(f.index))
+			if err != nil {
+				return nil, err
+			}
+			line[j] = s
+		}
+		if err := d.decodeStruct(&out[i], line); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
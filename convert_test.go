@@ -0,0 +1,37 @@
+package csvstruct
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	type vendor struct {
+		ID       int    `csv:"id"`
+		FullName string `csv:"name"`
+		Zip      string `csv:"zip"`
+		Internal string `csv:"-"`
+	}
+	type internal struct {
+		ID    string `csv:"id"`
+		Name  string `csv:"name"`
+		Zip   int    `csv:"zip"`
+		Notes string
+	}
+	in := []vendor{{1, "Alice", "02134", "x"}, {2, "Bob", "94043", "y"}}
+	got, err := Convert[vendor, internal](in)
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	want := []internal{{"1", "Alice", 2134, ""}, {"2", "Bob", 94043, ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Convert: got %v, want %v", got, want)
+	}
+
+	if _, err := Convert[internal, vendor]([]internal{{ID: "x"}}); err == nil {
+		t.Errorf("Convert: expected error converting %q to int", "x")
+	}
+	if _, err := Convert[int, internal]([]int{1}); err == nil {
+		t.Errorf("Convert: expected error for non-struct type")
+	}
+}
@@ -0,0 +1,346 @@
+// Package csvarrow converts between CSV and Apache Arrow records, so that
+// csvstruct can feed columnar analytics pipelines.
+//
+// A Reader parses the cells read by a csvstruct Decoder straight into the
+// typed column builders of an Arrow record, and a Writer formats the columns
+// of Arrow records into CSV rows, without boxing each value in an
+// interface{}. Columns of integer, floating point, boolean and string types
+// are supported. Empty cells are nulls, except in string columns, where they
+// are empty strings.
+package csvarrow
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ImJasonH/csvstruct"
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/memory"
+)
+
+// checkSchema returns an error if a field of s has an unsupported type.
+func checkSchema(s *arrow.Schema) error {
+	for _, f := range s.Fields() {
+		switch f.Type.ID() {
+		case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64,
+			arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64,
+			arrow.FLOAT32, arrow.FLOAT64, arrow.BOOL, arrow.STRING:
+		default:
+			return fmt.Errorf("column %q has unsupported type %v", f.Name, f.Type)
+		}
+	}
+	return nil
+}
+
+// Reader reads the rows of a Decoder as Arrow records of a given schema.
+// Each field of the schema is read from the column of the same name, which
+// must be in the header.
+//
+// Reader implements array.RecordReader.
+type Reader struct {
+	d      csvstruct.Decoder
+	schema *arrow.Schema
+	rows   int
+	refs   int64
+
+	b   *array.RecordBuilder
+	row map[string]string
+	rec array.Record
+	err error
+}
+
+var _ array.RecordReader = (*Reader)(nil)
+
+// NewReader returns a Reader that reads records of up to rows rows from d.
+func NewReader(d csvstruct.Decoder, schema *arrow.Schema, rows int) *Reader {
+	r := &Reader{d: d, schema: schema, rows: rows, refs: 1}
+	if err := checkSchema(schema); err != nil {
+		r.err = err
+	} else if rows < 1 {
+		r.err = errors.New("rows must be positive")
+	}
+	return r
+}
+
+// Schema returns the schema of the records read.
+func (r *Reader) Schema() *arrow.Schema { return r.schema }
+
+// Next reads the next record, which is then returned by Record. It returns
+// false at the end of the input or on error, after which Err returns the
+// error, if any.
+func (r *Reader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	if r.err != nil {
+		return false
+	}
+	if r.b == nil {
+		// Peek reads the header, which is then checked before any rows.
+		_, err := r.d.Peek()
+		header := r.d.Checkpoint().Header
+		if header == nil && errors.Is(err, io.EOF) {
+			// Empty input has no records.
+			return false
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			r.err = err
+			return false
+		}
+		have := map[string]bool{}
+		for _, h := range header {
+			have[h] = true
+		}
+		for _, f := range r.schema.Fields() {
+			if !have[f.Name] {
+				r.err = fmt.Errorf("missing column %q", f.Name)
+				return false
+			}
+		}
+		r.b = array.NewRecordBuilder(memory.NewGoAllocator(), r.schema)
+		r.row = map[string]string{}
+	}
+
+	n := 0
+	for ; n < r.rows; n++ {
+		for k := range r.row {
+			delete(r.row, k)
+		}
+		err := r.d.DecodeNext(&r.row)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			r.err = err
+			return false
+		}
+		for i, f := range r.schema.Fields() {
+			if err := appendCell(r.b.Field(i), r.row[f.Name]); err != nil {
+				r.err = fmt.Errorf("row %d: column %q: %v", r.d.Checkpoint().Row, f.Name, err)
+				return false
+			}
+		}
+	}
+	if n == 0 {
+		return false
+	}
+	r.rec = r.b.NewRecord()
+	return true
+}
+
+// Record returns the record read by the last call to Next. It is valid
+// until the next call to Next, unless retained.
+func (r *Reader) Record() array.Record { return r.rec }
+
+// Err returns the error that stopped Next, if any.
+func (r *Reader) Err() error { return r.err }
+
+// Retain increases the reference count of r by 1.
+func (r *Reader) Retain() {
+	atomic.AddInt64(&r.refs, 1)
+}
+
+// Release decreases the reference count of r by 1, releasing its memory
+// when the count reaches 0.
+func (r *Reader) Release() {
+	if atomic.AddInt64(&r.refs, -1) > 0 {
+		return
+	}
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	if r.b != nil {
+		r.b.Release()
+		r.b = nil
+	}
+}
+
+// appendCell parses cell and appends it to b.
+func appendCell(b array.Builder, cell string) error {
+	if b, ok := b.(*array.StringBuilder); ok {
+		b.Append(cell)
+		return nil
+	}
+	if cell == "" {
+		b.AppendNull()
+		return nil
+	}
+	switch b := b.(type) {
+	case *array.Int8Builder:
+		v, err := strconv.ParseInt(cell, 10, 8)
+		if err != nil {
+			return err
+		}
+		b.Append(int8(v))
+	case *array.Int16Builder:
+		v, err := strconv.ParseInt(cell, 10, 16)
+		if err != nil {
+			return err
+		}
+		b.Append(int16(v))
+	case *array.Int32Builder:
+		v, err := strconv.ParseInt(cell, 10, 32)
+		if err != nil {
+			return err
+		}
+		b.Append(int32(v))
+	case *array.Int64Builder:
+		v, err := strconv.ParseInt(cell, 10, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.Uint8Builder:
+		v, err := strconv.ParseUint(cell, 10, 8)
+		if err != nil {
+			return err
+		}
+		b.Append(uint8(v))
+	case *array.Uint16Builder:
+		v, err := strconv.ParseUint(cell, 10, 16)
+		if err != nil {
+			return err
+		}
+		b.Append(uint16(v))
+	case *array.Uint32Builder:
+		v, err := strconv.ParseUint(cell, 10, 32)
+		if err != nil {
+			return err
+		}
+		b.Append(uint32(v))
+	case *array.Uint64Builder:
+		v, err := strconv.ParseUint(cell, 10, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.Float32Builder:
+		v, err := strconv.ParseFloat(cell, 32)
+		if err != nil {
+			return err
+		}
+		b.Append(float32(v))
+	case *array.Float64Builder:
+		v, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.BooleanBuilder:
+		v, err := strconv.ParseBool(cell)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	default:
+		return fmt.Errorf("unsupported builder %T", b)
+	}
+	return nil
+}
+
+// Writer writes Arrow records of a given schema as CSV rows, with a header
+// listing the schema's fields in order.
+type Writer struct {
+	w      *csv.Writer
+	schema *arrow.Schema
+	header bool // whether the header has been written
+	row    []string
+	err    error
+}
+
+// NewWriter returns a Writer that writes records to w. The header is
+// written with the first record.
+func NewWriter(w *csv.Writer, schema *arrow.Schema) *Writer {
+	return &Writer{
+		w:      w,
+		schema: schema,
+		row:    make([]string, len(schema.Fields())),
+		err:    checkSchema(schema),
+	}
+}
+
+// Write writes the rows of rec, whose schema must be the Writer's.
+func (w *Writer) Write(rec array.Record) error {
+	if w.err != nil {
+		return w.err
+	}
+	if !rec.Schema().Equal(w.schema) {
+		return fmt.Errorf("record schema %v does not match %v", rec.Schema(), w.schema)
+	}
+	if !w.header {
+		for i, f := range w.schema.Fields() {
+			w.row[i] = f.Name
+		}
+		if err := w.write(); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	cols := rec.Columns()
+	for i := 0; i < int(rec.NumRows()); i++ {
+		for j, col := range cols {
+			w.row[j] = formatCell(col, i)
+		}
+		if err := w.write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes the current row, keeping any error.
+func (w *Writer) write() error {
+	if err := w.w.Write(w.row); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// Flush writes any buffered rows to the underlying io.Writer.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// formatCell returns the value of the ith row of col as a cell.
+func formatCell(col array.Interface, i int) string {
+	if col.IsNull(i) {
+		return ""
+	}
+	switch col := col.(type) {
+	case *array.Int8:
+		return strconv.FormatInt(int64(col.Value(i)), 10)
+	case *array.Int16:
+		return strconv.FormatInt(int64(col.Value(i)), 10)
+	case *array.Int32:
+		return strconv.FormatInt(int64(col.Value(i)), 10)
+	case *array.Int64:
+		return strconv.FormatInt(col.Value(i), 10)
+	case *array.Uint8:
+		return strconv.FormatUint(uint64(col.Value(i)), 10)
+	case *array.Uint16:
+		return strconv.FormatUint(uint64(col.Value(i)), 10)
+	case *array.Uint32:
+		return strconv.FormatUint(uint64(col.Value(i)), 10)
+	case *array.Uint64:
+		return strconv.FormatUint(col.Value(i), 10)
+	case *array.Float32:
+		return strconv.FormatFloat(float64(col.Value(i)), 'g', -1, 32)
+	case *array.Float64:
+		return strconv.FormatFloat(col.Value(i), 'g', -1, 64)
+	case *array.Boolean:
+		return strconv.FormatBool(col.Value(i))
+	case *array.String:
+		return col.Value(i)
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+package csvarrow
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/ImJasonH/csvstruct"
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/memory"
+)
+
+var schema = arrow.NewSchema([]arrow.Field{
+	{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+	{Name: "age", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+	{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	{Name: "ok", Type: arrow.FixedWidthTypes.Boolean, Nullable: true},
+}, nil)
+
+const people = "name,age,score,ok\nAlice,30,9.5,true\nBob,,7,false\n,25,,\n"
+
+func TestReader(t *testing.T) {
+	r := NewReader(csvstruct.NewDecoder(strings.NewReader(people)), schema, 2)
+	defer r.Release()
+
+	var got []string
+	for r.Next() {
+		rec := r.Record()
+		got = append(got, rowsOf(rec)...)
+		if rec.NumRows() > 2 {
+			t.Errorf("record has %d rows, want at most 2", rec.NumRows())
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	want := []string{
+		"Alice 30 9.5 true",
+		"Bob (null) 7 false",
+		" 25 (null) (null)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got rows %q, want %q", got, want)
+	}
+}
+
+func TestReader_Errors(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		schema *arrow.Schema
+		want   string
+	}{{
+		"name,age,score,ok\nAlice,old,9.5,true\n",
+		schema,
+		`row 1: column "age": strconv.ParseInt: parsing "old": invalid syntax`,
+	}, {
+		"name,age,score,ok\nAlice,300,9.5,true\n",
+		arrow.NewSchema([]arrow.Field{{Name: "age", Type: arrow.PrimitiveTypes.Int8}}, nil),
+		`row 1: column "age": strconv.ParseInt: parsing "300": value out of range`,
+	}, {
+		"name,age\nAlice,30\n",
+		schema,
+		`missing column "score"`,
+	}, {
+		// Missing columns are reported without any rows.
+		"name,age\n",
+		schema,
+		`missing column "score"`,
+	}, {
+		"day\n2021-01-01\n",
+		arrow.NewSchema([]arrow.Field{{Name: "day", Type: arrow.FixedWidthTypes.Date32}}, nil),
+		`column "day" has unsupported type date32`,
+	}} {
+		r := NewReader(csvstruct.NewDecoder(strings.NewReader(c.in)), c.schema, 10)
+		if r.Next() {
+			t.Errorf("Next(%q): got a record, want error", c.in)
+		}
+		if err := r.Err(); err == nil || err.Error() != c.want {
+			t.Errorf("Next(%q): got error %v, want %q", c.in, err, c.want)
+		}
+		r.Release()
+	}
+}
+
+func TestWriter(t *testing.T) {
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer b.Release()
+	b.Field(0).(*array.StringBuilder).AppendValues([]string{"Alice", "Bob", ""}, nil)
+	b.Field(1).(*array.Int32Builder).AppendValues([]int32{30, 0, 25}, []bool{true, false, true})
+	b.Field(2).(*array.Float64Builder).AppendValues([]float64{9.5, 7, 0}, []bool{true, true, false})
+	b.Field(3).(*array.BooleanBuilder).AppendValues([]bool{true, false, false}, []bool{true, true, false})
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	w := NewWriter(csv.NewWriter(&buf), schema)
+	if err := w.Write(rec); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := buf.String(); got != people {
+		t.Errorf("got %q, want %q", got, people)
+	}
+
+	// Reading the output back gives the same record.
+	r := NewReader(csvstruct.NewDecoder(&buf), schema, 10)
+	defer r.Release()
+	if !r.Next() {
+		t.Fatalf("Next: %v", r.Err())
+	}
+	if !array.RecordEqual(r.Record(), rec) {
+		t.Errorf("read back %q, want %q", rowsOf(r.Record()), rowsOf(rec))
+	}
+}
+
+func TestWriter_SchemaMismatch(t *testing.T) {
+	other := arrow.NewSchema([]arrow.Field{{Name: "name", Type: arrow.BinaryTypes.String}}, nil)
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), other)
+	defer b.Release()
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	if err := NewWriter(csv.NewWriter(&buf), schema).Write(rec); err == nil {
+		t.Errorf("Write: expected error for a record of another schema")
+	}
+}
+
+// rowsOf returns the rows of rec, with the values of each row separated by
+// spaces.
+func rowsOf(rec array.Record) []string {
+	rows := make([]string, rec.NumRows())
+	for i := range rows {
+		var cells []string
+		for _, col := range rec.Columns() {
+			if col.IsNull(i) {
+				cells = append(cells, "(null)")
+			} else {
+				cells = append(cells, formatCell(col, i))
+			}
+		}
+		rows[i] = strings.Join(cells, " ")
+	}
+	return rows
+}
@@ -0,0 +1,206 @@
+// Package csvproto encodes protocol buffer messages as CSV rows and decodes
+// CSV rows into messages, using protoreflect, so that messages can be
+// exported and imported without mirror structs.
+//
+// Each column holds a field of the message, named by its name in the .proto
+// file or by its JSON name. Fields are encoded in the order they're declared
+// in. Only singular fields of scalar, string, bytes and enum types are
+// encoded; message, repeated and map fields are left out. Bytes are base64
+// encoded, and enums are written by the names of their values.
+package csvproto
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+
+	"github.com/ImJasonH/csvstruct"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Options configure the columns of an Encoder or Decoder.
+type Options struct {
+	// JSONNames names columns by the JSON names of fields (e.g., "userId"),
+	// rather than their names in the .proto file (e.g., "user_id").
+	JSONNames bool
+}
+
+// name returns the column name of fd.
+func (o Options) name(fd protoreflect.FieldDescriptor) string {
+	if o.JSONNames {
+		return fd.JSONName()
+	}
+	return string(fd.Name())
+}
+
+// fields returns the fields of md that are encoded, in order.
+func fields(md protoreflect.MessageDescriptor) []protoreflect.FieldDescriptor {
+	var fds []protoreflect.FieldDescriptor
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		if fd.IsList() || fd.IsMap() || fd.Message() != nil {
+			continue
+		}
+		fds = append(fds, fd)
+	}
+	return fds
+}
+
+// Encoder encodes messages of a single type as CSV rows.
+type Encoder struct {
+	w    *csv.Writer
+	opts Options
+
+	md  protoreflect.MessageDescriptor
+	fds []protoreflect.FieldDescriptor
+	row []string
+}
+
+// NewEncoder returns an Encoder that writes to w. The header is written with
+// the first message.
+func NewEncoder(w *csv.Writer, opts Options) *Encoder {
+	return &Encoder{w: w, opts: opts}
+}
+
+// Encode writes m as a row. Fields that are unset and track presence are
+// written as empty cells.
+func (e *Encoder) Encode(m proto.Message) error {
+	rm := m.ProtoReflect()
+	md := rm.Descriptor()
+	if e.md == nil {
+		e.md, e.fds = md, fields(md)
+		e.row = make([]string, len(e.fds))
+		for i, fd := range e.fds {
+			e.row[i] = e.opts.name(fd)
+		}
+		if err := e.w.Write(e.row); err != nil {
+			return err
+		}
+	} else if md.FullName() != e.md.FullName() {
+		return fmt.Errorf("can't encode %s after %s", md.FullName(), e.md.FullName())
+	}
+	for i, fd := range e.fds {
+		e.row[i] = ""
+		if !fd.HasPresence() || rm.Has(fd) {
+			e.row[i] = format(fd, rm.Get(fd))
+		}
+	}
+	return e.w.Write(e.row)
+}
+
+// Flush writes any buffered rows to the underlying io.Writer.
+func (e *Encoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// format returns v, the value of field fd, as a cell.
+func format(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return strconv.FormatBool(v.Bool())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(v.Int(), 10)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.FormatUint(v.Uint(), 10)
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	}
+	return ""
+}
+
+// Decoder decodes CSV rows into messages.
+type Decoder struct {
+	d    csvstruct.Decoder
+	opts Options
+	row  map[string]string
+}
+
+// NewDecoder returns a Decoder that reads rows from d.
+func NewDecoder(d csvstruct.Decoder, opts Options) *Decoder {
+	return &Decoder{d: d, opts: opts, row: map[string]string{}}
+}
+
+// Decode resets m and decodes the next row into it. Fields whose columns
+// are missing or whose cells are empty are left unset. At the end of the
+// input, Decode returns io.EOF.
+func (d *Decoder) Decode(m proto.Message) error {
+	for k := range d.row {
+		delete(d.row, k)
+	}
+	if err := d.d.DecodeNext(&d.row); err != nil {
+		return err
+	}
+	proto.Reset(m)
+	rm := m.ProtoReflect()
+	for _, fd := range fields(rm.Descriptor()) {
+		name := d.opts.name(fd)
+		cell := d.row[name]
+		if cell == "" {
+			continue
+		}
+		v, err := parse(fd, cell)
+		if err != nil {
+			return fmt.Errorf("row %d: column %q: %v", d.d.Checkpoint().Row, name, err)
+		}
+		rm.Set(fd, v)
+	}
+	return nil
+}
+
+// parse returns the value of field fd written as cell.
+func parse(fd protoreflect.FieldDescriptor, cell string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(cell)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(cell, 10, 32)
+		return protoreflect.ValueOfInt32(int32(i)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(cell, 10, 64)
+		return protoreflect.ValueOfInt64(i), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		u, err := strconv.ParseUint(cell, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(u)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		u, err := strconv.ParseUint(cell, 10, 64)
+		return protoreflect.ValueOfUint64(u), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(cell, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(cell, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(cell), nil
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(cell)
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(cell)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(cell, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("unknown %s value %q", fd.Enum().FullName(), cell)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %v", fd.Kind())
+}
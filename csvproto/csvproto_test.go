@@ -0,0 +1,132 @@
+package csvproto
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ImJasonH/csvstruct"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/typepb"
+)
+
+var testFields = []*typepb.Field{{
+	Kind:        typepb.Field_TYPE_STRING,
+	Cardinality: typepb.Field_CARDINALITY_OPTIONAL,
+	Number:      1,
+	Name:        "user_id",
+	JsonName:    "userId",
+	Options:     []*typepb.Option{{Name: "deprecated"}}, // left out
+}, {
+	Kind:         typepb.Field_TYPE_INT64,
+	Cardinality:  typepb.Field_CARDINALITY_REPEATED,
+	Number:       2,
+	Name:         "scores",
+	Packed:       true,
+	DefaultValue: "a, \"b\"",
+}}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		opts Options
+		want string
+	}{{
+		Options{},
+		"kind,cardinality,number,name,type_url,oneof_index,packed,json_name,default_value\n" +
+			"TYPE_STRING,CARDINALITY_OPTIONAL,1,user_id,,0,false,userId,\n" +
+			"TYPE_INT64,CARDINALITY_REPEATED,2,scores,,0,true,,\"a, \"\"b\"\"\"\n",
+	}, {
+		Options{JSONNames: true},
+		"kind,cardinality,number,name,typeUrl,oneofIndex,packed,jsonName,defaultValue\n" +
+			"TYPE_STRING,CARDINALITY_OPTIONAL,1,user_id,,0,false,userId,\n" +
+			"TYPE_INT64,CARDINALITY_REPEATED,2,scores,,0,true,,\"a, \"\"b\"\"\"\n",
+	}} {
+		var buf bytes.Buffer
+		e := NewEncoder(csv.NewWriter(&buf), c.opts)
+		for _, f := range testFields {
+			if err := e.Encode(f); err != nil {
+				t.Fatalf("Encode(%v): %v", f, err)
+			}
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.opts, got, c.want)
+		}
+
+		d := NewDecoder(csvstruct.NewDecoder(&buf), c.opts)
+		for _, want := range testFields {
+			got := &typepb.Field{Options: []*typepb.Option{{Name: "stale"}}}
+			if err := d.Decode(got); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			want = proto.Clone(want).(*typepb.Field)
+			want.Options = nil
+			if !proto.Equal(got, want) {
+				t.Errorf("%+v: decoded %v, want %v", c.opts, got, want)
+			}
+		}
+		if err := d.Decode(&typepb.Field{}); err != io.EOF {
+			t.Errorf("Decode at end: got %v, want EOF", err)
+		}
+	}
+}
+
+// Tests that unset fields with presence are written as empty cells, and that
+// empty cells leave fields unset.
+func TestPresence(t *testing.T) {
+	in := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String("id"),
+		Number: proto.Int32(0),
+		Type:   descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum(),
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(csv.NewWriter(&buf), Options{})
+	if err := e.Encode(in); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	want := "name,number,label,type,type_name,extendee,default_value,oneof_index,json_name,proto3_optional\n" +
+		"id,0,,TYPE_BYTES,,,,,,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	out := &descriptorpb.FieldDescriptorProto{}
+	if err := NewDecoder(csvstruct.NewDecoder(&buf), Options{}).Decode(out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !proto.Equal(out, in) {
+		t.Errorf("decoded %v, want %v", out, in)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(csv.NewWriter(&buf), Options{})
+	if err := e.Encode(&typepb.Field{}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if err := e.Encode(&typepb.Enum{}); err == nil {
+		t.Errorf("Encode of another message type: expected error")
+	}
+
+	for _, c := range []struct {
+		in, want string
+	}{
+		{"number\nx\n", `row 1: column "number": strconv.ParseInt: parsing "x": invalid syntax`},
+		{"kind\nTYPE_NONE\n", `row 1: column "kind": unknown google.protobuf.Field.Kind value "TYPE_NONE"`},
+		{"packed\nyes\n", `row 1: column "packed": strconv.ParseBool: parsing "yes": invalid syntax`},
+	} {
+		err := NewDecoder(csvstruct.NewDecoder(strings.NewReader(c.in)), Options{}).Decode(&typepb.Field{})
+		if err == nil || err.Error() != c.want {
+			t.Errorf("Decode(%q): got error %v, want %q", c.in, err, c.want)
+		}
+	}
+}
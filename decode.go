@@ -1,7 +1,47 @@
 // Package csvstruct provides methods to decode a CSV file into a struct.
+//
+// Each exported field of a struct maps to the column named by the first
+// element of its csv tag, or by the field's name. Fields tagged "-" are
+// skipped. The fields of an embedded struct, but not of an embedded pointer,
+// are treated as fields of the outer struct, at the embedded struct's place,
+// unless a field of the outer struct has the same column name. Columns are
+// ordered by the fields' "order=" weights, like "order=-1" or "order=10",
+// and then in declaration order; fields without a weight weigh zero.
+//
+// The other elements of a csv tag are options:
+//
+//   - "omitempty", on a pointer field, leaves it as it is when its cell is
+//     empty.
+//   - "inline", on a map field, stands for the columns named by its keys.
+//   - "allowdup" lets fields share a column name, mapping them to the
+//     columns with that name in order.
+//   - "mask" redacts the field's values when encoding, as described for
+//     EncodeOpts.Maskers.
+//   - "braced" and "upper" write UUIDs in braces or in upper case.
+//   - "onerror=zero" leaves the field zero when its cell can't be decoded,
+//     and "onerror=skiprow" skips the row instead; otherwise, or with
+//     "onerror=fail", DecodeNext returns an error.
+//   - "unit=", with a unit registered by RegisterUnit, like "unit=bytes",
+//     lets number fields accept cells with the unit's suffixes, like "3.5GB".
+//   - "lookup=", with the name of a lookup registered by RegisterLookup,
+//     writes and reads integer fields as labels; empty cells leave them
+//     unset.
+//   - "desc=" describes the column, for EncodeOpts.DescriptionRow.
+//   - "template=", which must come last, formats the field's cells on encode
+//     with a text/template executed on the whole row, like
+//     "template={{.Last}}, {{.First}}", or with a template registered by
+//     RegisterTemplate.
+//
+// Unknown options, an "order=" weight that isn't an integer and an unknown
+// "onerror=" value are errors. With ProtoNames set, untagged fields of
+// generated protobuf messages are named by their JSON names. A Mapping
+// overrides the tags of the fields it names.
 package csvstruct
 
 import (
+	"bufio"
+	"context"
+	"database/sql"
 	"encoding"
 	"encoding/csv"
 	"errors"
@@ -10,9 +50,37 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
-var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	scannerType         = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[reflect.Type]func(string) (interface{}, error){}
+)
+
+// RegisterDecoder registers fn to decode cells into fields of type t.
+//
+// The value returned by fn must be assignable or convertible to t. Registered
+// decoders take precedence over encoding.TextUnmarshaler and the built-in
+// conversions, which lets applications teach the Decoder about domain types
+// without wrapping every field.
+func RegisterDecoder(t reflect.Type, fn func(cell string) (interface{}, error)) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[t] = fn
+}
+
+func registeredDecoder(t reflect.Type) func(string) (interface{}, error) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	return decoders[t]
+}
 
 // Decoder reads and decodes CSV rows from an input stream.
 type Decoder interface {
@@ -22,34 +90,471 @@ type Decoder interface {
 	// On the first call to DecodeNext, the first row in the reader will be
 	// used as the header row to map CSV fields to struct fields, and the
 	// second row will be read to populate v.
+	//
+	// If v is a *map[string]string holding a map, the map is cleared and
+	// reused rather than a new one allocated for each row. Callers that keep
+	// rows must copy the map, or set *v to nil, before the next call.
 	DecodeNext(v interface{}) error
 
-	// Opts specifies options to modify decoding behavior.
+	// DecodeNextContext is like DecodeNext, but returns ctx.Err() without
+	// reading if ctx is done.
+	DecodeNextContext(ctx context.Context, v interface{}) error
+
+	// Opts specifies options to modify decoding behavior. Invalid
+	// options, or invalid combinations of them, cause DecodeNext to return
+	// an error.
 	//
 	// It returns the Decoder, to support chaining.
 	Opts(DecodeOpts) Decoder
+
+	// Checkpoint returns the Decoder's current position, from which
+	// decoding can later be resumed with ResumeDecoder.
+	Checkpoint() Checkpoint
+
+	// Reset discards the Decoder's state and starts decoding r, keeping
+	// the Decoder's options. This allows a Decoder to be reused.
+	Reset(r io.Reader)
+
+	// Rewind restarts decoding at the first data row. The input must
+	// implement io.Seeker.
+	Rewind() error
+
+	// Peek returns the next data row without consuming it, so that the
+	// following call to DecodeNext decodes the same row. The returned
+	// slice must not be modified.
+	Peek() ([]string, error)
+
+	// Header returns the header row, reading it if it hasn't been read
+	// yet, so that it is available even when the input has no data rows.
+	// The returned slice must not be modified.
+	Header() ([]string, error)
+
+	// Provenance returns the provenance recorded in the comment lines
+	// before the header, reading the header if it hasn't been read yet.
+	// DecodeOpts.Provenance must be set.
+	Provenance() (Provenance, error)
+
+	// LastRecord returns the cells of the data row last read by
+	// DecodeNext, as read, including rows that failed to decode, so that
+	// error handlers and audit logs can show the input. It returns nil
+	// before the first row. The slice must not be modified, and with
+	// ReuseRecord it is overwritten by the next row.
+	LastRecord() []string
+}
+
+// Checkpoint records a Decoder's position in its input.
+//
+// Offsets count bytes of the input as given to the Decoder, so they are only
+// meaningful for uncompressed UTF-8 input. For a Decoder returned by
+// NewMultiDecoder, Offset is relative to the input being read.
+type Checkpoint struct {
+	Offset int64    // byte offset of the next row
+	Row    int      // number of data rows read
+	Header []string // header row of the input
 }
 
 // DecodeOpts specifies options to modify decoding behavior.
 type DecodeOpts struct {
-	Comma            rune // field delimiter (set to ',' by default)
-	Comment          rune // comment character for start of line
-	LazyQuotes       bool // allow lazy quotes
-	TrimLeadingSpace bool // trim leading space
+	Comma            rune              // field delimiter (set to ',' by default)
+	Comment          rune              // comment character for start of line
+	LazyQuotes       bool              // allow lazy quotes
+	TrimLeadingSpace bool              // trim leading space
+	FieldsPerRecord  int               // number of fields per record (see csv.Reader)
+	Charset          Charset           // character encoding of input without a BOM (UTF8 by default)
+	InvalidUTF8      InvalidUTF8Policy // handling of invalid UTF-8 in cells
+
+	// RFC4180 checks the input strictly against RFC 4180, as Validate
+	// does, with Comma as the delimiter. DecodeNext returns the first
+	// violation as a *Violation, once the rows before it are decoded.
+	RFC4180 bool
+
+	// QuotedEmpty tells cells written as "" apart from empty cells, for
+	// pointer and sql.Scanner fields. Empty cells leave pointers nil, and
+	// are scanned as SQL NULL; "" sets *string fields to point to an
+	// empty string, and is scanned as an empty string, e.g. giving a
+	// valid sql.NullString. Other fields decode both alike.
+	QuotedEmpty bool
+
+	// UnknownBools lists cells, such as "unknown" or "N/A", that decode
+	// to nil for *bool fields, ignoring case, as empty cells do. This
+	// keeps "answered no" apart from "not answered".
+	UnknownBools []string
+
+	// Numbers sets which spellings of numbers are accepted for integer
+	// and float fields, as exported by spreadsheets and other messy
+	// sources.
+	Numbers NumberPolicy
+
+	// Rounding converts numbers with fractions for integer fields, which
+	// otherwise fail to decode. Setting it also accepts integers written
+	// as floats, like "3.0", as LenientNumbers does.
+	Rounding RoundingPolicy
+
+	// SampleEvery, if greater than one, decodes only every SampleEvery-th
+	// data row, starting with the first, for cheap sampling of very large
+	// inputs. The rows left out are still counted by Checkpoint, so row
+	// numbers match the input.
+	SampleEvery int
+
+	// ReuseRecord reuses the backing array of read records from row to
+	// row, as csv.Reader.ReuseRecord does, saving an allocation per row.
+	// Cells are immutable strings, so values decoded into structs and maps
+	// stay valid after later rows are read; only the slice of cells is
+	// overwritten, and Peek returns a copy of it. Independently of this
+	// option, a *map[string]string target holding a map is cleared and
+	// refilled by each call to DecodeNext, so rows kept across calls
+	// must be copied.
+	ReuseRecord bool
+
+	// BufferSize sets the size in bytes of the buffers input is read
+	// through, 4096 by default. Larger buffers suit very wide rows and
+	// high-latency streams. An input that is already a *bufio.Reader
+	// with a buffer at least this large is read from directly.
+	BufferSize int
+
+	// MaxUploadBytes limits the size of request bodies read by
+	// DecodeRequest, 32 MiB by default.
+	MaxUploadBytes int64
+
+	// Columns restricts decoding to the named columns. Values in other
+	// columns are never converted, which saves work when only a few
+	// fields are needed from a wide file. If empty, all columns are
+	// decoded.
+	Columns []string
+
+	// TypeOverrides forces the named columns to decode as the given Kind
+	// when the target is an interface{} value, instead of inferring the
+	// type from the cell's contents. Setting a column to KindString
+	// preserves values like ZIP codes with leading zeros.
+	TypeOverrides map[string]Kind
+
+	// Schema, if set, maps the columns it names to the struct fields or
+	// map keys given by their Field, decoding only those columns, and
+	// gives the Kind of each when the target is an interface{} value.
+	// TypeOverrides are keyed by Field, too. Required columns missing
+	// from the header, and empty cells in them, are an error.
+	Schema *Schema
+
+	// ZeroFields zeroes the fields of a struct that map to columns, and
+	// inline maps, before decoding each row into it. By default, a field
+	// is only set when its row has a cell for it, so when the same struct
+	// is reused across calls, short rows and empty cells of omitempty
+	// pointers leave values from earlier rows in place.
+	ZeroFields bool
+
+	// SkipRepeatedHeaders skips data rows identical to the header row, as
+	// occur when files with headers are concatenated.
+	SkipRepeatedHeaders bool
+
+	// ProtoNames maps columns to untagged fields of generated protobuf
+	// message structs by their JSON names (e.g., "userId" for user_id).
+	ProtoNames bool
+
+	// Mapping, if set, maps struct fields to columns in place of their csv
+	// tags.
+	Mapping *Mapping
+
+	// Stats, if set, collects statistics about the decoded columns as rows
+	// are read.
+	Stats *Stats
+
+	// Metrics, if set, receives counts of the rows, bytes and errors read.
+	Metrics Metrics
+
+	// OnSkip, if set, is called with a description of each piece of data
+	// the Decoder drops without returning an error, such as columns with no
+	// matching struct field.
+	OnSkip func(Skip)
+
+	// ArrayDelimiter separates the elements of array fields within a cell
+	// ("|" by default). Cells with fewer elements than the array leave the
+	// rest zero.
+	ArrayDelimiter string
+
+	// SchemaHash, if set, requires the input to begin with a schema line,
+	// as written by EncodeOpts.SchemaHash, holding this hash. Use the
+	// SchemaHash function to compute it from the row type.
+	SchemaHash string
+
+	// Provenance reads the comment lines written before the header by
+	// EncodeOpts.Provenance, which are then returned by
+	// Decoder.Provenance.
+	Provenance bool
+
+	// VerifyTrailer checks the trailer record written by
+	// EncodeOpts.Trailer at the end of the input against the rows read,
+	// returning a *TrailerError if they don't match or there is no
+	// trailer. With NewMultiDecoder, each input must end with its own.
+	VerifyTrailer bool
+}
+
+// Kind describes the type a CSV cell decodes to when the target is an
+// interface{} value.
+type Kind int
+
+const (
+	KindInfer  Kind = iota // infer the type from the cell's contents
+	KindString             // always decode as string
+	KindInt                // decode as int64
+	KindFloat              // decode as float64
+	KindBool               // decode as bool
+)
+
+var kindNames = [...]string{"infer", "string", "int", "float", "bool"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// MarshalText returns the name of k, as in a Schema.
+func (k Kind) MarshalText() ([]byte, error) {
+	if k < 0 || int(k) >= len(kindNames) {
+		return nil, fmt.Errorf("unknown Kind %d", int(k))
+	}
+	return []byte(kindNames[k]), nil
+}
+
+// UnmarshalText sets k to the Kind named by text, in any case.
+func (k *Kind) UnmarshalText(text []byte) error {
+	for i, n := range kindNames {
+		if strings.EqualFold(string(text), n) {
+			*k = Kind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown column type %q", text)
+}
+
+// NumberPolicy describes which spellings of numbers a Decoder accepts in
+// cells decoded into integer and float fields.
+type NumberPolicy int
+
+const (
+	StrictNumbers  NumberPolicy = iota // accept plain numbers, like "-12" and "1.5e3" for floats (the default)
+	LenientNumbers                     // also accept a leading "+", and floats with integer values, like "3.0" and "1e3", for integers
+)
+
+// InvalidUTF8Policy describes how a Decoder handles cells containing invalid
+// UTF-8 byte sequences.
+type InvalidUTF8Policy int
+
+const (
+	KeepInvalid    InvalidUTF8Policy = iota // pass invalid sequences through unchanged
+	RejectInvalid                           // return an error giving the position
+	ReplaceInvalid                          // replace each invalid run with U+FFFD
+	StripInvalid                            // remove invalid sequences
+)
+
+// peeked holds the result of reading a row ahead with Peek.
+type peeked struct {
+	line []string
+	err  error
+	off  int64 // Checkpoint offset before the row was read
 }
 
 type decoder struct {
-	r  csv.Reader
-	hm map[string]int
+	src      io.Reader
+	inputs   []io.Reader // all inputs, for NewMultiDecoder
+	more     []io.Reader // inputs to read after src
+	sr       *sniffReader
+	qr       *quoteReader // notes quoted empty cells, when QuotedEmpty is set
+	r        csv.Reader
+	quoted   []int            // columns of the last record read holding ""
+	buffered []bufferedRecord // records of a Section, read instead of r
+	nbuf     int              // number of buffered records read
+	base     int64            // offset in src at which reading started
+	rows     int              // number of data rows read
+	dropped  int              // rows skipped by SampleEvery since the last row consumed
+	peek     *peeked          // row read ahead by Peek, if any
+	counted  int64            // offset up to which bytes were reported to Metrics
+	skips    skipper
+	header   []string
+	last     []string // data row last read
+	hm       map[string]int
+	preamble map[string]string // comment lines before the header, by key
+	trail    *trailer          // rows read, when VerifyTrailer is set
+	types    map[string]Kind   // Kinds given by Schema, by Field
+	ftype    reflect.Type      // struct type of the last row decoded
+	fields   []field           // fields of ftype, reused from row to row
+	opts     DecodeOpts
+	err      error // sticky error from Opts or options
 }
 
-// NewDecoder returns a Decoder that reads from r.
-func NewDecoder(r io.Reader) Decoder {
-	csvr := csv.NewReader(r)
-	return &decoder{r: *csvr}
+// NewDecoder returns a Decoder that reads from r, configured by options.
+func NewDecoder(r io.Reader, options ...Option) Decoder {
+	d := &decoder{}
+	d.reset(r)
+	if len(options) > 0 {
+		var opts DecodeOpts
+		if err := applyDecode(&opts, options); err != nil {
+			d.err = err
+			return d
+		}
+		d.Opts(opts)
+	}
+	return d
+}
+
+// ReadHeader returns the header row of r, as read by a Decoder configured by
+// opts, e.g. to use an existing file's header as EncodeOpts.Columns.
+func ReadHeader(r io.Reader, opts DecodeOpts) ([]string, error) {
+	d := NewDecoder(r).Opts(opts).(*decoder)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := d.readHeader(); err != nil {
+		return nil, err
+	}
+	return d.header, nil
+}
+
+// ResumeDecoder returns a Decoder that continues decoding r from cp, which
+// was returned by an earlier Decoder's Checkpoint method.
+func ResumeDecoder(r io.ReadSeeker, cp Checkpoint) (Decoder, error) {
+	if _, err := r.Seek(cp.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	d := &decoder{}
+	d.reset(r)
+	d.base = cp.Offset
+	d.counted = cp.Offset
+	d.rows = cp.Row
+	if cp.Header != nil {
+		d.setHeader(cp.Header)
+	}
+	return d, nil
+}
+
+// NewMultiDecoder returns a Decoder that reads rows from each of the readers
+// in turn, as though they were one stream.
+//
+// Each reader must begin with a header row. Headers must name the same set of
+// columns, though not necessarily in the same order; DecodeNext returns an
+// error when it reaches an input whose header is incompatible.
+func NewMultiDecoder(readers ...io.Reader) Decoder {
+	if len(readers) == 0 {
+		return NewDecoder(strings.NewReader(""))
+	}
+	d := NewDecoder(readers[0]).(*decoder)
+	d.inputs = readers
+	d.more = readers[1:]
+	return d
 }
 
 func (d *decoder) Opts(opts DecodeOpts) Decoder {
+	if err := opts.validate(); err != nil {
+		d.err = err
+		return d
+	}
+	reread := opts.Charset != d.opts.Charset || opts.BufferSize != d.opts.BufferSize || opts.RFC4180 != d.opts.RFC4180 || opts.QuotedEmpty != d.opts.QuotedEmpty
+	if reread && d.r.InputOffset() > 0 {
+		// The input already read is buffered by the csv.Reader being
+		// replaced, so it can't be read again.
+		d.err = errors.New("Charset, BufferSize, RFC4180 and QuotedEmpty can't be changed after reading has started")
+		return d
+	}
+	d.opts = opts
+	d.skips.fn = opts.OnSkip
+	d.ftype, d.fields = nil, nil
+	d.types = nil
+	if opts.Schema != nil {
+		d.types = map[string]Kind{}
+		for _, c := range opts.Schema.Columns {
+			d.types[c.key()] = c.Type
+		}
+	}
+	if reread {
+		d.reset(d.src)
+	}
+	d.configure()
+	if d.header != nil {
+		d.setHeader(d.header)
+	}
+	return d
+}
+
+// reset starts reading from src.
+func (d *decoder) reset(src io.Reader) {
+	d.src = src
+	d.sr = newSniffReader(src, d.opts.Charset, d.opts.BufferSize)
+	var r io.Reader = d.sr
+	if d.opts.RFC4180 {
+		comma := d.opts.Comma
+		if comma == 0 {
+			comma = ','
+		}
+		r = newRFCReader(r, comma)
+	}
+	d.qr = nil
+	if d.opts.QuotedEmpty {
+		d.qr = newQuoteReader(r)
+		r = d.qr
+	}
+	if d.opts.BufferSize > 0 {
+		// csv.Reader uses a buffered Reader as it is, if it is large
+		// enough.
+		d.r = *csv.NewReader(bufio.NewReaderSize(r, d.opts.BufferSize))
+	} else {
+		d.r = *csv.NewReader(r)
+	}
+	d.trail = nil
+	d.configure()
+}
+
+func (d *decoder) Reset(r io.Reader) {
+	d.inputs, d.more = nil, nil
+	d.buffered = nil
+	d.restart(r)
+}
+
+func (d *decoder) Rewind() error {
+	inputs := d.inputs
+	if inputs == nil {
+		inputs = []io.Reader{d.src}
+	}
+	for _, in := range inputs {
+		s, ok := in.(io.Seeker)
+		if !ok {
+			return errors.New("input is not seekable")
+		}
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	d.more = inputs[1:]
+	d.restart(inputs[0])
+	return nil
+}
+
+// restart starts reading src from the beginning, discarding the header.
+func (d *decoder) restart(src io.Reader) {
+	d.header, d.hm = nil, nil
+	d.rows, d.dropped = 0, 0
+	d.peek = nil
+	d.last = nil
+	d.base = 0
+	d.nbuf = 0
+	d.reset(src)
+}
+
+func (d *decoder) Checkpoint() Checkpoint {
+	off := d.base + d.sr.skipped + d.r.InputOffset()
+	if d.peek != nil {
+		off = d.peek.off
+	}
+	return Checkpoint{
+		Offset: off,
+		Row:    d.rows,
+		Header: d.header,
+	}
+}
+
+// configure applies d.opts to the csv.Reader.
+func (d *decoder) configure() {
+	opts := d.opts
 	if opts.Comma != rune(0) {
 		d.r.Comma = opts.Comma
 	}
@@ -58,17 +563,56 @@ func (d *decoder) Opts(opts DecodeOpts) Decoder {
 	}
 	d.r.LazyQuotes = opts.LazyQuotes
 	d.r.TrimLeadingSpace = opts.TrimLeadingSpace
-	return d
+	d.r.FieldsPerRecord = opts.FieldsPerRecord
+	d.r.ReuseRecord = opts.ReuseRecord
+	if q := d.qr; q != nil {
+		q.comma, q.comment = d.r.Comma, d.r.Comment
+		q.lazy, q.trim = d.r.LazyQuotes, d.r.TrimLeadingSpace
+	}
+}
+
+func (d *decoder) DecodeNextContext(ctx context.Context, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.DecodeNext(v)
 }
 
 func (d *decoder) DecodeNext(v interface{}) error {
+	err := d.decodeNext(v)
+	for err == errSkipRow {
+		err = d.decodeNext(v)
+	}
+	if err != nil && err != io.EOF && d.opts.Metrics != nil {
+		d.opts.Metrics.Add(MetricDecodeErrors, 1)
+	}
+	return err
+}
+
+func (d *decoder) decodeNext(v interface{}) error {
 	line, err := d.read()
 	if err != nil {
 		return err
 	}
+	return d.decodeRow(v, line)
+}
 
+// decodeRow decodes the data row line into v.
+func (d *decoder) decodeRow(v interface{}, line []string) error {
 	// v is nil, skip this line and proceed.
 	if v == nil {
+		d.skips.skip(d.rows, "", SkipNilRow)
+		return nil
+	}
+
+	// Decode the common map[string]string case without reflection.
+	if m, ok := v.(*map[string]string); ok {
+		if *m == nil {
+			*m = make(map[string]string, len(d.hm))
+		} else {
+			clear(*m)
+		}
+		d.decodeStrings(*m, line)
 		return nil
 	}
 
@@ -88,122 +632,659 @@ func (d *decoder) DecodeNext(v interface{}) error {
 	}
 }
 func (d *decoder) decodeMap(v interface{}, line []string) error {
-	rv := reflect.ValueOf(v)
-	t := rv.Elem().Type()
+	mv := reflect.ValueOf(v).Elem()
+	t := mv.Type()
 	if t.Key().Kind() != reflect.String {
 		return errors.New("map key must be string")
 	}
-	switch t.Elem().Kind() {
-	case reflect.String:
-		m := *(v.(*map[string]string))
-		for hv, hidx := range d.hm {
-			m[hv] = line[hidx]
+	et := t.Elem()
+	if et.Kind() != reflect.String && (et.Kind() != reflect.Interface || et.NumMethod() > 0) {
+		return fmt.Errorf("can't decode type %v", et)
+	}
+	if mv.IsNil() {
+		return errors.New("can't decode into nil map")
+	}
+	for hv, hidx := range d.hm {
+		if hidx >= len(line) {
+			d.skips.skip(d.rows, hv, SkipShortRow)
+			continue
 		}
-	// TODO: Support arbitrary map values by parsing string values
-	case reflect.Interface:
-		return errors.New("TODO")
-	default:
-		return fmt.Errorf("can't decode type %v", t.Elem().Kind())
+		var iv interface{} = line[hidx]
+		if et.Kind() == reflect.Interface {
+			var err error
+			if iv, err = d.kindOf(hv).parse(line[hidx]); err != nil {
+				if d.opts.Stats != nil {
+					d.opts.Stats.fail(hv)
+				}
+				return fmt.Errorf("error decoding: %v", err)
+			}
+		}
+		mv.SetMapIndex(reflect.ValueOf(hv).Convert(t.Key()), reflect.ValueOf(iv).Convert(et))
 	}
 	return nil
 }
 
+// decodeStrings sets the decoded columns of m to the cells of line.
+func (d *decoder) decodeStrings(m map[string]string, line []string) {
+	for hv, hidx := range d.hm {
+		if hidx < len(line) {
+			m[hv] = line[hidx]
+		} else {
+			d.skips.skip(d.rows, hv, SkipShortRow)
+		}
+	}
+}
+
+// errSkipRow is returned by decodeStruct when a field tagged
+// "onerror=skiprow" can't be parsed, to have DecodeNext move on to the next
+// row.
+var errSkipRow = errors.New("skip row")
+
+// structFields returns the fields of the struct type t, computing them only
+// when the type of the row changes.
+func (d *decoder) structFields(t reflect.Type) ([]field, error) {
+	if t != d.ftype {
+		fields, err := structFields(t, d.opts.ProtoNames, d.opts.Mapping)
+		if err != nil {
+			return nil, err
+		}
+		d.ftype, d.fields = t, fields
+	}
+	return d.fields, nil
+}
+
 func (d *decoder) decodeStruct(v interface{}, line []string) error {
 	rv := reflect.ValueOf(v).Elem()
-	t := rv.Type()
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		if f.Anonymous {
+	fields, err := d.structFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	d.skips.mapping(rv.Type(), fields, d.hm, d.header)
+	if d.opts.ZeroFields {
+		for _, f := range fields {
+			if _, ok := d.hm[f.name]; ok || f.inline {
+				vf := rv.FieldByIndex(f.index)
+				if vf.CanSet() {
+					vf.Set(reflect.Zero(vf.Type()))
+				}
+			}
+		}
+	}
+	var seen map[string]int
+	for _, f := range fields {
+		if f.inline {
+			if err := d.decodeInline(rv.FieldByIndex(f.index), fields, line); err != nil {
+				return err
+			}
 			continue
 		}
-		n := f.Name
-		omitempty := false
-		if tag := f.Tag.Get("csv"); tag != "" {
-			parts := strings.Split(tag, ",")
-			if tagn := parts[0]; tag == "-" {
-				continue
-			} else if tagn != "" {
-				n = tagn
+		idx, ok := d.hm[f.name]
+		if f.allowdup {
+			if seen == nil {
+				seen = map[string]int{}
 			}
-			omitempty = len(parts) > 1 && parts[1] == "omitempty"
+			idx, ok = d.nthColumn(f.name, seen[f.name])
+			seen[f.name]++
 		}
-		idx, ok := d.hm[n]
 		if !ok {
 			// Unmapped header value
 			continue
 		}
-		strv := line[idx]
-		vf := rv.FieldByName(f.Name)
-		if vf.CanSet() {
-			if vf.CanInterface() && vf.Type().Implements(textUnmarshalerType) {
-				if vf.IsNil() {
-					vf.Set(reflect.New(vf.Type().Elem()))
-				}
-				if tu, ok := vf.Interface().(encoding.TextUnmarshaler); ok {
-					if err := tu.UnmarshalText([]byte(strv)); err != nil {
-						return err
-					}
-					continue
-				} else {
-					panic("unreachable")
-				}
+		if idx >= len(line) {
+			d.skips.skip(d.rows, f.name, SkipShortRow)
+			continue
+		}
+		if err := d.setStructField(rv.FieldByIndex(f.index), f, line[idx], d.quotedEmpty(idx)); err != nil {
+			if d.opts.Stats != nil {
+				d.opts.Stats.fail(f.name)
 			}
-			if vf.Kind() == reflect.Ptr {
-				if omitempty && strv == "" {
-					continue
-				}
-				if vf.IsNil() {
-					vf.Set(reflect.New(vf.Type().Elem()))
-				}
-				vf = vf.Elem()
+			switch f.onError {
+			case "zero":
+				vf := rv.FieldByIndex(f.index)
+				vf.Set(reflect.Zero(vf.Type()))
+				d.skips.skip(d.rows, f.name, SkipInvalidCell)
+				continue
+			case "skiprow":
+				d.skips.skip(d.rows, f.name, SkipInvalidRow)
+				return errSkipRow
 			}
+			return err
+		}
+	}
+	return nil
+}
 
-			switch vf.Kind() {
-			case reflect.String:
-				vf.SetString(strv)
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				i, err := strconv.ParseInt(strv, 10, 64)
-				if err != nil {
-					return fmt.Errorf("error decoding: %v", err)
-				}
-				vf.SetInt(i)
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				u, err := strconv.ParseUint(strv, 10, 64)
-				if err != nil {
-					return fmt.Errorf("error decoding: %v", err)
-				}
-				vf.SetUint(u)
-			case reflect.Float64:
-				f, err := strconv.ParseFloat(strv, 64)
-				if err != nil {
-					return fmt.Errorf("error decoding: %v", err)
-				}
-				vf.SetFloat(f)
-			case reflect.Bool:
-				b, err := strconv.ParseBool(strv)
-				if err != nil {
-					return fmt.Errorf("error decoding: %v", err)
-				}
-				vf.SetBool(b)
-			default:
-				return fmt.Errorf("can't decode type %v", vf.Type())
+// decodeInline fills the inline map mv with the cells of line in columns
+// that no other field of fields maps to.
+func (d *decoder) decodeInline(mv reflect.Value, fields []field, line []string) error {
+	et := mv.Type().Elem()
+	if et.Kind() != reflect.String && et.Kind() != reflect.Interface {
+		return fmt.Errorf("can't decode inline map of type %v", mv.Type())
+	}
+	fixed := map[string]bool{}
+	for _, f := range fields {
+		fixed[f.name] = true
+	}
+	if mv.IsNil() {
+		mv.Set(reflect.MakeMap(mv.Type()))
+	}
+	for i, h := range d.header {
+		if j, ok := d.hm[h]; !ok || j != i || fixed[h] {
+			continue
+		}
+		if i >= len(line) {
+			d.skips.skip(d.rows, h, SkipShortRow)
+			continue
+		}
+		var val reflect.Value
+		if et.Kind() == reflect.String {
+			val = reflect.ValueOf(line[i]).Convert(et)
+		} else {
+			iv, err := d.kindOf(h).parse(line[i])
+			if err != nil {
+				return fmt.Errorf("error decoding: %v", err)
+			}
+			val = reflect.ValueOf(&iv).Elem()
+		}
+		mv.SetMapIndex(reflect.ValueOf(h).Convert(mv.Type().Key()), val)
+	}
+	return nil
+}
+
+// nthColumn returns the index of the nth column of the header named name,
+// for fields tagged "allowdup".
+func (d *decoder) nthColumn(name string, n int) (int, bool) {
+	if _, ok := d.hm[name]; !ok {
+		// Not a decoded column.
+		return 0, false
+	}
+	for i, h := range d.header {
+		if h != name {
+			continue
+		}
+		if n == 0 {
+			return i, true
+		}
+		n--
+	}
+	return 0, false
+}
+
+// unknownBool reports whether s decodes to nil for a *bool field.
+func (d *decoder) unknownBool(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, u := range d.opts.UnknownBools {
+		if strings.EqualFold(s, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// setStructField sets the struct field vf, described by f, to strv, which
+// was written as "" if quoted is set.
+func (d *decoder) setStructField(vf reflect.Value, f field, strv string, quoted bool) error {
+	if d.opts.QuotedEmpty && strv == "" {
+		if ok, err := d.setEmpty(vf, quoted); ok {
+			return err
+		}
+	}
+	if l := lookupFor(f.lookup, vf.Type()); l != nil && vf.CanSet() {
+		if strv == "" {
+			// Encoders write nil codes as empty cells.
+			return nil
+		}
+		return d.setCode(l, vf, f.name, strv)
+	}
+	if table := registeredUnit(f.unit); table != nil && strv != "" {
+		return d.setUnitField(vf, f, table, strv)
+	}
+	return d.setField(vf, f.name, strv, f.omitempty)
+}
+
+// setField populates vf, the field mapped to column n, from the string strv.
+func (d *decoder) setField(vf reflect.Value, n, strv string, omitempty bool) error {
+	if !vf.CanSet() {
+		return nil
+	}
+	if fn := registeredDecoder(vf.Type()); fn != nil {
+		return setRegistered(vf, fn, strv)
+	}
+	if vf.Kind() == reflect.Ptr {
+		if omitempty && strv == "" {
+			return nil
+		}
+		if vf.Type().Elem().Kind() == reflect.Bool && d.unknownBool(strv) {
+			vf.Set(reflect.Zero(vf.Type()))
+			return nil
+		}
+		if vf.IsNil() {
+			vf.Set(reflect.New(vf.Type().Elem()))
+		}
+		vf = vf.Elem()
+		if fn := registeredDecoder(vf.Type()); fn != nil {
+			return setRegistered(vf, fn, strv)
+		}
+	}
+	// Fields are addressable, so this also finds UnmarshalText methods with
+	// pointer receivers on non-pointer fields (e.g., time.Time, net.IP).
+	if vf.Addr().Type().Implements(textUnmarshalerType) {
+		return vf.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(strv))
+	}
+	if vf.Addr().Type().Implements(scannerType) {
+		// Empty cells are scanned as SQL NULL.
+		var src interface{}
+		if strv != "" {
+			src = strv
+		}
+		if err := vf.Addr().Interface().(sql.Scanner).Scan(src); err != nil {
+			return fmt.Errorf("error decoding: %v", err)
+		}
+		return nil
+	}
+
+	switch vf.Kind() {
+	case reflect.Interface:
+		iv, err := d.kindOf(n).parse(strv)
+		if err != nil {
+			return fmt.Errorf("error decoding: %w", err)
+		}
+		vf.Set(reflect.ValueOf(iv))
+	case reflect.String:
+		vf.SetString(strv)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := d.parseInt(strv, vf.Type().Bits())
+		if err != nil {
+			return d.numberError(err, vf.Type(), n, strv)
+		}
+		vf.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := d.parseUint(strv, vf.Type().Bits())
+		if err != nil {
+			return d.numberError(err, vf.Type(), n, strv)
+		}
+		vf.SetUint(u)
+	case reflect.Float64:
+		f, err := d.parseFloat(strv)
+		if err != nil {
+			return d.numberError(err, vf.Type(), n, strv)
+		}
+		vf.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strv)
+		if err != nil {
+			return fmt.Errorf("error decoding: %w", err)
+		}
+		vf.SetBool(b)
+	case reflect.Array:
+		if isUUID(vf.Type()) {
+			return parseUUID(vf, strv)
+		}
+		if vf.Type().Elem().Kind() == reflect.Array {
+			return fmt.Errorf("can't decode nested array type %v", vf.Type())
+		}
+		vf.Set(reflect.Zero(vf.Type()))
+		if strv == "" {
+			return nil
+		}
+		parts := strings.Split(strv, arrayDelimiter(d.opts.ArrayDelimiter))
+		if len(parts) > vf.Len() {
+			return fmt.Errorf("error decoding: %d values for %v", len(parts), vf.Type())
+		}
+		for i, p := range parts {
+			if err := d.setField(vf.Index(i), n, p, false); err != nil {
+				return err
 			}
 		}
+	default:
+		return fmt.Errorf("can't decode type %v", vf.Type())
 	}
 	return nil
 }
 
+// arrayDelimiter returns delim, or the default array element delimiter if
+// it is empty.
+func arrayDelimiter(delim string) string {
+	if delim == "" {
+		return "|"
+	}
+	return delim
+}
+
+// setRegistered sets vf to the result of calling the registered decoder fn
+// on strv.
+func setRegistered(vf reflect.Value, fn func(string) (interface{}, error), strv string) error {
+	v, err := fn(strv)
+	if err != nil {
+		return fmt.Errorf("error decoding: %v", err)
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case !rv.IsValid():
+		vf.Set(reflect.Zero(vf.Type()))
+	case rv.Type().AssignableTo(vf.Type()):
+		vf.Set(rv)
+	case rv.Type().ConvertibleTo(vf.Type()):
+		vf.Set(rv.Convert(vf.Type()))
+	default:
+		return fmt.Errorf("registered decoder for %v returned %v", vf.Type(), rv.Type())
+	}
+	return nil
+}
+
+// kindOf returns the Kind that values in column n decode to.
+func (d *decoder) kindOf(n string) Kind {
+	if k, ok := d.opts.TypeOverrides[n]; ok {
+		return k
+	}
+	if k, ok := d.types[n]; ok {
+		return k
+	}
+	return KindInfer
+}
+
+// parse converts s to a value of kind k. If k is KindInfer, s is an int64 or
+// float64 if it is a finite decimal number, like "12" or "-1.5e3", a bool if
+// it is "true" or "false", and otherwise a string, so that text like "T" or
+// "NaN" stays as it is.
+func (k Kind) parse(s string) (interface{}, error) {
+	switch k {
+	case KindString:
+		return s, nil
+	case KindInt:
+		return strconv.ParseInt(s, 10, 64)
+	case KindFloat:
+		return strconv.ParseFloat(s, 64)
+	case KindBool:
+		return strconv.ParseBool(s)
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	if isDecimal(s) {
+		// Out of range numbers fail to parse, rather than becoming
+		// infinities.
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+	}
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return s, nil
+}
+
+// isDecimal reports whether s is spelled as a decimal number, with an
+// optional sign, fraction and exponent, as opposed to "Inf", "NaN" or a hex
+// float.
+func isDecimal(s string) bool {
+	digits := false
+	for i, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c == '+' || c == '-':
+			if i > 0 && s[i-1] != 'e' && s[i-1] != 'E' {
+				return false
+			}
+		case c != '.' && c != 'e' && c != 'E':
+			return false
+		}
+	}
+	return digits
+}
+
 func (d *decoder) read() ([]string, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
 	if d.hm == nil {
 		// First run; read header row
-		header, err := d.r.Read()
-		if err != nil {
-			return nil, fmt.Errorf("error reading headers: %v", err)
+		if err := d.readHeader(); err != nil {
+			return nil, err
 		}
-		d.hm = reverse(header)
 	}
 	// Read data row into []string
-	return d.r.Read()
+	var line []string
+	var err error
+	if d.peek != nil {
+		line, err = d.peek.line, d.peek.err
+		d.peek = nil
+	} else {
+		line, err = d.readSample()
+	}
+	d.rows += d.dropped
+	d.dropped = 0
+	if err == nil {
+		d.last = line
+		d.rows++
+		if d.opts.Stats != nil {
+			d.opts.Stats.observe(d.header, d.hm, line)
+		}
+		if m := d.opts.Metrics; m != nil {
+			m.Add(MetricRowsDecoded, 1)
+			off := d.Checkpoint().Offset
+			if off < d.counted {
+				// Reading restarted, or moved to the next input.
+				d.counted = d.base
+			}
+			m.Add(MetricBytesRead, off-d.counted)
+			d.counted = off
+		}
+		if d.opts.Schema != nil {
+			err = d.checkCells(line)
+		}
+	}
+	return line, err
+}
+
+func (d *decoder) LastRecord() []string {
+	return d.last
+}
+
+func (d *decoder) Header() ([]string, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.hm == nil {
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+	return d.header, nil
+}
+
+func (d *decoder) Peek() ([]string, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.hm == nil {
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+	if d.peek == nil {
+		off := d.Checkpoint().Offset
+		line, err := d.readSample()
+		if d.opts.ReuseRecord {
+			line = append([]string(nil), line...)
+		}
+		d.peek = &peeked{line, err, off}
+	}
+	return d.peek.line, d.peek.err
+}
+
+// readSample reads the next data row to be decoded, skipping those that
+// SampleEvery leaves out. The rows skipped are counted in d.dropped until
+// the row is consumed.
+func (d *decoder) readSample() ([]string, error) {
+	for {
+		line, err := d.readData()
+		n := d.opts.SampleEvery
+		if err != nil || n <= 1 || (d.rows+d.dropped)%n == 0 {
+			return line, err
+		}
+		d.dropped++
+	}
+}
+
+// readData reads the next data row, moving on to the next input at the end
+// of each input.
+func (d *decoder) readData() ([]string, error) {
+	for {
+		line, err := d.readRecord()
+		if d.opts.VerifyTrailer {
+			line, err = d.readTrailer(line, err)
+		}
+		if err == io.EOF && len(d.more) > 0 {
+			if err := d.nextInput(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err == nil && d.opts.SkipRepeatedHeaders && equal(line, d.header) {
+			d.skips.skip(0, "", SkipRepeatedHeader)
+			continue
+		}
+		if err == nil && d.opts.VerifyTrailer {
+			d.trail.add(line)
+		}
+		return line, err
+	}
+}
+
+// readHeader reads the header row and maps its columns.
+func (d *decoder) readHeader() error {
+	var header []string
+	var err error
+	if d.opts.SchemaHash != "" || d.opts.Provenance {
+		header, err = d.readPreamble()
+	} else {
+		header, err = d.readRecord()
+	}
+	if err != nil {
+		return fmt.Errorf("error reading headers: %w", err)
+	}
+	if d.opts.SchemaHash != "" {
+		if err := d.checkSchema(d.opts.SchemaHash); err != nil {
+			return err
+		}
+	}
+	d.setHeader(header)
+	if d.opts.Schema != nil {
+		return d.checkRequired()
+	}
+	return nil
+}
+
+// setHeader maps the columns of header.
+func (d *decoder) setHeader(header []string) {
+	// Copy the header, since the csv.Reader may reuse it.
+	d.header = append([]string(nil), header...)
+	d.hm = reverse(d.header)
+	if len(d.opts.Columns) > 0 {
+		d.hm = project(d.hm, d.opts.Columns)
+	}
+	if d.opts.Schema != nil {
+		d.hm = d.opts.Schema.project(d.hm)
+	}
+}
+
+// nextInput switches to the next reader given to NewMultiDecoder, checking
+// that its header is compatible with the first.
+func (d *decoder) nextInput() error {
+	prev := d.header
+	src := d.more[0]
+	d.more = d.more[1:]
+	d.base = 0
+	d.reset(src)
+	if err := d.readHeader(); err != nil {
+		return err
+	}
+	if !sameColumns(prev, d.header) {
+		return fmt.Errorf("incompatible headers: %v and %v", prev, d.header)
+	}
+	return nil
+}
+
+// equal reports whether a and b hold the same values in the same order.
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameColumns reports whether a and b name the same set of columns.
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := reverse(a)
+	for _, c := range b {
+		if _, ok := m[c]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// readRecord reads the next record, applying the InvalidUTF8 policy.
+func (d *decoder) readRecord() ([]string, error) {
+	if d.buffered != nil {
+		return d.readBuffered()
+	}
+	rec, err := d.r.Read()
+	if d.qr != nil && err == nil {
+		d.quoted = d.qr.quotedFields(d.r.InputOffset())
+	}
+	if err != nil || d.opts.InvalidUTF8 == KeepInvalid {
+		return rec, err
+	}
+	for i, v := range rec {
+		if utf8.ValidString(v) {
+			continue
+		}
+		switch d.opts.InvalidUTF8 {
+		case RejectInvalid:
+			line, col := d.r.FieldPos(i)
+			return nil, fmt.Errorf("invalid UTF-8 in field %d at line %d, column %d", i+1, line, col)
+		case ReplaceInvalid:
+			rec[i] = strings.ToValidUTF8(v, string(utf8.RuneError))
+		case StripInvalid:
+			rec[i] = strings.ToValidUTF8(v, "")
+		}
+	}
+	return rec, nil
+}
+
+// readBuffered reads the next buffered record of a Section. The InvalidUTF8
+// policy was applied when the section was read; FieldsPerRecord is checked
+// here, as csv.Reader would.
+func (d *decoder) readBuffered() ([]string, error) {
+	if d.nbuf == len(d.buffered) {
+		return nil, io.EOF
+	}
+	rec := d.buffered[d.nbuf]
+	d.nbuf++
+	d.quoted = rec.quoted
+	switch n := d.r.FieldsPerRecord; {
+	case n == 0:
+		d.r.FieldsPerRecord = len(rec.fields)
+	case n > 0 && len(rec.fields) != n:
+		return rec.fields, &csv.ParseError{StartLine: rec.line, Line: rec.line, Column: 1, Err: csv.ErrFieldCount}
+	}
+	return rec.fields, nil
 }
 
 func reverse(in []string) map[string]int {
@@ -213,3 +1294,14 @@ func reverse(in []string) map[string]int {
 	}
 	return m
 }
+
+// project returns the subset of hm whose keys are listed in cols.
+func project(hm map[string]int, cols []string) map[string]int {
+	m := make(map[string]int, len(cols))
+	for _, c := range cols {
+		if i, ok := hm[c]; ok {
+			m[c] = i
+		}
+	}
+	return m
+}
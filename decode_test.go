@@ -1,12 +1,18 @@
 package csvstruct
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"net"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 var ip = net.IPv4(128, 0, 0, 1)
@@ -204,6 +210,12 @@ $comment`,
 	}, {
 		DecodeOpts{TrimLeadingSpace: true},
 		"A,B,C\n  a,b,c\n\td,,f",
+	}, {
+		DecodeOpts{FieldsPerRecord: -1},
+		"A,B,C\na,b,c,extra\nd,,f",
+	}, {
+		DecodeOpts{ReuseRecord: true},
+		"A,B,C\na,b,c\nd,,f",
 	}} {
 		d := NewDecoder(strings.NewReader(c.s)).Opts(c.opts)
 		rows := []row{}
@@ -226,6 +238,327 @@ $comment`,
 	}
 }
 
+func TestDecode_OptsAfterRead(t *testing.T) {
+	type row struct{ A string }
+	for _, opts := range []DecodeOpts{
+		{Charset: Latin1},
+		{BufferSize: 1 << 16},
+		{RFC4180: true},
+		{QuotedEmpty: true},
+	} {
+		d := NewDecoder(strings.NewReader("A\na\nb\n"))
+		var r row
+		if err := d.DecodeNext(&r); err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		if err := d.Opts(opts).DecodeNext(&r); err == nil {
+			t.Errorf("Opts(%+v) after reading: expected error", opts)
+		}
+	}
+
+	// Before reading, the options apply to the whole input.
+	d := NewDecoder(strings.NewReader("A\n\"\"\n")).Opts(DecodeOpts{QuotedEmpty: true})
+	var r struct{ A *string }
+	if err := d.DecodeNext(&r); err != nil || r.A == nil {
+		t.Errorf("DecodeNext: got %v, %v, want a pointer to an empty string", r.A, err)
+	}
+}
+
+func TestDecode_Columns(t *testing.T) {
+	// Int would fail to decode, but it is not in the projection.
+	s := "Foo,Int,Bar\na,foo,b"
+	type row struct {
+		Foo, Bar string
+		Int      int
+	}
+	var r row
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{Columns: []string{"Foo", "Bar"}})
+	if err := d.DecodeNext(&r); err != nil {
+		t.Errorf("DecodeNext(%q): %v", s, err)
+	}
+	want := row{Foo: "a", Bar: "b"}
+	if r != want {
+		t.Errorf("DecodeNext(%q): got %v, want %v", s, r, want)
+	}
+
+	m := map[string]string{}
+	d = NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{Columns: []string{"Int"}})
+	if err := d.DecodeNext(&m); err != nil {
+		t.Errorf("DecodeNext(%q): %v", s, err)
+	}
+	if wantm := map[string]string{"Int": "foo"}; !reflect.DeepEqual(m, wantm) {
+		t.Errorf("DecodeNext(%q): got %v, want %v", s, m, wantm)
+	}
+}
+
+func TestDecode_FieldsPerRecord(t *testing.T) {
+	s := "A,B,C\na,b"
+	var r struct{ A, B, C string }
+	if err := NewDecoder(strings.NewReader(s)).DecodeNext(&r); err == nil {
+		t.Errorf("DecodeNext(%q): expected error", s)
+	}
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{FieldsPerRecord: -1})
+	if err := d.DecodeNext(&r); err != nil {
+		t.Errorf("DecodeNext(%q): %v", s, err)
+	}
+	if r.A != "a" || r.B != "b" || r.C != "" {
+		t.Errorf("DecodeNext(%q): got %v", s, r)
+	}
+}
+
+func TestMultiDecoder(t *testing.T) {
+	type row struct{ A, B string }
+	d := NewMultiDecoder(
+		strings.NewReader("A,B\na,b\nc,d"),
+		strings.NewReader("A,B\n"),
+		strings.NewReader("B,A\nf,e"))
+	rows := []row{}
+	for {
+		var r row
+		if err := d.DecodeNext(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		rows = append(rows, r)
+	}
+	if want := []row{{"a", "b"}, {"c", "d"}, {"e", "f"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+
+	d = NewMultiDecoder(strings.NewReader("A,B\na,b"), strings.NewReader("A,C\nc,d"))
+	var r row
+	if err := d.DecodeNext(&r); err != nil {
+		t.Errorf("DecodeNext: %v", err)
+	}
+	if err := d.DecodeNext(&r); err == nil || err == io.EOF {
+		t.Errorf("DecodeNext: expected incompatible header error, got %v", err)
+	}
+}
+
+func TestDecode_SkipRepeatedHeaders(t *testing.T) {
+	s := "A,B\na,b\nA,B\nc,d"
+	type row struct{ A, B string }
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{SkipRepeatedHeaders: true})
+	rows := []row{}
+	for {
+		var r row
+		if err := d.DecodeNext(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeNext(%q): %v", s, err)
+		}
+		rows = append(rows, r)
+	}
+	if want := []row{{"a", "b"}, {"c", "d"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("DecodeNext(%q): got %v, want %v", s, rows, want)
+	}
+}
+
+func TestDecode_Checkpoint(t *testing.T) {
+	type row struct{ A, B string }
+	for _, s := range []string{
+		"A,B\na,b\nc,\"d\nd\"\ne,f\ng,h\n",
+		"\xef\xbb\xbfA,B\na,b\nc,\"d\nd\"\ne,f\ng,h\n",
+	} {
+		d := NewDecoder(strings.NewReader(s))
+		var r row
+		for i := 0; i < 2; i++ {
+			if err := d.DecodeNext(&r); err != nil {
+				t.Fatalf("DecodeNext(%q): %v", s, err)
+			}
+		}
+		cp := d.Checkpoint()
+		if cp.Row != 2 {
+			t.Errorf("Checkpoint(%q): got row %d, want 2", s, cp.Row)
+		}
+
+		d, err := ResumeDecoder(strings.NewReader(s), cp)
+		if err != nil {
+			t.Fatalf("ResumeDecoder(%q): %v", s, err)
+		}
+		rows := []row{}
+		for {
+			if err := d.DecodeNext(&r); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("DecodeNext(%q) after resume: %v", s, err)
+			}
+			rows = append(rows, r)
+		}
+		if want := []row{{"e", "f"}, {"g", "h"}}; !reflect.DeepEqual(rows, want) {
+			t.Errorf("DecodeNext(%q) after resume: got %v, want %v", s, rows, want)
+		}
+		if got := d.Checkpoint(); got.Row != 4 || got.Offset != int64(len(s)) {
+			t.Errorf("Checkpoint(%q) at end: got %+v", s, got)
+		}
+
+		// Options that restart reading keep the offset resumed from.
+		for name, opts := range map[string]DecodeOpts{
+			"BufferSize":  {BufferSize: 16},
+			"QuotedEmpty": {QuotedEmpty: true},
+			"RFC4180":     {RFC4180: true},
+		} {
+			d, err := ResumeDecoder(strings.NewReader(s), cp)
+			if err != nil {
+				t.Fatalf("ResumeDecoder(%q): %v", s, err)
+			}
+			d = d.Opts(opts)
+			if err := d.DecodeNext(&r); err != nil {
+				t.Fatalf("DecodeNext(%q) after resume with %s: %v", s, name, err)
+			}
+			if r != (row{"e", "f"}) {
+				t.Errorf("DecodeNext(%q) after resume with %s: got %v", s, name, r)
+			}
+			if got, want := d.Checkpoint().Offset, int64(len(s)-len("g,h\n")); got != want {
+				t.Errorf("Checkpoint(%q) after resume with %s: got offset %d, want %d", s, name, got, want)
+			}
+		}
+	}
+}
+
+func TestDecode_ResetRewind(t *testing.T) {
+	type row struct{ A, B string }
+	decodeRows := func(d Decoder) []row {
+		rows := []row{}
+		for {
+			var r row
+			if err := d.DecodeNext(&r); err == io.EOF {
+				return rows
+			} else if err != nil {
+				t.Fatalf("DecodeNext: %v", err)
+			}
+			rows = append(rows, r)
+		}
+	}
+
+	d := NewDecoder(strings.NewReader("A;B\na;b")).Opts(DecodeOpts{Comma: ';'})
+	if got, want := decodeRows(d), []row{{"a", "b"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Options survive a Reset, but the header doesn't.
+	d.Reset(strings.NewReader("B;A\nc;d"))
+	if got, want := decodeRows(d), []row{{"d", "c"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after Reset: got %v, want %v", got, want)
+	}
+
+	d = NewMultiDecoder(strings.NewReader("A,B\na,b"), strings.NewReader("A,B\nc,d"))
+	first := decodeRows(d)
+	if err := d.Rewind(); err != nil {
+		t.Fatalf("Rewind: %v", err)
+	}
+	if again := decodeRows(d); !reflect.DeepEqual(first, again) || len(again) != 2 {
+		t.Errorf("after Rewind: got %v, want %v", again, first)
+	}
+
+	d = NewDecoder(bytes.NewBufferString("A,B\na,b"))
+	if err := d.Rewind(); err == nil {
+		t.Errorf("Rewind: expected error for unseekable input")
+	}
+}
+
+func TestDecode_Peek(t *testing.T) {
+	s := "A,B\na,b\nTOTAL,2"
+	type row struct{ A, B string }
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{ReuseRecord: true})
+	rows := []row{}
+	for {
+		rec, err := d.Peek()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Peek(%q): %v", s, err)
+		}
+		if rec[0] == "TOTAL" {
+			if cp := d.Checkpoint(); cp.Row != 1 || cp.Offset != int64(len("A,B\na,b\n")) {
+				t.Errorf("Checkpoint after Peek: got %+v", cp)
+			}
+			if err := d.DecodeNext(nil); err != nil {
+				t.Errorf("DecodeNext(nil): %v", err)
+			}
+			continue
+		}
+		var r row
+		if err := d.DecodeNext(&r); err != nil {
+			t.Fatalf("DecodeNext(%q): %v", s, err)
+		}
+		rows = append(rows, r)
+	}
+	if want := []row{{"a", "b"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+	if !isDone(d) {
+		t.Errorf("decoder unexpectedly not done")
+	}
+}
+
+func TestDecode_LastRecord(t *testing.T) {
+	type row struct {
+		A string
+		B int
+	}
+	d := NewDecoder(strings.NewReader("A,B\na,1\nb,x\n"))
+	if got := d.LastRecord(); got != nil {
+		t.Errorf("LastRecord before first row: got %q", got)
+	}
+	var r row
+	if err := d.DecodeNext(&r); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	if got, want := d.LastRecord(), []string{"a", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LastRecord: got %q, want %q", got, want)
+	}
+	if _, err := d.Peek(); err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+	if got, want := d.LastRecord(), []string{"a", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LastRecord after Peek: got %q, want %q", got, want)
+	}
+	// Rows that fail to decode are recorded, too.
+	if err := d.DecodeNext(&r); err == nil {
+		t.Fatalf("DecodeNext(%q): expected error", "b,x")
+	}
+	if got, want := d.LastRecord(), []string{"b", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LastRecord after error: got %q, want %q", got, want)
+	}
+}
+
+func TestDecode_Header(t *testing.T) {
+	d := NewDecoder(strings.NewReader("A,B\n"))
+	got, err := d.Header()
+	if err != nil {
+		t.Fatalf("Header: %v", err)
+	}
+	if want := []string{"A", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Header: got %q, want %q", got, want)
+	}
+	if err := d.DecodeNext(&map[string]string{}); err != io.EOF {
+		t.Errorf("DecodeNext: got %v, want EOF", err)
+	}
+
+	d = NewDecoder(strings.NewReader(""))
+	if _, err := d.Header(); err == nil {
+		t.Errorf("Header of empty input: expected error")
+	}
+}
+
+func TestDecode_Context(t *testing.T) {
+	s := "A\na\nb"
+	var r struct{ A string }
+	ctx, cancel := context.WithCancel(context.Background())
+	d := NewDecoder(strings.NewReader(s))
+	if err := d.DecodeNextContext(ctx, &r); err != nil {
+		t.Errorf("DecodeNextContext(%q): %v", s, err)
+	}
+	cancel()
+	if err := d.DecodeNextContext(ctx, &r); err != context.Canceled {
+		t.Errorf("DecodeNextContext(%q): got %v, want %v", s, err, context.Canceled)
+	}
+	if r.A != "a" {
+		t.Errorf("DecodeNextContext(%q): got %v", s, r)
+	}
+}
+
 func TestDecode_Map(t *testing.T) {
 	s := "foo,bar,baz\na,b,c"
 	want := map[string]string{
@@ -246,6 +579,77 @@ func TestDecode_Map(t *testing.T) {
 	}
 }
 
+func TestDecode_Interface(t *testing.T) {
+	s := "zip,n,f,b,s\n02134,123,1.5,true,foo"
+	want := map[string]interface{}{
+		"zip": "02134",
+		"n":   int64(123),
+		"f":   1.5,
+		"b":   true,
+		"s":   "foo",
+	}
+	got := map[string]interface{}{}
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{TypeOverrides: map[string]Kind{"zip": KindString}})
+	if err := d.DecodeNext(&got); err != nil {
+		t.Errorf("DecodeNext(%q): %v", s, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeNext(%q): got %v, want %v", s, got, want)
+	}
+
+	var r struct{ Zip, N interface{} }
+	d = NewDecoder(strings.NewReader("Zip,N\n02134,02134")).Opts(DecodeOpts{TypeOverrides: map[string]Kind{"Zip": KindString}})
+	if err := d.DecodeNext(&r); err != nil {
+		t.Errorf("DecodeNext: %v", err)
+	}
+	if r.Zip != "02134" || r.N != int64(2134) {
+		t.Errorf("DecodeNext: got %v", r)
+	}
+
+	d = NewDecoder(strings.NewReader("n\nfoo")).Opts(DecodeOpts{TypeOverrides: map[string]Kind{"n": KindInt}})
+	if err := d.DecodeNext(&got); err == nil {
+		t.Errorf("expected error")
+	}
+
+	// Only "true", "false" and finite decimal numbers are inferred.
+	for _, c := range []struct {
+		s    string
+		want interface{}
+	}{
+		{"true", true},
+		{"false", false},
+		{"-2", int64(-2)},
+		{"+1.5e3", 1500.0},
+		{".5", 0.5},
+		{"T", "T"},
+		{"F", "F"},
+		{"TRUE", "TRUE"},
+		{"Nan", "Nan"},
+		{"inf", "inf"},
+		{"-Infinity", "-Infinity"},
+		{"1e999", "1e999"},
+		{"0x1p-2", "0x1p-2"},
+		{"1_000", "1_000"},
+		{"1-2", "1-2"},
+	} {
+		got := map[string]interface{}{}
+		if err := NewDecoder(strings.NewReader("v\n" + c.s)).DecodeNext(&got); err != nil {
+			t.Errorf("DecodeNext(%q): %v", c.s, err)
+		} else if got["v"] != c.want {
+			t.Errorf("DecodeNext(%q): got %#v, want %#v", c.s, got["v"], c.want)
+		}
+	}
+
+	// Named map types decode too.
+	type row map[string]interface{}
+	r2 := row{}
+	if err := NewDecoder(strings.NewReader("n\n1")).DecodeNext(&r2); err != nil {
+		t.Errorf("DecodeNext(*row): %v", err)
+	} else if r2["n"] != int64(1) {
+		t.Errorf("DecodeNext(*row): got %v", r2)
+	}
+}
+
 func TestDecode_MapErrors(t *testing.T) {
 	d := NewDecoder(strings.NewReader("foo,bar\na,b"))
 
@@ -263,6 +667,16 @@ func TestDecode_MapErrors(t *testing.T) {
 	if err := d.DecodeNext(m2); err == nil {
 		t.Errorf("expected error")
 	}
+
+	var m3 map[string]interface{}
+	if err := NewDecoder(strings.NewReader("foo\na")).DecodeNext(&m3); err == nil || err == io.EOF {
+		t.Errorf("DecodeNext(nil map): got %v, want error", err)
+	}
+
+	m4 := map[string]fmt.Stringer{}
+	if err := NewDecoder(strings.NewReader("foo\na")).DecodeNext(&m4); err == nil || err == io.EOF {
+		t.Errorf("DecodeNext(map[string]fmt.Stringer): got %v, want error", err)
+	}
 }
 
 // Tests that values that implement encoding.TextUnarshaler are correctly unmarshaled.
@@ -278,6 +692,83 @@ func TestDecode_TextUnmarshaler(t *testing.T) {
 	}
 }
 
+// Tests that non-pointer fields whose pointer implements encoding.TextUnmarshaler
+// are unmarshaled in place.
+func TestDecode_TextUnmarshalerValue(t *testing.T) {
+	s := "N,T\n128.0.0.1,2015-03-23T12:00:00Z"
+	d := NewDecoder(strings.NewReader(s))
+	var r struct {
+		N net.IP
+		T time.Time
+	}
+	if err := d.DecodeNext(&r); err != nil {
+		t.Errorf("DecodeNext(%q): %v", s, err)
+	}
+	if !ip.Equal(r.N) {
+		t.Errorf("DecodeNext(%q): got %v want %v", s, r.N, ip)
+	}
+	if want := time.Date(2015, 3, 23, 12, 0, 0, 0, time.UTC); !r.T.Equal(want) {
+		t.Errorf("DecodeNext(%q): got %v want %v", s, r.T, want)
+	}
+}
+
+// Tests that fields implementing sql.Scanner are scanned, with empty cells
+// scanned as NULL.
+func TestDecode_Scanner(t *testing.T) {
+	s := "S,I,P\nfoo,,12"
+	d := NewDecoder(strings.NewReader(s))
+	var r struct {
+		S sql.NullString
+		I sql.NullInt64
+		P *sql.NullInt64
+	}
+	r.I = sql.NullInt64{Int64: 1, Valid: true}
+	if err := d.DecodeNext(&r); err != nil {
+		t.Errorf("DecodeNext(%q): %v", s, err)
+	}
+	if want := (sql.NullString{String: "foo", Valid: true}); r.S != want {
+		t.Errorf("DecodeNext(%q): got %v, want %v", s, r.S, want)
+	}
+	if r.I.Valid {
+		t.Errorf("DecodeNext(%q): got %v, want NULL", s, r.I)
+	}
+	if want := (sql.NullInt64{Int64: 12, Valid: true}); r.P == nil || *r.P != want {
+		t.Errorf("DecodeNext(%q): got %v, want %v", s, r.P, want)
+	}
+
+	s = "I\nfoo"
+	if err := NewDecoder(strings.NewReader(s)).DecodeNext(&r); err == nil {
+		t.Errorf("DecodeNext(%q): expected error", s)
+	}
+}
+
+type celsius float64
+
+// Tests that decoders registered with RegisterDecoder are used for their type.
+func TestDecode_RegisterDecoder(t *testing.T) {
+	RegisterDecoder(reflect.TypeOf(celsius(0)), func(cell string) (interface{}, error) {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(cell, "C"), 64)
+		return f, err
+	})
+	s := "Temp,Ptr\n21.5C,-3C"
+	d := NewDecoder(strings.NewReader(s))
+	var r struct {
+		Temp celsius
+		Ptr  *celsius
+	}
+	if err := d.DecodeNext(&r); err != nil {
+		t.Errorf("DecodeNext(%q): %v", s, err)
+	}
+	if r.Temp != 21.5 || r.Ptr == nil || *r.Ptr != -3 {
+		t.Errorf("DecodeNext(%q): got %v, %v", s, r.Temp, r.Ptr)
+	}
+
+	s = "Temp\nwarm"
+	if err := NewDecoder(strings.NewReader(s)).DecodeNext(&r); err == nil {
+		t.Errorf("DecodeNext(%q): expected error", s)
+	}
+}
+
 func isDone(d Decoder) bool {
 	return d.DecodeNext(nil) == io.EOF
 }
@@ -303,3 +794,198 @@ d,e,f`
 	// {a b c}
 	// {d e f}
 }
+
+func TestDecode_OnError(t *testing.T) {
+	type row struct {
+		Name  string
+		Age   int     `csv:"age,onerror=zero"`
+		Score float64 `csv:"score,onerror=skiprow"`
+		Rank  int     `csv:"rank,onerror=fail"`
+	}
+	in := "Name,age,score,rank\na,x,1.5,1\nb,2,bad,2\nc,3,2.5,3\nd,4,3.5,z\n"
+	var skips []Skip
+	d := NewDecoder(strings.NewReader(in)).Opts(DecodeOpts{OnSkip: func(s Skip) { skips = append(skips, s) }})
+	var got []row
+	var err error
+	for {
+		var r row
+		if err = d.DecodeNext(&r); err != nil {
+			break
+		}
+		got = append(got, r)
+	}
+	want := []row{{"a", 0, 1.5, 1}, {"c", 3, 2.5, 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err == nil || err == io.EOF {
+		t.Errorf("expected error decoding rank, got %v", err)
+	}
+	wantSkips := []Skip{{1, "age", SkipInvalidCell}, {2, "score", SkipInvalidRow}}
+	if !reflect.DeepEqual(skips, wantSkips) {
+		t.Errorf("got skips %v, want %v", skips, wantSkips)
+	}
+}
+
+func TestDecode_ReuseMap(t *testing.T) {
+	d := NewDecoder(strings.NewReader("A,B\na,b\nc\n")).Opts(DecodeOpts{FieldsPerRecord: -1})
+	var m map[string]string
+	if err := d.DecodeNext(&m); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	first := m
+	if err := d.DecodeNext(&m); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	if want := map[string]string{"A": "c"}; !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v, want %v", m, want)
+	}
+	if reflect.ValueOf(m).Pointer() != reflect.ValueOf(first).Pointer() {
+		t.Errorf("map was reallocated")
+	}
+}
+
+func TestDecode_ZeroFields(t *testing.T) {
+	type row struct {
+		A     string
+		B     *int `csv:"B,omitempty"`
+		Other string
+	}
+	in := "A,B\na,1\nb,\nc\n"
+	for _, c := range []struct {
+		zero bool
+		want []string
+	}{
+		{false, []string{"a 1 keep", "b 1 keep", "c 1 keep"}},
+		{true, []string{"a 1 keep", "b <nil> keep", "c <nil> keep"}},
+	} {
+		d := NewDecoder(strings.NewReader(in)).Opts(DecodeOpts{ZeroFields: c.zero, FieldsPerRecord: -1})
+		r := row{Other: "keep"}
+		var got []string
+		for {
+			if err := d.DecodeNext(&r); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("DecodeNext: %v", err)
+			}
+			b := "<nil>"
+			if r.B != nil {
+				b = strconv.Itoa(*r.B)
+			}
+			got = append(got, r.A+" "+b+" "+r.Other)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ZeroFields %t: got %q, want %q", c.zero, got, c.want)
+		}
+	}
+}
+
+func TestDecode_BufferSize(t *testing.T) {
+	wide := strings.Repeat("x", 10000)
+	in := "a,b\n" + wide + ",1\n"
+	for _, r := range []io.Reader{
+		strings.NewReader(in),
+		bufio.NewReaderSize(strings.NewReader(in), 1<<16),
+	} {
+		d := NewDecoder(r).Opts(DecodeOpts{BufferSize: 1 << 16})
+		m := map[string]string{}
+		if err := d.DecodeNext(&m); err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		if m["a"] != wide || m["b"] != "1" {
+			t.Errorf("decoded %v", m)
+		}
+		if cp := d.Checkpoint(); cp.Offset != int64(len(in)) {
+			t.Errorf("Checkpoint().Offset = %d, want %d", cp.Offset, len(in))
+		}
+	}
+}
+
+func TestDecode_ReuseRecordTypes(t *testing.T) {
+	s := "A,B\na,b\nc,d\ne,f\n"
+	type ab struct{ A, B string }
+	type ba struct {
+		X string `csv:"B"`
+		Y string `csv:"A"`
+	}
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{ReuseRecord: true})
+	var r1, r3 ab
+	var r2 ba
+	for _, v := range []interface{}{&r1, &r2, &r3} {
+		if err := d.DecodeNext(v); err != nil {
+			t.Fatalf("DecodeNext(%q): %v", s, err)
+		}
+	}
+	// Rows decoded earlier keep their values.
+	if r1 != (ab{"a", "b"}) || r2 != (ba{"d", "c"}) || r3 != (ab{"e", "f"}) {
+		t.Errorf("got %v, %v, %v", r1, r2, r3)
+	}
+}
+
+func TestDecode_SampleEvery(t *testing.T) {
+	s := "N\n1\n2\n3\n4\n5\n6\n7\n"
+	type row struct{ N int }
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{SampleEvery: 3})
+	var got []int
+	var rows []int
+	for {
+		if _, err := d.Peek(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Peek: %v", err)
+		}
+		var r row
+		if err := d.DecodeNext(&r); err != nil {
+			t.Fatalf("DecodeNext(%q): %v", s, err)
+		}
+		got = append(got, r.N)
+		rows = append(rows, d.Checkpoint().Row)
+	}
+	if want := []int{1, 4, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Checkpoint counts the rows left out.
+	if !reflect.DeepEqual(rows, got) {
+		t.Errorf("Checkpoint rows: got %v, want %v", rows, got)
+	}
+}
+
+func TestDecode_UnknownBools(t *testing.T) {
+	s := "A,B\ntrue,false\n,FALSE\nN/A,unknown\n"
+	type row struct {
+		A *bool
+		B *bool
+	}
+	d := NewDecoder(strings.NewReader(s)).Opts(DecodeOpts{UnknownBools: []string{"n/a", "Unknown"}})
+	var got []string
+	for {
+		var r row
+		if err := d.DecodeNext(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeNext(%q): %v", s, err)
+		}
+		for _, b := range []*bool{r.A, r.B} {
+			if b == nil {
+				got = append(got, "nil")
+			} else {
+				got = append(got, strconv.FormatBool(*b))
+			}
+		}
+	}
+	if want := []string{"true", "false", "nil", "false", "nil", "nil"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Without UnknownBools, other cells are still errors.
+	d = NewDecoder(strings.NewReader(s))
+	var r row
+	for i := 0; i < 2; i++ {
+		if err := d.DecodeNext(&r); err != nil {
+			t.Fatalf("DecodeNext(%q): %v", s, err)
+		}
+	}
+	if err := d.DecodeNext(&r); err == nil {
+		t.Errorf("DecodeNext(N/A): expected error")
+	}
+}
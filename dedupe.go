@@ -0,0 +1,60 @@
+package csvstruct
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+// dedupe remembers the hashes of rows, or of their key columns, to detect
+// duplicates.
+type dedupe struct {
+	cols []int // indexes of the key columns; nil for the whole row
+	max  int
+	seen map[uint64]struct{}
+}
+
+func newDedupe(hm map[string]int, by []string, max int) (*dedupe, error) {
+	var cols []int
+	if len(by) > 0 {
+		var err error
+		if cols, err = columnIndexes(hm, by); err != nil {
+			return nil, fmt.Errorf("error deduplicating: %v", err)
+		}
+	}
+	return &dedupe{cols: cols, max: max, seen: map[uint64]struct{}{}}, nil
+}
+
+// dup reports whether row duplicates one seen before, and remembers it if
+// not.
+func (d *dedupe) dup(row []string) bool {
+	h := fnv.New64a()
+	var n [binary.MaxVarintLen64]byte
+	add := func(s string) {
+		// Prefix each value with its length so that values can't run
+		// together, e.g. "ab","c" and "a","bc".
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+	if d.cols == nil {
+		for _, v := range row {
+			add(v)
+		}
+	} else {
+		for _, c := range d.cols {
+			if c < len(row) {
+				add(row[c])
+			} else {
+				add("")
+			}
+		}
+	}
+	k := h.Sum64()
+	if _, ok := d.seen[k]; ok {
+		return true
+	}
+	if d.max == 0 || len(d.seen) < d.max {
+		d.seen[k] = struct{}{}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package csvstruct
+
+import (
+	"fmt"
+	"io"
+)
+
+// DiffReport describes the differences between two CSV inputs whose rows
+// are identified by a key column.
+type DiffReport struct {
+	Added   []map[string]string // rows only in the new input, in its order
+	Removed []map[string]string // rows only in the old input, in its order
+	Changed []RowChange         // rows whose values differ, in the new input's order
+}
+
+// RowChange describes the differences in a row present in both inputs.
+type RowChange struct {
+	Key     string         // the row's value in the key column
+	Changes []ColumnChange // changed columns, in the new input's header order
+}
+
+// ColumnChange describes a changed cell. A column missing from one of the
+// inputs has the empty value there.
+type ColumnChange struct {
+	Column   string
+	Old, New string
+}
+
+// Diff reads the rows of old and new, matching them by their values in the
+// key column, and reports the rows that were added, removed or changed.
+// Each key must appear at most once in each input.
+//
+// The rows of old are held in memory; new is streamed.
+func Diff(old, new io.Reader, key string, opts DecodeOpts) (*DiffReport, error) {
+	oldRows, oldKeys, oldHeader, err := readKeyed(old, key, opts)
+	if err != nil {
+		return nil, fmt.Errorf("old: %v", err)
+	}
+
+	rep := &DiffReport{}
+	d := NewDecoder(new).Opts(opts).(*decoder)
+	if err := d.readHeader(); err != nil {
+		return nil, fmt.Errorf("new: %v", err)
+	}
+	if _, ok := d.hm[key]; !ok {
+		return nil, fmt.Errorf("new: no column %q", key)
+	}
+	// Compare the new columns, then any the new input dropped.
+	cols := append([]string(nil), d.header...)
+	for _, h := range oldHeader {
+		if _, ok := d.hm[h]; !ok {
+			cols = append(cols, h)
+		}
+	}
+	seen := map[string]bool{}
+	for {
+		row := map[string]string{}
+		if err := d.DecodeNext(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("new: %v", err)
+		}
+		k := row[key]
+		if seen[k] {
+			return nil, fmt.Errorf("new: duplicate key %q", k)
+		}
+		seen[k] = true
+		o, ok := oldRows[k]
+		if !ok {
+			rep.Added = append(rep.Added, row)
+			continue
+		}
+		var changes []ColumnChange
+		for _, c := range cols {
+			if o[c] != row[c] {
+				changes = append(changes, ColumnChange{c, o[c], row[c]})
+			}
+		}
+		if changes != nil {
+			rep.Changed = append(rep.Changed, RowChange{k, changes})
+		}
+	}
+	for _, k := range oldKeys {
+		if !seen[k] {
+			rep.Removed = append(rep.Removed, oldRows[k])
+		}
+	}
+	return rep, nil
+}
+
+// readKeyed reads the rows of r into a map by their values in the key
+// column, also returning the keys in order and the header.
+func readKeyed(r io.Reader, key string, opts DecodeOpts) (map[string]map[string]string, []string, []string, error) {
+	d := NewDecoder(r).Opts(opts).(*decoder)
+	if err := d.readHeader(); err != nil {
+		return nil, nil, nil, err
+	}
+	if _, ok := d.hm[key]; !ok {
+		return nil, nil, nil, fmt.Errorf("no column %q", key)
+	}
+	rows := map[string]map[string]string{}
+	var keys []string
+	for {
+		row := map[string]string{}
+		if err := d.DecodeNext(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, nil, err
+		}
+		k := row[key]
+		if _, ok := rows[k]; ok {
+			return nil, nil, nil, fmt.Errorf("duplicate key %q", k)
+		}
+		rows[k] = row
+		keys = append(keys, k)
+	}
+	return rows, keys, d.header, nil
+}
@@ -0,0 +1,45 @@
+package csvstruct
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := `id,name,qty,note
+1,apple,3,x
+2,pear,5,y
+3,plum,1,z
+`
+	new := `id,qty,name
+3,2,plum
+1,3,apple
+4,7,fig
+`
+	got, err := Diff(strings.NewReader(old), strings.NewReader(new), "id", DecodeOpts{})
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	want := &DiffReport{
+		Added:   []map[string]string{{"id": "4", "qty": "7", "name": "fig"}},
+		Removed: []map[string]string{{"id": "2", "name": "pear", "qty": "5", "note": "y"}},
+		Changed: []RowChange{{
+			Key:     "3",
+			Changes: []ColumnChange{{"qty", "1", "2"}, {"note", "z", ""}},
+		}, {
+			Key:     "1",
+			Changes: []ColumnChange{{"note", "x", ""}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := Diff(strings.NewReader("id\n1\n1\n"), strings.NewReader("id\n"), "id", DecodeOpts{}); err == nil {
+		t.Errorf("Diff: expected error for duplicate key")
+	}
+	if _, err := Diff(strings.NewReader(old), strings.NewReader(new), "sku", DecodeOpts{}); err == nil {
+		t.Errorf("Diff: expected error for missing key column")
+	}
+}
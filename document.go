@@ -0,0 +1,359 @@
+package csvstruct
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// A Document is CSV input read with the formatting needed to write it back
+// byte for byte: how each cell was quoted, the line endings, a byte order
+// mark, and comment and empty lines. Records may be edited, added, removed
+// and reordered; WriteTo writes the cells that haven't changed as they
+// were read, so that tools editing one column leave the rest of a file
+// untouched. Unknown columns are kept, since every cell is.
+//
+// Documents hold all of their input in memory, which must be uncompressed
+// UTF-8.
+type Document struct {
+	Header  *Record
+	Records []*Record
+
+	d       *decoder // decodes records, with the Document's options
+	decoded []string // header d was last set to
+	eol     string   // line ending of new records
+	trailer string   // comment and empty lines after the last record
+}
+
+// Record is a row of a Document.
+type Record struct {
+	Cells []string
+
+	orig   []string // cells as read
+	raw    []string // cells' text as read, with any quotes
+	prefix string   // byte order mark, comment and empty lines before the record
+	eol    string   // line ending as read
+}
+
+// NewRecord returns a Record to add to a Document. Its cells are quoted
+// only as needed.
+func NewRecord(cells ...string) *Record {
+	return &Record{Cells: cells}
+}
+
+// ReadDocument reads all of r into a Document. Of the options, those
+// affecting how CSV is read apply, like WithComma, WithComment,
+// WithLazyQuotes and WithTrimLeadingSpace, and all of them apply to
+// Document.Decode.
+func ReadDocument(r io.Reader, options ...Option) (*Document, error) {
+	d := NewDecoder(strings.NewReader(""), options...).(*decoder)
+	if d.err != nil {
+		return nil, d.err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{d: d, eol: "\n"}
+	p := &docParser{s: string(b), comma: ",", opts: d.opts}
+	if d.opts.Comma != 0 {
+		p.comma = string(d.opts.Comma)
+	}
+	if d.opts.Comment != 0 {
+		p.comment = string(d.opts.Comment)
+	}
+	for {
+		rec, err := p.record()
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			break
+		}
+		if doc.Header == nil {
+			doc.Header = rec
+			if rec.eol != "" {
+				doc.eol = rec.eol
+			}
+		} else {
+			doc.Records = append(doc.Records, rec)
+		}
+	}
+	if doc.Header == nil {
+		return nil, fmt.Errorf("error reading headers: %w", io.EOF)
+	}
+	doc.trailer = p.prefix
+	return doc, nil
+}
+
+// Decode decodes the cells of rec, a Record of doc, into v, as DecodeNext
+// would, mapping them by the Document's header.
+func (doc *Document) Decode(rec *Record, v interface{}) error {
+	if !equal(doc.decoded, doc.Header.Cells) {
+		doc.decoded = append([]string(nil), doc.Header.Cells...)
+		doc.d.setHeader(doc.decoded)
+	}
+	return doc.d.decodeRow(v, rec.Cells)
+}
+
+// Encode sets the cells of rec, a Record of doc, from v, a struct or map,
+// as an Encoder configured by options would write them under the
+// Document's header. Cells of columns v doesn't have are kept, as are those
+// of fields and keys whose values decode to the values v holds, so that
+// decoding and encoding a record leaves it unchanged.
+func (doc *Document) Encode(rec *Record, v interface{}, options ...Option) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if !rv.IsValid() || (rv.Kind() != reflect.Struct && rv.Kind() != reflect.Map) {
+		return errors.New("must encode map or struct")
+	}
+	var buf bytes.Buffer
+	options = append(options, WithColumns(doc.Header.Cells...), WithSkipHeader())
+	e := NewEncoder(&buf, options...).(*encoder)
+	if err := e.EncodeNext(v); err != nil {
+		return err
+	}
+	if err := e.Close(); err != nil {
+		return err
+	}
+	cr := csv.NewReader(&buf)
+	if e.opts.Comma != 0 {
+		cr.Comma = e.opts.Comma
+	}
+	cells, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("error encoding: %v", err)
+	}
+
+	// Decode the record as it is, to keep the cells of unchanged values.
+	old := reflect.New(rv.Type())
+	if rv.Kind() == reflect.Map {
+		old.Elem().Set(reflect.MakeMap(rv.Type()))
+	}
+	unchanged := func(reflect.Value, reflect.Value) bool { return false }
+	if doc.Decode(rec, old.Interface()) == nil {
+		unchanged = func(a, b reflect.Value) bool {
+			return a.IsValid() && b.IsValid() && reflect.DeepEqual(a.Interface(), b.Interface())
+		}
+	}
+	old = old.Elem()
+
+	for len(rec.Cells) < len(doc.Header.Cells) {
+		rec.Cells = append(rec.Cells, "")
+	}
+	set := func(col string, seen map[string]int, a, b reflect.Value) {
+		i, ok := e.column(col, seen)
+		if ok && i < len(cells) && !unchanged(a, b) {
+			rec.Cells[i] = cells[i]
+		}
+	}
+	seen := map[string]int{}
+	setMap := func(mv, oldv reflect.Value) {
+		for _, k := range mv.MapKeys() {
+			old := reflect.Value{}
+			if oldv.IsValid() && !oldv.IsNil() {
+				old = oldv.MapIndex(k)
+			}
+			set(k.String(), seen, old, mv.MapIndex(k))
+		}
+	}
+	if rv.Kind() == reflect.Map {
+		setMap(rv, old)
+		return nil
+	}
+	fields, err := e.structFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.inline {
+			setMap(rv.FieldByIndex(f.index), old.FieldByIndex(f.index))
+			continue
+		}
+		set(f.name, seen, old.FieldByIndex(f.index), rv.FieldByIndex(f.index))
+	}
+	return nil
+}
+
+// WriteTo writes doc to w, writing the cells of its records that haven't
+// changed as they were read.
+func (doc *Document) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	recs := append([]*Record{doc.Header}, doc.Records...)
+	for i, rec := range recs {
+		b.WriteString(rec.prefix)
+		for j := range rec.Cells {
+			if j > 0 {
+				b.WriteString(doc.comma())
+			}
+			b.WriteString(doc.cell(rec, j))
+		}
+		eol := rec.eol
+		if eol == "" && (rec.orig == nil || i < len(recs)-1) {
+			eol = doc.eol
+		}
+		b.WriteString(eol)
+	}
+	b.WriteString(doc.trailer)
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (doc *Document) comma() string {
+	if c := doc.d.opts.Comma; c != 0 {
+		return string(c)
+	}
+	return ","
+}
+
+// cell returns the text of cell j of rec: as read, if it hasn't changed,
+// or else quoted as it was, or if needed.
+func (doc *Document) cell(rec *Record, j int) string {
+	v := rec.Cells[j]
+	if j < len(rec.orig) && v == rec.orig[j] {
+		return rec.raw[j]
+	}
+	quoted := j < len(rec.raw) && strings.HasPrefix(strings.TrimLeftFunc(rec.raw[j], isSpace), `"`)
+	switch {
+	case quoted,
+		strings.ContainsAny(v, "\"\r\n"),
+		strings.Contains(v, doc.comma()),
+		// A lone empty cell would be an empty line, which is skipped.
+		len(rec.Cells) == 1 && v == "",
+		j == 0 && doc.d.opts.Comment != 0 && strings.HasPrefix(v, string(doc.d.opts.Comment)),
+		doc.d.opts.TrimLeadingSpace && strings.TrimLeftFunc(v, isSpace) != v:
+		return `"` + strings.ReplaceAll(v, `"`, `""`) + `"`
+	}
+	return v
+}
+
+// isSpace reports whether r is white space that TrimLeadingSpace trims
+// from a cell, other than a line ending.
+func isSpace(r rune) bool {
+	return unicode.IsSpace(r) && r != '\r' && r != '\n'
+}
+
+// docParser splits the input of a Document into records, as a csv.Reader
+// would, keeping the text of each cell.
+type docParser struct {
+	s              string
+	pos            int
+	comma, comment string
+	opts           DecodeOpts
+	prefix         string // lines skipped since the last record
+}
+
+// record returns the next record, or nil at the end of the input.
+func (p *docParser) record() (*Record, error) {
+	if p.pos == 0 && strings.HasPrefix(p.s, "\ufeff") {
+		p.prefix, p.pos = "\ufeff", len("\ufeff")
+	}
+	for p.pos < len(p.s) {
+		rest := p.s[p.pos:]
+		end := strings.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+		if (p.comment != "" && strings.HasPrefix(line, p.comment)) || strings.TrimRight(line, "\r\n") == "" {
+			p.prefix += line
+			p.pos += end
+			continue
+		}
+		rec := &Record{prefix: p.prefix}
+		p.prefix = ""
+		for {
+			raw, v, err := p.cell()
+			if err != nil {
+				return nil, err
+			}
+			rec.raw, rec.orig = append(rec.raw, raw), append(rec.orig, v)
+			if strings.HasPrefix(p.s[p.pos:], p.comma) {
+				p.pos += len(p.comma)
+				continue
+			}
+			rest := p.s[p.pos:]
+			switch {
+			case strings.HasPrefix(rest, "\r\n"):
+				rec.eol = "\r\n"
+			case strings.HasPrefix(rest, "\n"):
+				rec.eol = "\n"
+			case rest == "\r":
+				// A trailing CR at the end of the input is dropped.
+				rec.eol = "\r"
+			}
+			p.pos += len(rec.eol)
+			break
+		}
+		rec.Cells = append([]string(nil), rec.orig...)
+		return rec, nil
+	}
+	return nil, nil
+}
+
+// cell reads the next cell, returning its text and value.
+func (p *docParser) cell() (raw, v string, err error) {
+	start := p.pos
+	if p.opts.TrimLeadingSpace {
+		p.pos += len(p.s[p.pos:]) - len(strings.TrimLeftFunc(p.s[p.pos:], isSpace))
+	}
+	if !strings.HasPrefix(p.s[p.pos:], `"`) {
+		rest := p.s[p.pos:]
+		end := len(rest)
+		if i := strings.Index(rest, p.comma); i >= 0 {
+			end = i
+		}
+		if i := strings.IndexByte(rest[:end], '\n'); i >= 0 {
+			end = i
+			if i > 0 && rest[i-1] == '\r' {
+				end--
+			}
+		} else if end == len(rest) && strings.HasSuffix(rest, "\r") {
+			end--
+		}
+		v = rest[:end]
+		if !p.opts.LazyQuotes && strings.Contains(v, `"`) {
+			return "", "", p.errorf(`bare " in non-quoted field`)
+		}
+		p.pos += end
+		return p.s[start:p.pos], v, nil
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(p.s[p.pos:], '"')
+		if i < 0 {
+			if !p.opts.LazyQuotes {
+				return "", "", p.errorf(`extraneous or missing " in quoted-field`)
+			}
+			b.WriteString(p.s[p.pos:])
+			p.pos = len(p.s)
+			break
+		}
+		b.WriteString(p.s[p.pos : p.pos+i])
+		p.pos += i + 1
+		rest := p.s[p.pos:]
+		if strings.HasPrefix(rest, `"`) {
+			b.WriteByte('"')
+			p.pos++
+			continue
+		}
+		if rest == "" || rest == "\r" || strings.HasPrefix(rest, p.comma) || strings.HasPrefix(rest, "\n") || strings.HasPrefix(rest, "\r\n") {
+			break
+		}
+		if !p.opts.LazyQuotes {
+			return "", "", p.errorf(`extraneous or missing " in quoted-field`)
+		}
+		b.WriteByte('"')
+	}
+	// csv.Reader reads line breaks in quoted cells as \n.
+	return p.s[start:p.pos], strings.ReplaceAll(b.String(), "\r\n", "\n"), nil
+}
+
+func (p *docParser) errorf(msg string) error {
+	return fmt.Errorf("error reading document: line %d: %s", strings.Count(p.s[:p.pos], "\n")+1, msg)
+}
@@ -0,0 +1,104 @@
+package csvstruct
+
+import (
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var documents = []string{
+	"a,b\n1,2\n",
+	"a,b\r\n1,2\r\n3,4",
+	"\ufeffa,b\n\"1\",\"x\"\"y\"\n\n3,\"multi\r\nline\"\n",
+	"a,b,c\n1,,\"\"\n4,5,6\r",
+}
+
+func TestDocument_RoundTrip(t *testing.T) {
+	for _, in := range documents {
+		doc, err := ReadDocument(strings.NewReader(in))
+		if err != nil {
+			t.Errorf("ReadDocument(%q): %v", in, err)
+			continue
+		}
+		var b strings.Builder
+		if _, err := doc.WriteTo(&b); err != nil {
+			t.Errorf("WriteTo: %v", err)
+		}
+		if got := b.String(); got != in {
+			t.Errorf("WriteTo: got %q, want %q", got, in)
+		}
+
+		// Cells are read as a csv.Reader reads them.
+		want, err := csv.NewReader(strings.NewReader(in)).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll(%q): %v", in, err)
+		}
+		if len(want) > 0 && strings.HasPrefix(want[0][0], "\ufeff") {
+			want[0][0] = strings.TrimPrefix(want[0][0], "\ufeff")
+		}
+		got := [][]string{doc.Header.Cells}
+		for _, r := range doc.Records {
+			got = append(got, r.Cells)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadDocument(%q): got cells %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDocument_Options(t *testing.T) {
+	in := "# comment\na; b\n\"1\"; 2\n# trailer\n"
+	doc, err := ReadDocument(strings.NewReader(in), WithComma(';'), WithComment('#'), WithTrimLeadingSpace())
+	if err != nil {
+		t.Fatalf("ReadDocument: %v", err)
+	}
+	if got, want := doc.Records[0].Cells, []string{"1", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got cells %q, want %q", got, want)
+	}
+	doc.Records[0].Cells[1] = " 3"
+	var b strings.Builder
+	doc.WriteTo(&b)
+	if got, want := b.String(), "# comment\na; b\n\"1\";\" 3\"\n# trailer\n"; got != want {
+		t.Errorf("WriteTo: got %q, want %q", got, want)
+	}
+
+	if _, err := ReadDocument(strings.NewReader("a\nx\"y\n")); err == nil {
+		t.Errorf("ReadDocument(bare quote): expected error")
+	}
+}
+
+func TestDocument_Edit(t *testing.T) {
+	type item struct {
+		Name  string
+		Price float64
+		Qty   *int `csv:",omitempty"`
+	}
+	in := "Name,Price,Note,Qty\r\n\"widget\",1.5,\"keep, me\",\n gadget ,2.25,,7"
+	doc, err := ReadDocument(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadDocument: %v", err)
+	}
+	for i, r := range doc.Records {
+		var it item
+		if err := doc.Decode(r, &it); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if i == 1 {
+			it.Price *= 2
+		}
+		if err := doc.Encode(r, &it); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	if err := doc.Encode(doc.Records[0], map[string]string{"Note": "changed"}); err != nil {
+		t.Fatalf("Encode(map): %v", err)
+	}
+	doc.Records = append(doc.Records, NewRecord("new", "1", "", ""))
+	var b strings.Builder
+	doc.WriteTo(&b)
+	want := "Name,Price,Note,Qty\r\n\"widget\",1.5,\"changed\",\n gadget ,4.500000,,7\r\nnew,1,,\r\n"
+	if got := b.String(); got != want {
+		t.Errorf("WriteTo: got %q, want %q", got, want)
+	}
+}
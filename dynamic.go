@@ -0,0 +1,105 @@
+package csvstruct
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// StructOf returns a struct type with a field for each column of header, so
+// that files can be decoded and encoded without declaring a type for them.
+// Each field is tagged with its column's name, and has the Go type of the
+// column's Kind in types: *int64, *float64 or *bool, so that empty cells
+// decode to nil, or string for KindString and KindInfer, and for columns
+// not in types. Field names are derived from the column names, e.g. "Unit
+// Price" gives UnitPrice. Column names must be unique, non-empty and free
+// of commas.
+func StructOf(header []string, types map[string]Kind) (reflect.Type, error) {
+	fields := make([]reflect.StructField, len(header))
+	seen := map[string]bool{}
+	names := map[string]bool{}
+	for i, h := range header {
+		if h == "" || strings.Contains(h, ",") {
+			return nil, fmt.Errorf("can't name a field for column %q", h)
+		}
+		if seen[h] {
+			return nil, fmt.Errorf("duplicate column %q", h)
+		}
+		seen[h] = true
+		var t reflect.Type
+		switch k := types[h]; k {
+		case KindInfer, KindString:
+			t = stringType
+		case KindInt:
+			t = reflect.TypeOf((*int64)(nil))
+		case KindFloat:
+			t = reflect.TypeOf((*float64)(nil))
+		case KindBool:
+			t = reflect.TypeOf((*bool)(nil))
+		default:
+			return nil, fmt.Errorf("unknown Kind %d for column %q", int(k), h)
+		}
+		name := fieldName(h)
+		for n := 2; names[name]; n++ {
+			name = fmt.Sprintf("%s%d", fieldName(h), n)
+		}
+		names[name] = true
+		fields[i] = reflect.StructField{
+			Name: name,
+			Type: t,
+			Tag:  reflect.StructTag("csv:" + strconv.Quote(h+",omitempty")),
+		}
+	}
+	return reflect.StructOf(fields), nil
+}
+
+// fieldName returns an exported Go identifier for the column h, joining its
+// words with their first letters in upper case.
+func fieldName(h string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range h {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	if r := []rune(name); len(r) == 0 || !unicode.IsUpper(r[0]) {
+		name = "F" + name
+	}
+	return name
+}
+
+// DecodeDynamic reads the header of r, builds a struct type for it with
+// StructOf, and decodes every row of r into a value of that type, as a
+// Decoder configured by options would. The rows' fields may be read by
+// reflection, or by index, in the order of the header.
+func DecodeDynamic(r io.Reader, types map[string]Kind, options ...Option) (reflect.Type, []reflect.Value, error) {
+	d := NewDecoder(r, options...).(*decoder)
+	if _, err := d.Peek(); err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	t, err := StructOf(d.header, types)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows []reflect.Value
+	for {
+		row := reflect.New(t)
+		if err := d.DecodeNext(row.Interface()); err == io.EOF {
+			return t, rows, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, row.Elem())
+	}
+}
@@ -0,0 +1,75 @@
+package csvstruct
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStructOf(t *testing.T) {
+	typ, err := StructOf([]string{"id", "Unit Price", "unit-price", "2nd", "ok"}, map[string]Kind{"id": KindInt, "Unit Price": KindFloat, "ok": KindBool})
+	if err != nil {
+		t.Fatalf("StructOf: %v", err)
+	}
+	var got []string
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		got = append(got, f.Name+" "+f.Type.String()+" "+f.Tag.Get("csv"))
+	}
+	want := []string{
+		"Id *int64 id,omitempty",
+		"UnitPrice *float64 Unit Price,omitempty",
+		"UnitPrice2 string unit-price,omitempty",
+		"F2nd string 2nd,omitempty",
+		"Ok *bool ok,omitempty",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, header := range [][]string{{"a", "a"}, {""}, {"a,b"}} {
+		if _, err := StructOf(header, nil); err == nil {
+			t.Errorf("StructOf(%q): expected error", header)
+		}
+	}
+}
+
+func TestDecodeDynamic(t *testing.T) {
+	in := "name,age,score\nada,36,\nbob,,1.500000\n"
+	typ, rows, err := DecodeDynamic(strings.NewReader(in), map[string]Kind{"age": KindInt, "score": KindFloat})
+	if err != nil {
+		t.Fatalf("DecodeDynamic: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	if got := rows[0].FieldByName("Name").String(); got != "ada" {
+		t.Errorf("Name: got %q", got)
+	}
+	if got := rows[0].Field(1).Elem().Int(); got != 36 {
+		t.Errorf("age: got %d", got)
+	}
+	if !rows[0].Field(2).IsNil() || !rows[1].Field(1).IsNil() {
+		t.Errorf("empty cells: want nil")
+	}
+
+	// The rows encode back to the input.
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, r := range rows {
+		if r.Type() != typ {
+			t.Errorf("row type: got %v, want %v", r.Type(), typ)
+		}
+		if err := e.EncodeNext(r.Interface()); err != nil {
+			t.Fatalf("EncodeNext: %v", err)
+		}
+	}
+	if got := buf.String(); got != in {
+		t.Errorf("encoded %q, want %q", got, in)
+	}
+
+	if _, _, err := DecodeDynamic(strings.NewReader("a\nx\n"), map[string]Kind{"a": KindInt}); err == nil {
+		t.Errorf("DecodeDynamic(bad int): expected error")
+	}
+}
@@ -1,13 +1,16 @@
 package csvstruct
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
 	"encoding"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
 	"sort"
+	"strings"
 )
 
 var textMarshalerType = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
@@ -18,54 +21,620 @@ type Encoder interface {
 	// Writer.
 	//
 	// On the first call to EncodeNext, v's fields will be used to write the
-	// header row, then v's values will be written as the second row.
-	EncodeNext(v interface{}) error
+	// header row, then v's values will be written as the second row. Later
+	// rows, whether structs or maps, are mapped into the header's columns
+	// by field name, tag or key.
+	//
+	// v may be a struct or map, or a pointer to one. Nil values are
+	// handled as set by the NilRows option. If v is a slice, each of its
+	// elements is written as a row.
+	//
+	// Overrides, if any, apply to this call only, layered over the
+	// Encoder's options. Only options that affect how values are formatted,
+	// such as WithFloatFormat and WithMaskers, may be overridden.
+	EncodeNext(v interface{}, overrides ...Option) error
+
+	// EncodeNextContext is like EncodeNext, but returns ctx.Err() without
+	// writing if ctx is done.
+	EncodeNextContext(ctx context.Context, v interface{}, overrides ...Option) error
 
-	// Opts specifies options to modify encoding behavior.
+	// Opts specifies options to modify encoding behavior. Invalid
+	// options, or invalid combinations of them, cause EncodeNext and Close
+	// to return an error.
+	//
+	// Opts may be called between rows. The options that shape records,
+	// Comma, UseCRLF, TrailingComma, Charset and Align, can't be changed
+	// once the header has been written, since the output would mix
+	// formats; doing so is an error.
 	//
 	// It returns the Encoder, to support chaining.
 	Opts(EncodeOpts) Encoder
+
+	// Close flushes any buffered data and finalizes compression, if any.
+	// It does not close the underlying Writer. Calling Close again does
+	// nothing, and EncodeNext returns an error once Close has been called.
+	Close() error
 }
 
-// EncodeOpts specifies options to modify encoding behavior.
+// EncodeOpts specifies options to modify encoding behavior. Comma and
+// UseCRLF mirror the settings of csv.Writer, which the Encoder's own writer
+// replaces.
 type EncodeOpts struct {
 	SkipHeader bool // True to skip writing the header row
 	Comma      rune // Field delimiter (set to ',' by default)
 	UseCRLF    bool // True to use \r\n as the line terminator
+
+	// BufferSize, if positive, sets the size in bytes of the output
+	// buffer, and rows are then written to the Writer only as it fills and
+	// on Close, rather than after each row, batching writes to
+	// high-latency streams. If the Writer is a *bufio.Writer, Close
+	// flushes it.
+	BufferSize int
+
+	// TrailingComma writes the delimiter after the last field of each
+	// record, as some legacy loaders require. Readers that don't expect
+	// it see an extra empty column.
+	TrailingComma bool
+
+	// Compression compresses the output stream. When set, Close must be
+	// called after the last row to finish the compressed stream.
+	Compression Compression
+
+	// CompressionLevel is the gzip compression level. Zero selects
+	// gzip.DefaultCompression.
+	CompressionLevel int
+
+	// Middleware wraps the output in a chain of Writers, e.g. to encrypt
+	// or hash it. The first middleware receives the CSV output, or the
+	// compressed output if Compression is set, and each writes to the
+	// next; the last writes to the Encoder's Writer. Close closes each in
+	// turn, starting with the first. Like Compression, Middleware must be
+	// set before the first row is encoded.
+	Middleware []WriterMiddleware
+
+	// ProtoNames names the columns of untagged fields of generated protobuf
+	// message structs by their JSON names (e.g., "userId" for user_id).
+	// Oneof fields are skipped, and nested messages are not supported.
+	ProtoNames bool
+
+	// Mapping, if set, maps struct fields to columns in place of their csv
+	// tags.
+	Mapping *Mapping
+
+	// SortBy, if set, buffers data rows and writes them on Close sorted by
+	// the named columns, in order of precedence. Cells that both parse as
+	// numbers are compared numerically, others lexically, and numbers sort
+	// before other cells; rows that compare equal keep the order they were
+	// encoded in.
+	SortBy []string
+
+	// SortMemoryRows bounds the number of rows SortBy holds in memory.
+	// Beyond it, sorted runs are spilled to temporary files and merged on
+	// Close. Zero means no limit.
+	SortMemoryRows int
+
+	// Dedupe drops data rows that duplicate an earlier row. If DedupeBy is
+	// set, rows are duplicates when their values in the named columns
+	// match; otherwise all columns are compared. Rows are remembered by a
+	// 64-bit hash, so distinct rows are very rarely taken as duplicates.
+	Dedupe   bool
+	DedupeBy []string
+
+	// DedupeMaxKeys bounds the number of rows Dedupe remembers. Once it is
+	// reached, new rows are still written but no longer remembered. Zero
+	// means no limit.
+	DedupeMaxKeys int
+
+	// Metrics, if set, receives counts of the rows, bytes, flushes and
+	// errors written.
+	Metrics Metrics
+
+	// OnSkip, if set, is called with a description of each piece of data
+	// the Encoder drops without returning an error, such as fields with no
+	// column in the header.
+	OnSkip func(Skip)
+
+	// Maskers redact the values of the named columns. Fields tagged with
+	// the "mask" option, e.g. `csv:"ssn,mask"`, are redacted with their
+	// column's masker, or by default by replacing all but the last four
+	// characters with '*' (all of them, in values of up to eight
+	// characters). Empty values are left as-is.
+	Maskers map[string]func(string) string
+
+	// OnMask, if set, is called by Close with the number of values masked
+	// in each column, for auditing.
+	OnMask func(counts map[string]int)
+
+	// FloatFormat is the fmt format used for float fields, e.g. "%.2f" or
+	// "%g". If empty, "%f" is used.
+	FloatFormat string
+
+	// NilRows selects how EncodeNext handles nil values and nil pointers.
+	NilRows NilPolicy
+
+	// ArrayDelimiter separates the elements of array fields within a cell
+	// ("|" by default).
+	ArrayDelimiter string
+
+	// UnitRow and DescriptionRow write rows after the header holding each
+	// column's unit and description, from tags like
+	// `csv:"speed,unit=km/h,desc=Ground speed"`. Tag values can't contain
+	// commas. The rows are only written when encoding structs.
+	UnitRow        bool
+	DescriptionRow bool
+
+	// RepeatHeaderEvery, if positive, writes the header row again after
+	// every RepeatHeaderEvery data rows, for output read by people with
+	// tools like tail and less.
+	RepeatHeaderEvery int
+
+	// Columns gives the header, in order, as a template: fields and keys
+	// not in Columns are dropped, and columns with no field or key are
+	// left empty. ReadHeader reads a template from an existing file. If
+	// nil, the header holds the fields of the first struct in order, the
+	// keys of the first map in sorted order, or of the first OrderedMap in
+	// insertion order.
+	Columns []string
+
+	// Schema, if set, gives the header as a template, as Columns does,
+	// filling each column from the struct field or map key given by its
+	// Field and formatting values with its Format. Required columns that
+	// are empty are an error. SortBy and DedupeBy name columns by their
+	// Field.
+	Schema *Schema
+
+	// DedupeHeaders suffixes repeated column names, as when struct tags
+	// or inline map keys collide, to make them unique: the second "name"
+	// column is written as "name_2", the third as "name_3" and so on.
+	// Without it, the repeated columns are written under the same name,
+	// and only the last is filled in.
+	DedupeHeaders bool
+
+	// HeaderLess, if set, orders the keys of the first map into the header
+	// instead of lexical order, e.g. NaturalLess.
+	HeaderLess func(a, b string) bool
+
+	// SortHeaders orders the columns of structs as the keys of maps are
+	// ordered, lexically or by HeaderLess, instead of in field order, so
+	// that the same data encoded from structs and maps is identical.
+	SortHeaders bool
+
+	// SkipEmptyRows skips rows whose cells are all empty, such as structs
+	// whose matched fields are all empty strings or nil pointers. By
+	// default they are written as rows of empty cells.
+	SkipEmptyRows bool
+
+	// Quote sets when cells are quoted. With QuoteNever, cells containing
+	// the delimiter, a quote or a line break are an error, unless
+	// Unquotable is set, in which case each such character is replaced
+	// with Unquotable. QuoteNonNumeric quotes every cell except those of
+	// columns whose fields or first row's values are numbers or booleans,
+	// like Python's csv.QUOTE_NONNUMERIC.
+	Quote      QuotePolicy
+	Unquotable string
+
+	// Newlines sets how line breaks within cells are written, for
+	// consumers that can't read quoted cells spanning several lines.
+	// LFNewlines can't be used with UseCRLF.
+	Newlines NewlinePolicy
+
+	// ControlChars sets how other control characters within cells are
+	// written, to protect downstream parsers and terminals from raw bytes
+	// in user data. ControlReplacement is used with ReplaceControl.
+	ControlChars       ControlPolicy
+	ControlReplacement string
+
+	// Charset is the character encoding of the output: UTF8 (the default),
+	// Latin1 or Windows1252, for endpoints that refuse UTF-8. Cells
+	// containing characters the charset can't represent are an error,
+	// unless Unmappable is set, in which case each such character is
+	// replaced with Unmappable.
+	Charset    Charset
+	Unmappable string
+
+	// Align pads the cells of each column to equal widths, for
+	// human-readable dumps of small datasets. All rows are buffered until
+	// Close, which computes the widths and writes them. The padding is
+	// part of the cells to CSV readers, and AlignSpaces output isn't CSV
+	// at all, so aligned output is meant for people, not programs.
+	Align AlignPolicy
+
+	// SchemaHash writes a comment line before the header holding a hash
+	// of the columns' names, order and Go types, which a Decoder checks
+	// when DecodeOpts.SchemaHash is set, to detect schema drift between
+	// producers and consumers. See the SchemaHash function.
+	SchemaHash bool
+
+	// Provenance, if set, writes comment lines before the header
+	// recording when and by what the output was generated, which
+	// Decoder.Provenance reads back. The row count is written as a
+	// placeholder and filled in by Close if the Encoder's Writer is an
+	// io.WriteSeeker, such as an *os.File, and neither Compression nor
+	// Middleware is set, or if Align is set.
+	Provenance *Provenance
+
+	// Trailer appends a trailer record to the output on Close, of the form
+	// "#TRAILER,<rows>,<crc32>", holding the number of data rows and a
+	// checksum of their cells, as some file exchanges require.
+	// DecodeOpts.VerifyTrailer checks it. The checksum is the CRC-32
+	// (IEEE) of each cell followed by a 0x1F byte, with each row followed
+	// by a 0x1E byte. Trailer can't be used with Align, TrailingComma or
+	// Unmappable, which change the cells as they are read back.
+	Trailer bool
+}
+
+// NilPolicy describes how an Encoder handles nil rows.
+type NilPolicy int
+
+const (
+	SkipNil     NilPolicy = iota // skip the row (the default)
+	EmptyRowNil                  // write a row of empty cells
+	ErrorNil                     // return an error
+)
+
+// WriterMiddleware wraps w in a Writer that transforms its output. The
+// returned Writer's Close must flush it to w, but not close w.
+type WriterMiddleware func(w io.Writer) (io.WriteCloser, error)
+
+// GzipMiddleware returns a WriterMiddleware that compresses its output with
+// gzip at the given level.
+func GzipMiddleware(level int) WriterMiddleware {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	}
 }
 
+// Compression identifies a compression format for encoded output.
+type Compression int
+
+const (
+	NoCompression Compression = iota // write uncompressed CSV
+	Gzip                             // write a gzip stream
+)
+
 type encoder struct {
-	w    csv.Writer
-	hm   map[string]int
-	opts EncodeOpts
+	dst      io.Writer
+	cw       *countWriter // counts bytes written to dst
+	w        writer
+	chain    []io.WriteCloser // middleware between w and dst, in order
+	out      io.Writer        // the Writer w writes to: dst, or the first middleware
+	hm       map[string]int
+	headers  []string
+	rows     int // data rows written
+	opts     EncodeOpts
+	err      error   // sticky error from Opts or options
+	sort     *sorter // buffers rows when SortBy is set
+	seen     *dedupe // rows seen when Dedupe is set
+	counted  int64   // bytes reported to Metrics
+	skips    skipper
+	masked   map[string]int           // values masked in each column
+	notes    [][]string               // rows written after the header
+	written  int                      // data rows written to w, for RepeatHeaderEvery
+	names    []string                 // columns of the header before DedupeHeaders
+	dups     map[string][]int         // columns with each repeated name
+	allowDup map[string]bool          // names of fields tagged "allowdup"
+	rowType  reflect.Type             // type of the first row, for SchemaHash
+	fields   map[reflect.Type][]field // fields of the struct types encoded
+	first    interface{}              // the first row, until the header is set
+	rowsAt   int64                    // offset in dst of the Provenance row count, or -1
+	closed   bool                     // Close has been called
 }
 
-// NewEncoder returns an encoder that writes to w.
-func NewEncoder(w io.Writer) Encoder {
-	csvw := csv.NewWriter(w)
-	return &encoder{w: *csvw}
+// errClosed is returned by EncodeNext after Close.
+var errClosed = errors.New("encoder closed")
+
+// NewEncoder returns an encoder that writes to w, configured by options.
+func NewEncoder(w io.Writer, options ...Option) Encoder {
+	cw := &countWriter{w: w}
+	e := &encoder{dst: cw, cw: cw, out: cw, w: *newWriter(cw, 0), rowsAt: -1}
+	if len(options) > 0 {
+		var opts EncodeOpts
+		if err := applyEncode(&opts, options); err != nil {
+			e.err = err
+			return e
+		}
+		e.Opts(opts)
+	}
+	return e
 }
 
 func (e *encoder) Opts(opts EncodeOpts) Encoder {
+	if err := opts.validate(); err != nil {
+		e.err = err
+		return e
+	}
+	if err := e.checkLayout(opts); err != nil {
+		e.err = err
+		return e
+	}
+	if e.hm == nil && (opts.Compression != e.opts.Compression || opts.CompressionLevel != e.opts.CompressionLevel ||
+		!sameMiddleware(opts.Middleware, e.opts.Middleware)) {
+		// The chain is only built before the header, since replacing it
+		// would drop output buffered by the old one.
+		if err := e.buildChain(opts); err != nil {
+			e.err = err
+			return e
+		}
+	} else if opts.BufferSize != e.opts.BufferSize {
+		e.w.Flush()
+		e.w.w = bufio.NewWriterSize(e.out, opts.BufferSize)
+	}
 	if opts.Comma != rune(0) {
 		e.w.Comma = opts.Comma
 	}
 	e.w.UseCRLF = opts.UseCRLF
+	e.w.TrailingComma = opts.TrailingComma
+	e.w.Quote = opts.Quote
+	e.w.Replacement = opts.Unquotable
+	e.w.Newlines = opts.Newlines
+	e.w.Control, e.w.ControlReplacement = opts.ControlChars, opts.ControlReplacement
+	e.w.Charset, e.w.Unmappable = opts.Charset, opts.Unmappable
+	e.w.Align = opts.Align
+	if opts.Trailer && e.w.Trailer == nil {
+		e.w.Trailer = newTrailer()
+	} else if !opts.Trailer {
+		e.w.Trailer = nil
+	}
 	e.opts = opts
+	e.skips.fn = opts.OnSkip
+	e.fields = nil
 	return e
 }
 
-func (e *encoder) EncodeNext(v interface{}) error {
-	if v == nil {
+// checkLayout reports changes to the options that shape records once the
+// header has been written.
+func (e *encoder) checkLayout(opts EncodeOpts) error {
+	if e.hm == nil {
+		return nil
+	}
+	switch {
+	case opts.Comma != 0 && opts.Comma != e.w.Comma:
+		return errors.New("Comma can't be changed after the header is written")
+	case opts.UseCRLF != e.w.UseCRLF, opts.TrailingComma != e.w.TrailingComma:
+		return errors.New("UseCRLF and TrailingComma can't be changed after the header is written")
+	case opts.Charset != e.w.Charset, opts.Align != e.w.Align:
+		return errors.New("Charset and Align can't be changed after the header is written")
+	case opts.Compression != e.opts.Compression, opts.CompressionLevel != e.opts.CompressionLevel,
+		!sameMiddleware(opts.Middleware, e.opts.Middleware):
+		return errors.New("Compression, CompressionLevel and Middleware can't be changed after the header is written")
+	}
+	return nil
+}
+
+// sameMiddleware reports whether a and b hold the same functions in the same
+// order. Closures of the same function literal compare equal.
+func sameMiddleware(a, b []WriterMiddleware) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if reflect.ValueOf(a[i]).Pointer() != reflect.ValueOf(b[i]).Pointer() {
+			return false
+		}
+	}
+	return true
+}
+
+// buildChain wraps dst in the compression and middleware set in opts, and
+// points the writer at the result.
+func (e *encoder) buildChain(opts EncodeOpts) error {
+	mws := opts.Middleware
+	if opts.Compression == Gzip {
+		level := opts.CompressionLevel
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		mws = append([]WriterMiddleware{GzipMiddleware(level)}, mws...)
+	}
+	// Wrap from the destination outwards, so that the first middleware
+	// receives the CSV output.
+	e.chain = make([]io.WriteCloser, len(mws))
+	out := e.dst
+	for i := len(mws) - 1; i >= 0; i-- {
+		wc, err := mws[i](out)
+		if err != nil {
+			return err
+		}
+		e.chain[i], out = wc, wc
+	}
+	e.out = out
+	e.w = *newWriter(out, opts.BufferSize)
+	return nil
+}
+
+func (e *encoder) Close() error {
+	if e.closed {
 		return nil
 	}
-	switch reflect.ValueOf(v).Type().Kind() {
+	e.closed = true
+	if e.err != nil {
+		return e.err
+	}
+	if e.sort != nil {
+		if err := e.sort.flush(e.write); err != nil {
+			return err
+		}
+	}
+	if e.w.Trailer != nil {
+		if err := e.w.Write(e.w.Trailer.record()); err != nil {
+			return err
+		}
+	}
+	e.fillAlignedRows()
+	if err := e.w.WriteAligned(); err != nil {
+		return err
+	}
+	e.flush()
+	if err := e.w.Error(); err != nil {
+		return err
+	}
+	if err := e.fillRows(); err != nil {
+		return err
+	}
+	var err error
+	for _, wc := range e.chain {
+		if cerr := wc.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if bw, ok := e.cw.w.(*bufio.Writer); ok {
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+	}
+	if len(e.chain) > 0 {
+		e.report()
+	}
+	if e.opts.OnMask != nil && e.masked != nil {
+		e.opts.OnMask(e.masked)
+	}
+	return err
+}
+
+// mask redacts s, the value of the named column, if the column has a
+// masker or tagged is set.
+func (e *encoder) mask(column, s string, tagged bool) string {
+	fn, ok := e.opts.Maskers[column]
+	if (!ok && !tagged) || s == "" {
+		return s
+	}
+	if fn == nil {
+		fn = maskAllButLast4
+	}
+	if e.masked == nil {
+		e.masked = map[string]int{}
+	}
+	e.masked[column]++
+	return fn(s)
+}
+
+// maskAllButLast4 replaces all but the last four characters of s with '*'.
+// Short values are masked entirely, since their last four characters would
+// reveal too much of them.
+func maskAllButLast4(s string) string {
+	r := []rune(s)
+	keep := 4
+	if len(r) <= 8 {
+		keep = 0
+	}
+	for i := 0; i < len(r)-keep; i++ {
+		r[i] = '*'
+	}
+	return string(r)
+}
+
+func (e *encoder) EncodeNextContext(ctx context.Context, v interface{}, overrides ...Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.EncodeNext(v, overrides...)
+}
+
+func (e *encoder) EncodeNext(v interface{}, overrides ...Option) error {
+	if e.closed {
+		return errClosed
+	}
+	if len(overrides) > 0 && e.err == nil {
+		base := e.opts
+		defer func() {
+			e.opts = base
+			e.skips.fn = base.OnSkip
+		}()
+		if err := applyOverrides(&e.opts, overrides); err != nil {
+			return err
+		}
+		e.skips.fn = e.opts.OnSkip
+	}
+	err := e.encodeNext(v)
+	if err != nil && e.opts.Metrics != nil {
+		e.opts.Metrics.Add(MetricEncodeErrors, 1)
+	}
+	return err
+}
+
+func (e *encoder) encodeNext(v interface{}) error {
+	if e.err != nil {
+		return e.err
+	}
+	if v == nil {
+		return e.encodeNil(nil)
+	}
+	if e.hm == nil {
+		e.rowType, e.first = reflect.TypeOf(v), v
+	}
+	switch m := v.(type) {
+	case map[string]string:
+		return e.encodeStrings(m)
+	case *OrderedMap:
+		if m != nil {
+			return e.encodeOrdered(m)
+		}
+	case OrderedMap:
+		return e.encodeOrdered(&m)
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return e.encodeNil(rv.Type().Elem())
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
 	case reflect.Map:
-		return e.encodeMap(v)
+		return e.encodeMap(rv.Interface())
 	case reflect.Struct:
-		return e.encodeStruct(v)
+		return e.encodeStruct(rv.Interface())
+	case reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			if err := e.encodeNext(rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
 	default:
-		return errors.New("must encode map or struct")
+		return errors.New("must encode map, struct or slice")
+	}
+}
+
+// encodeNil handles a nil row according to the NilRows option. t is the
+// type the nil pointed to, if known.
+func (e *encoder) encodeNil(t reflect.Type) error {
+	switch e.opts.NilRows {
+	case EmptyRowNil:
+		if e.hm == nil {
+			for t != nil && t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t == nil || t.Kind() != reflect.Struct {
+				return errors.New("can't write an empty row before the header")
+			}
+			fields, err := e.structFields(t)
+			if err != nil {
+				return err
+			}
+			headers := []string{}
+			for _, f := range fields {
+				if !f.inline {
+					headers = append(headers, f.name)
+				}
+			}
+			e.sortHeaders(headers)
+			if cols := e.template(); cols != nil {
+				headers = cols
+			}
+			if err := e.setHeader(headers); err != nil {
+				return err
+			}
+		}
+		if len(e.hm) == 0 {
+			return nil
+		}
+		return e.writeRow(make([]string, len(e.headers)))
+	case ErrorNil:
+		return errors.New("can't encode nil row")
+	default:
+		e.skips.skip(e.rows+1, "", SkipNilRow)
+		return nil
 	}
 }
 
@@ -73,144 +642,594 @@ func (e *encoder) encodeMap(v interface{}) error {
 	if reflect.ValueOf(v).Type().Key().Kind() != reflect.String {
 		return errors.New("map key must be string")
 	}
-	m := v.(map[string]interface{})
-
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("can't encode map of type %T", v)
+	}
 	if e.hm == nil {
-		e.hm = make(map[string]int)
-		headers := []string{}
-		for k := range m {
-			headers = append(headers, k)
+		if err := e.setMapHeader(sortedKeys(e, m)); err != nil {
+			return err
 		}
-		sort.Strings(headers)
-		for i, h := range headers {
-			e.hm[h] = i
+	}
+	return encodeCells(e, m, func(v interface{}) string { return fmt.Sprint(v) })
+}
+
+// encodeStrings encodes a map[string]string without reflection.
+func (e *encoder) encodeStrings(m map[string]string) error {
+	if e.hm == nil {
+		if err := e.setMapHeader(sortedKeys(e, m)); err != nil {
+			return err
 		}
-		if len(e.hm) == 0 {
-			// First row was an empty map, so write nothing.
-			// This will result in an empty output no matter what is Encoded.
-			return nil
+	}
+	return encodeCells(e, m, func(s string) string { return s })
+}
+
+// sortedKeys returns the keys of m ordered by HeaderLess, or lexically.
+func sortedKeys[V any](e *encoder, m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	if less := e.opts.HeaderLess; less != nil {
+		sort.SliceStable(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+	} else {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+// sortHeaders sorts the columns of a struct's header, and the slices
+// parallel to it, if SortHeaders is set.
+func (e *encoder) sortHeaders(headers []string, parallel ...[]string) {
+	if !e.opts.SortHeaders {
+		return
+	}
+	less := e.opts.HeaderLess
+	if less == nil {
+		less = func(a, b string) bool { return a < b }
+	}
+	idx := make([]int, len(headers))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return less(headers[idx[i]], headers[idx[j]]) })
+	for _, s := range append(parallel, headers) {
+		sorted := make([]string, len(s))
+		for i, j := range idx {
+			sorted[i] = s[j]
 		}
-		if !e.opts.SkipHeader {
-			if err := e.w.Write(headers); err != nil {
-				return err
-			}
+		copy(s, sorted)
+	}
+}
+
+// encodeOrdered encodes an OrderedMap, whose keys give the header in order.
+func (e *encoder) encodeOrdered(m *OrderedMap) error {
+	if e.hm == nil {
+		if err := e.setMapHeader(append([]string(nil), m.keys...)); err != nil {
+			return err
 		}
 	}
-	row := make([]string, len(m))
+	return encodeCells(e, m.values, func(v interface{}) string { return fmt.Sprint(v) })
+}
+
+// setMapHeader sets the header from the keys of the first map encoded,
+// unless the Columns or Schema option gives it explicitly.
+func (e *encoder) setMapHeader(keys []string) error {
+	if cols := e.template(); cols != nil {
+		keys = cols
+	}
+	// If the first row was an empty map, nothing is written.
+	// This will result in an empty output no matter what is Encoded.
+	return e.setHeader(keys)
+}
+
+// encodeCells writes the values of m, formatted by str, as a row under the
+// header.
+func encodeCells[V any](e *encoder, m map[string]V, str func(V) string) error {
+	row := make([]string, len(e.headers))
 	add := false // Whether there has been a row to write in this call.
-	for h, i := range e.hm {
+	for i, h := range e.names {
 		val, ok := m[h]
 		if !ok {
 			continue
 		}
 		add = true
-		row[i] = fmt.Sprint(val)
+		s, ok := e.sprintf(i, reflect.ValueOf(val))
+		if !ok {
+			s = str(val)
+		}
+		row[i] = e.mask(h, s, false)
+	}
+	if e.skips.fn != nil {
+		for k := range m {
+			if _, ok := e.hm[k]; !ok {
+				e.skips.skip(e.rows+1, k, SkipUnmappedKey)
+			}
+		}
 	}
 	if !add {
+		e.skips.skip(e.rows+1, "", SkipEmptyRow)
 		return nil
 	}
-	if err := e.w.Write(row); err != nil {
-		return err
+	return e.writeRow(row)
+}
+
+// structFields returns the fields of the struct type t, computing them once
+// per type.
+func (e *encoder) structFields(t reflect.Type) ([]field, error) {
+	if fields, ok := e.fields[t]; ok {
+		return fields, nil
 	}
-	e.w.Flush()
-	return e.w.Error()
+	fields, err := structFields(t, e.opts.ProtoNames, e.opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	if e.fields == nil {
+		e.fields = map[reflect.Type][]field{}
+	}
+	e.fields[t] = fields
+	return fields, nil
 }
 
 func (e *encoder) encodeStruct(v interface{}) error {
-	t := reflect.ValueOf(v).Type()
+	rv := reflect.ValueOf(v)
+	fields, err := e.structFields(rv.Type())
+	if err != nil {
+		return err
+	}
 	if e.hm == nil {
-		e.hm = make(map[string]int)
 		headers := []string{}
-		i := 0
-		for j := 0; j < t.NumField(); j++ {
-			f := t.Field(j)
-			if f.Anonymous {
+		var units, descs []string
+		for _, f := range fields {
+			if f.inline {
+				// Inline maps contribute their keys from the first row.
+				keys := inlineKeys(rv.FieldByIndex(f.index))
+				headers = append(headers, keys...)
+				units = append(units, make([]string, len(keys))...)
+				descs = append(descs, make([]string, len(keys))...)
 				continue
 			}
-			if f.PkgPath != "" { // Filter unexported fields
-				continue
+			if f.allowdup {
+				if e.allowDup == nil {
+					e.allowDup = map[string]bool{}
+				}
+				e.allowDup[f.name] = true
 			}
-			n := f.Name
-			if f.Tag.Get("csv") != "" {
-				n = f.Tag.Get("csv")
-				if n == "-" {
-					continue
+			headers = append(headers, f.name)
+			units = append(units, f.unit)
+			descs = append(descs, f.desc)
+		}
+		e.sortHeaders(headers, units, descs)
+		if cols := e.template(); cols != nil {
+			// The template dictates the columns; fields not in it are
+			// dropped, and columns not in the struct are left empty.
+			idx := reverse(headers)
+			u, d := make([]string, len(cols)), make([]string, len(cols))
+			for i, c := range cols {
+				if j, ok := idx[c]; ok {
+					u[i], d[i] = units[j], descs[j]
 				}
 			}
-			headers = append(headers, n)
-			e.hm[n] = i
-			i++
+			headers, units, descs = cols, u, d
 		}
-		if len(e.hm) == 0 {
-			// Header row has no exported, unignored fields, so write nothing.
-			// This will result in an empty output no matter what is Encoded.
-			return nil
+		if e.opts.UnitRow {
+			e.notes = append(e.notes, units)
 		}
-		if !e.opts.SkipHeader {
-			if err := e.w.Write(headers); err != nil {
-				return err
-			}
+		if e.opts.DescriptionRow {
+			e.notes = append(e.notes, descs)
+		}
+		// If the header row has no exported, unignored fields, nothing is
+		// written. This will result in an empty output no matter what is
+		// Encoded.
+		if err := e.setHeader(headers); err != nil {
+			return err
 		}
 	}
 
-	rv := reflect.ValueOf(v)
-	row := make([]string, len(e.hm))
+	// Columns missing from the struct are left empty, so only report its
+	// fields that are missing from the header.
+	e.skips.mapping(rv.Type(), fields, e.hm, nil)
+	row := make([]string, len(e.headers))
 	add := false // Whether there has been a row to write in this call.
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		if f.PkgPath != "" { // Filter unexported fields
+	seen := map[string]int{}
+	for _, f := range fields {
+		if f.inline {
+			mv := rv.FieldByIndex(f.index)
+			for _, k := range inlineKeys(mv) {
+				fi, ok := e.column(k, seen)
+				if !ok {
+					e.skips.skip(e.rows+1, k, SkipUnmappedKey)
+					continue
+				}
+				add = true
+				s, err := e.format(mv.MapIndex(reflect.ValueOf(k)))
+				if err != nil {
+					return err
+				}
+				row[fi] = e.mask(k, s, false)
+			}
 			continue
 		}
-		n := f.Name
-		if f.Tag.Get("csv") != "" {
-			n = f.Tag.Get("csv")
-		}
-
-		fi, ok := e.hm[n]
+		fi, ok := e.column(f.name, seen)
 		if !ok {
 			// Unmapped header value
 			continue
 		}
-
 		add = true
-		vf := rv.Field(i)
-
-		if vf.Type().Implements(textMarshalerType) {
-			if tm, ok := vf.Interface().(encoding.TextMarshaler); ok {
-				b, err := tm.MarshalText()
-				if err != nil {
-					return err
-				}
-				row[fi] = string(b)
-				continue
+		vf := rv.FieldByIndex(f.index)
+		s, ok := e.sprintf(fi, vf)
+		if !ok {
+			s, ok = sprintf(f.format, vf)
+		}
+		if !ok {
+			var err error
+			if f.template != "" {
+				s, err = execute(f.template, rv)
+			} else if l := lookupFor(f.lookup, vf.Type()); l != nil {
+				s, err = l.label(vf)
 			} else {
-				panic("unreachable")
+				s, err = e.format(vf)
+			}
+			if err != nil {
+				return err
 			}
 		}
-		if vf.Kind() == reflect.Ptr {
-			vf = vf.Elem()
+		row[fi] = e.mask(f.name, f.styleUUID(s), f.mask)
+	}
+	if !add {
+		e.skips.skip(e.rows+1, "", SkipEmptyRow)
+		return nil
+	}
+	return e.writeRow(row)
+}
+
+// template returns the header given by the Columns or Schema option, or
+// nil.
+func (e *encoder) template() []string {
+	if e.opts.Schema != nil {
+		return e.opts.Schema.keys()
+	}
+	return e.opts.Columns
+}
+
+// sprintf formats the value v with the Schema's Format for column i, if it
+// has one and v isn't nil.
+func (e *encoder) sprintf(i int, v reflect.Value) (string, bool) {
+	if e.opts.Schema == nil {
+		return "", false
+	}
+	return sprintf(e.opts.Schema.Columns[i].Format, v)
+}
+
+// sprintf formats the value v with format, if it's set and v isn't nil.
+func sprintf(format string, v reflect.Value) (string, bool) {
+	if format == "" {
+		return "", false
+	}
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return "", false
+	}
+	return fmt.Sprintf(format, v.Interface()), true
+}
+
+// format returns the string representation of the field value vf.
+func (e *encoder) format(vf reflect.Value) (string, error) {
+	if vf.Kind() == reflect.Ptr && vf.IsNil() {
+		// Nil pointers are written as empty cells.
+		return "", nil
+	}
+	if vf.Type().Implements(textMarshalerType) {
+		b, err := vf.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	if vf.Kind() == reflect.Ptr {
+		vf = vf.Elem()
+	}
+	switch vf.Kind() {
+	case reflect.Interface:
+		// Format the dynamic value, falling back to fmt as map values do.
+		if vf.IsNil() {
+			return "", nil
+		}
+		ev := vf.Elem()
+		if s, err := e.format(ev); err == nil || ev.Type().Implements(textMarshalerType) {
+			return s, err
+		}
+		return fmt.Sprint(ev.Interface()), nil
+	case reflect.String:
+		return vf.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("%d", vf.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("%d", vf.Uint()), nil
+	case reflect.Float64:
+		if e.opts.FloatFormat != "" {
+			return fmt.Sprintf(e.opts.FloatFormat, vf.Float()), nil
 		}
-		switch vf.Kind() {
-		case reflect.String:
-			row[fi] = vf.String()
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			row[fi] = fmt.Sprintf("%d", vf.Int())
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			row[fi] = fmt.Sprintf("%d", vf.Uint())
-		case reflect.Float64:
-			row[fi] = fmt.Sprintf("%f", vf.Float())
-		case reflect.Bool:
-			row[fi] = fmt.Sprintf("%t", vf.Bool())
-		default:
-			return fmt.Errorf("can't encode type %v", f.Type)
+		return fmt.Sprintf("%f", vf.Float()), nil
+	case reflect.Bool:
+		return fmt.Sprintf("%t", vf.Bool()), nil
+	case reflect.Array:
+		if isUUID(vf.Type()) {
+			return formatUUID(vf), nil
 		}
+		if vf.Type().Elem().Kind() == reflect.Array {
+			return "", fmt.Errorf("can't encode nested array type %v", vf.Type())
+		}
+		parts := make([]string, vf.Len())
+		for i := range parts {
+			s, err := e.format(vf.Index(i))
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, arrayDelimiter(e.opts.ArrayDelimiter)), nil
+	default:
+		return "", fmt.Errorf("can't encode type %v", vf.Type())
 	}
-	if !add {
+}
+
+// setHeader maps the given header columns and writes the header row, unless
+// there are no columns or the header is skipped.
+func (e *encoder) setHeader(headers []string) error {
+	e.names, e.headers = headers, headers
+	if e.opts.Schema != nil {
+		// The Schema's columns are mapped by their fields, and written
+		// under their names.
+		e.headers = e.opts.Schema.names()
+	}
+	if e.opts.DedupeHeaders {
+		e.headers = dedupeNames(headers, e.allowDup)
+	}
+	if e.opts.DedupeHeaders || len(e.allowDup) > 0 {
+		e.dups = map[string][]int{}
+		for i, h := range headers {
+			if e.opts.DedupeHeaders || e.allowDup[h] {
+				e.dups[h] = append(e.dups[h], i)
+			}
+		}
+	}
+	e.hm = make(map[string]int, len(headers))
+	keys := e.headers
+	if e.opts.Schema != nil {
+		keys = e.names
+	}
+	for i, h := range keys {
+		e.hm[h] = i
+	}
+	if e.opts.Quote == QuoteNonNumeric {
+		e.w.Numeric = e.numericColumns(e.names)
+	}
+	e.first = nil
+	if len(headers) == 0 || e.opts.SkipHeader {
 		return nil
 	}
-	if err := e.w.Write(row); err != nil {
+	if e.opts.Provenance != nil {
+		if err := e.writeProvenance(*e.opts.Provenance); err != nil {
+			return err
+		}
+	}
+	if e.opts.SchemaHash {
+		if err := e.w.Write([]string{preambleLine("schema", e.schemaHash())}); err != nil {
+			return err
+		}
+	}
+	if err := e.w.Write(e.headers); err != nil {
+		return e.writeErr(err)
+	}
+	for _, row := range e.notes {
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// column returns the index of the column of the field or key name in the
+// row being encoded. With DedupeHeaders, or for fields tagged "allowdup",
+// the nth field or key with a repeated name, counted in seen, gets the nth
+// column with that name.
+func (e *encoder) column(name string, seen map[string]int) (int, bool) {
+	cols, ok := e.dups[name]
+	if !ok {
+		i, ok := e.hm[name]
+		return i, ok
+	}
+	n := seen[name]
+	seen[name]++
+	if n >= len(cols) {
+		return 0, false
+	}
+	return cols[n], true
+}
+
+// dedupeNames returns names with repeated names suffixed "_2", "_3" and so
+// on, skipping suffixed names that are already taken. Names in keep are left
+// repeated.
+func dedupeNames(names []string, keep map[string]bool) []string {
+	taken := make(map[string]bool, len(names))
+	for _, n := range names {
+		taken[n] = true
+	}
+	out := make([]string, len(names))
+	count := map[string]int{}
+	for i, n := range names {
+		count[n]++
+		if count[n] == 1 || keep[n] {
+			out[i] = n
+			continue
+		}
+		for {
+			s := fmt.Sprintf("%s_%d", n, count[n])
+			if !taken[s] {
+				taken[s] = true
+				out[i] = s
+				break
+			}
+			count[n]++
+		}
+	}
+	return out
+}
+
+// numericColumns reports which of the columns of headers hold numbers or
+// booleans, by the types of the fields or map values of the first row.
+func (e *encoder) numericColumns(headers []string) []bool {
+	types := columnTypes(e.rowType, e.opts.ProtoNames, e.opts.Mapping)
+	var values map[string]interface{}
+	switch m := e.first.(type) {
+	case map[string]interface{}:
+		values = m
+	case OrderedMap:
+		values = m.values
+	case *OrderedMap:
+		values = m.values
+	}
+	numeric := make([]bool, len(headers))
+	for i, h := range headers {
+		if s := e.opts.Schema; s != nil && s.Columns[i].Type != KindInfer {
+			k := s.Columns[i].Type
+			numeric[i] = k == KindInt || k == KindFloat || k == KindBool
+			continue
+		}
+		t := types(h)
+		if t == interfaceType && values[h] != nil {
+			t = reflect.TypeOf(values[h])
+		}
+		numeric[i] = isNumeric(t)
+	}
+	return numeric
+}
+
+// isNumeric reports whether values of type t are written as numbers or
+// booleans.
+func isNumeric(t reflect.Type) bool {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// writeRow writes a data row and flushes it to the underlying Writer, or
+// buffers it if the output is to be sorted.
+func (e *encoder) writeRow(row []string) error {
+	if s := e.opts.Schema; s != nil {
+		for i, c := range s.Columns {
+			if c.Required && row[i] == "" {
+				return fmt.Errorf("row %d: required column %q is empty", e.rows+1, c.Name)
+			}
+		}
+	}
+	if e.opts.SkipEmptyRows && allEmpty(row) {
+		e.skips.skip(e.rows+1, "", SkipEmptyRow)
+		return nil
+	}
+	if e.opts.Dedupe {
+		if e.seen == nil {
+			s, err := newDedupe(e.hm, e.opts.DedupeBy, e.opts.DedupeMaxKeys)
+			if err != nil {
+				return err
+			}
+			e.seen = s
+		}
+		if e.seen.dup(row) {
+			e.skips.skip(e.rows+1, "", SkipDuplicateRow)
+			return nil
+		}
+	}
+	if len(e.opts.SortBy) > 0 {
+		if e.sort == nil {
+			s, err := newSorter(e.hm, e.opts.SortBy, e.opts.SortMemoryRows)
+			if err != nil {
+				return err
+			}
+			e.sort = s
+		}
+		if err := e.sort.add(row); err != nil {
+			return err
+		}
+	} else if err := e.write(row); err != nil {
 		return err
 	}
-	e.w.Flush()
+	e.rows++
+	if e.opts.Metrics != nil {
+		e.opts.Metrics.Add(MetricRowsEncoded, 1)
+	}
+	return nil
+}
+
+// writeErr names the column of an unquotable or unmappable cell in err.
+func (e *encoder) writeErr(err error) error {
+	switch err := err.(type) {
+	case *unquotableError:
+		if err.field < len(e.headers) {
+			return fmt.Errorf("column %q contains %q, which can't be written without quotes", e.headers[err.field], err.char)
+		}
+	case *unmappableError:
+		if err.field < len(e.headers) {
+			return fmt.Errorf("column %q contains %q, which can't be encoded in the output charset", e.headers[err.field], err.char)
+		}
+	}
+	return err
+}
+
+// allEmpty reports whether all of the cells of row are empty.
+func allEmpty(row []string) bool {
+	for _, c := range row {
+		if c != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// write writes row and flushes it to the underlying Writer.
+func (e *encoder) write(row []string) error {
+	if n := e.opts.RepeatHeaderEvery; n > 0 && e.written > 0 && e.written%n == 0 &&
+		len(e.headers) > 0 && !e.opts.SkipHeader {
+		if err := e.w.Write(e.headers); err != nil {
+			return err
+		}
+	}
+	if err := e.w.WriteData(row); err != nil {
+		return e.writeErr(err)
+	}
+	e.written++
+	if e.opts.BufferSize == 0 {
+		e.flush()
+	}
 	return e.w.Error()
 }
+
+// flush flushes the writer and reports the bytes written to Metrics.
+func (e *encoder) flush() {
+	e.w.Flush()
+	if e.opts.Metrics != nil {
+		e.opts.Metrics.Add(MetricFlushes, 1)
+		e.report()
+	}
+}
+
+// report reports the bytes written since the last report to Metrics.
+func (e *encoder) report() {
+	if e.opts.Metrics != nil {
+		e.opts.Metrics.Add(MetricBytesWritten, e.cw.n-e.counted)
+		e.counted = e.cw.n
+	}
+}
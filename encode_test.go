@@ -1,8 +1,16 @@
 package csvstruct
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -55,6 +63,15 @@ a
 		}{"a", "b", "c", "d"}},
 		`renamed_foo,Bar,Baz
 a,b,d
+`,
+	}, {
+		// Tag options are not part of the column name.
+		[]interface{}{struct {
+			Foo string `csv:"foo,omitempty"`
+			Bar string `csv:",omitempty"`
+		}{"a", "b"}},
+		`foo,Bar
+a,b
 `,
 	}, {
 		// If the first row contains no encodable fields, further rows
@@ -142,6 +159,18 @@ d,e,f
 	}, {
 		EncodeOpts{UseCRLF: true},
 		"A,B,C\r\na,b,c\r\nd,e,f\r\n",
+	}, {
+		EncodeOpts{TrailingComma: true, Comma: '|'},
+		`A|B|C|
+a|b|c|
+d|e|f|
+`,
+	}, {
+		EncodeOpts{TrailingComma: true, Align: AlignDelimited},
+		`A,B,C,
+a,b,c,
+d,e,f,
+`,
 	}} {
 		var buf bytes.Buffer
 		e := NewEncoder(&buf).Opts(c.opts)
@@ -150,6 +179,9 @@ d,e,f
 				t.Errorf("EncodeNext(%v): %v", r, err)
 			}
 		}
+		if err := e.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
 		if got := buf.String(); got != c.want {
 			t.Errorf("EncodeNext(%v): got %s, want %s", rows, got, c.want)
 		}
@@ -214,6 +246,854 @@ true
 	}
 }
 
+func TestEncode_Order(t *testing.T) {
+	type row struct {
+		ID      int               `csv:"id,order=-1"`
+		Name    string            `csv:"name"`
+		Created string            `csv:"created,order=10"`
+		Extra   map[string]string `csv:",inline,order=5"`
+		Email   string            `csv:"email"`
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	in := row{1, "ada", "2024", map[string]string{"x": "y"}, "a@b"}
+	if err := e.EncodeNext(in); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	want := "id,name,email,x,created\n1,ada,a@b,y,2024\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	var out row
+	if err := NewDecoder(&buf).DecodeNext(&out); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("decoded %+v, want %+v", out, in)
+	}
+}
+
+// Tests that the fields of embedded structs are ordered with the others.
+func TestEncode_OrderEmbedded(t *testing.T) {
+	type Audit struct {
+		Created string `csv:"created,order=10"`
+		Name    string `csv:"name"` // shadowed by row's Name
+		By      string `csv:"by"`
+	}
+	type row struct {
+		Name string `csv:"name"`
+		Audit
+		ID int `csv:"id,order=-1"`
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	in := row{"ada", Audit{"2024", "hidden", "bob"}, 1}
+	if err := e.EncodeNext(in); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	want := "id,name,by,created\n1,ada,bob,2024\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	var out row
+	if err := NewDecoder(&buf).DecodeNext(&out); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	in.Audit.Name = ""
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("decoded %+v, want %+v", out, in)
+	}
+}
+
+func TestEncode_BadTagOptions(t *testing.T) {
+	for _, c := range []struct {
+		v    interface{}
+		want string
+	}{
+		{struct {
+			A string `csv:"a,order=first"`
+		}{}, `field A: order "first" is not an integer`},
+		{struct {
+			A int `csv:"a,onerror=panic"`
+		}{}, `field A: unknown onerror value "panic"`},
+		{struct {
+			A string `csv:"a,omitempy"`
+		}{}, `field A: unknown tag option "omitempy"`},
+		{struct {
+			A string `csv:"a,"`
+		}{}, `field A: empty tag option`},
+	} {
+		err := NewEncoder(&bytes.Buffer{}).EncodeNext(c.v)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("EncodeNext(%T): got error %v, want %q", c.v, err, c.want)
+		}
+		p := reflect.New(reflect.TypeOf(c.v)).Interface()
+		err = NewDecoder(strings.NewReader("a\n1\n")).DecodeNext(p)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("DecodeNext(%T): got error %v, want %q", p, err, c.want)
+		}
+	}
+}
+
+func TestEncode_SortHeaders(t *testing.T) {
+	type row struct {
+		Foo   string
+		Bar   bool
+		Baz   int
+		Extra map[string]interface{} `csv:",inline"`
+	}
+	encode := func(v interface{}, options ...Option) string {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf, options...)
+		if err := e.EncodeNext(v); err != nil {
+			t.Errorf("EncodeNext(%v): %v", v, err)
+		}
+		e.Close()
+		return buf.String()
+	}
+	want := "Bar,Baz,Foo,Qux\ntrue,1,a,q\n"
+	if got := encode(row{"a", true, 1, map[string]interface{}{"Qux": "q"}}, WithSortHeaders()); got != want {
+		t.Errorf("struct: got %q, want %q", got, want)
+	}
+	if got := encode(map[string]interface{}{"Foo": "a", "Bar": true, "Baz": 1, "Qux": "q"}); got != want {
+		t.Errorf("map: got %q, want %q", got, want)
+	}
+
+	// Columns for empty rows are sorted too.
+	if got := encode((*row)(nil), WithSortHeaders(), WithNilRows(EmptyRowNil)); got != "Bar,Baz,Foo\n,,\n" {
+		t.Errorf("nil row: got %q", got)
+	}
+	if err := NewEncoder(io.Discard, WithSortHeaders(), WithColumns("Foo")).EncodeNext(row{}); err == nil {
+		t.Errorf("SortHeaders with Columns: expected error")
+	}
+}
+
+func TestEncode_Gzip(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{Compression: Gzip, CompressionLevel: gzip.BestCompression})
+	for _, r := range []struct{ A, B string }{{"a", "b"}, {"c", "d"}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := "A,B\na,b\nc,d\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if err := e.EncodeNext(struct{ A string }{"e"}); err == nil || err.Error() != "encoder closed" {
+		t.Errorf("EncodeNext after Close: got error %v, want %q", err, "encoder closed")
+	}
+
+	e = NewEncoder(&buf).Opts(EncodeOpts{Compression: Gzip, CompressionLevel: 42})
+	if err := e.EncodeNext(struct{ A string }{"a"}); err == nil {
+		t.Errorf("expected error for invalid compression level")
+	}
+}
+
+// Tests that Opts after the first row keeps the compressed stream intact,
+// and rejects changes to the compression.
+func TestEncode_GzipOpts(t *testing.T) {
+	type row struct{ A float64 }
+	var buf bytes.Buffer
+	opts := EncodeOpts{Compression: Gzip}
+	e := NewEncoder(&buf).Opts(opts)
+	if err := e.EncodeNext(row{1.5}); err != nil {
+		t.Errorf("EncodeNext: %v", err)
+	}
+	opts.FloatFormat = "%.2f"
+	if err := e.Opts(opts).EncodeNext(row{2.5}); err != nil {
+		t.Errorf("EncodeNext after Opts: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := "A\n1.500000\n2.50\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for i, change := range []EncodeOpts{
+		{},
+		{Compression: Gzip, CompressionLevel: gzip.BestSpeed},
+		{Compression: Gzip, Middleware: []WriterMiddleware{GzipMiddleware(gzip.BestSpeed)}},
+	} {
+		e := NewEncoder(&bytes.Buffer{}).Opts(EncodeOpts{Compression: Gzip})
+		if err := e.EncodeNext(row{1}); err != nil {
+			t.Errorf("EncodeNext: %v", err)
+		}
+		if err := e.Opts(change).EncodeNext(row{2}); err == nil {
+			t.Errorf("change %d after the header: expected error", i)
+		}
+	}
+}
+
+// xorWriter XORs each byte written to w with key, as a stand-in for
+// encryption.
+type xorWriter struct {
+	w      io.Writer
+	key    byte
+	closed *[]string
+}
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	for i := range p {
+		b[i] = p[i] ^ x.key
+	}
+	return x.w.Write(b)
+}
+
+func (x xorWriter) Close() error {
+	*x.closed = append(*x.closed, fmt.Sprintf("xor%d", x.key))
+	return nil
+}
+
+func TestEncode_Middleware(t *testing.T) {
+	var closed []string
+	xor := func(key byte) WriterMiddleware {
+		return func(w io.Writer) (io.WriteCloser, error) { return xorWriter{w, key, &closed}, nil }
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{
+		Compression: Gzip,
+		Middleware:  []WriterMiddleware{xor(1), xor(2)},
+	})
+	if err := e.EncodeNext(struct{ A string }{"a"}); err != nil {
+		t.Errorf("EncodeNext: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if want := []string{"xor1", "xor2"}; !reflect.DeepEqual(closed, want) {
+		t.Errorf("got closed %v, want %v", closed, want)
+	}
+	// Undo the middleware, then the compression.
+	b := buf.Bytes()
+	for i := range b {
+		b[i] ^= 1 ^ 2
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := "A\na\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	e = NewEncoder(&buf).Opts(EncodeOpts{Middleware: []WriterMiddleware{
+		func(io.Writer) (io.WriteCloser, error) { return nil, errors.New("boom") },
+	}})
+	if err := e.EncodeNext(struct{ A string }{"a"}); err == nil {
+		t.Errorf("expected error from middleware")
+	}
+}
+
+func TestEncode_Context(t *testing.T) {
+	var buf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	e := NewEncoder(&buf)
+	if err := e.EncodeNextContext(ctx, struct{ A string }{"a"}); err != nil {
+		t.Errorf("EncodeNextContext: %v", err)
+	}
+	cancel()
+	if err := e.EncodeNextContext(ctx, struct{ A string }{"b"}); err != context.Canceled {
+		t.Errorf("EncodeNextContext: got %v, want %v", err, context.Canceled)
+	}
+	if got, want := buf.String(), "A\na\n"; got != want {
+		t.Errorf("EncodeNextContext: got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_SortBy(t *testing.T) {
+	type row struct {
+		Name string
+		N    int
+	}
+	rows := []row{{"b", 10}, {"a", 9}, {"b", 2}, {"c", 1}, {"a", 9}, {"a", 100}}
+	want := "Name,N\na,9\na,9\na,100\nb,2\nb,10\nc,1\n"
+	// Check both in memory and with spilling to temporary files.
+	for _, max := range []int{0, 2} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(EncodeOpts{SortBy: []string{"Name", "N"}, SortMemoryRows: max})
+		for _, r := range rows {
+			if err := e.EncodeNext(r); err != nil {
+				t.Errorf("EncodeNext(%v): %v", r, err)
+			}
+		}
+		if got := buf.String(); strings.Contains(got, "a,") {
+			t.Errorf("SortMemoryRows=%d: rows written before Close: %q", max, got)
+		}
+		if err := e.Close(); err != nil {
+			t.Errorf("SortMemoryRows=%d: Close: %v", max, err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("SortMemoryRows=%d: got %q, want %q", max, got, want)
+		}
+	}
+
+	e := NewEncoder(&bytes.Buffer{}).Opts(EncodeOpts{SortBy: []string{"Missing"}})
+	if err := e.EncodeNext(row{"a", 1}); err == nil {
+		t.Errorf("expected error for missing sort column")
+	}
+}
+
+// Tests that rows spilled to temporary files while sorting read back exactly,
+// including empty one-cell rows and carriage returns in cells.
+func TestEncode_SortBySpill(t *testing.T) {
+	rows := []map[string]string{{"A": "b"}, {"A": ""}, {"A": "a\r\nx"}, {"A": ""}, {"A": "a"}}
+	var outs []string
+	for _, max := range []int{0, 1, 2} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(EncodeOpts{SortBy: []string{"A"}, SortMemoryRows: max})
+		for _, r := range rows {
+			if err := e.EncodeNext(r); err != nil {
+				t.Errorf("EncodeNext(%v): %v", r, err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Errorf("SortMemoryRows=%d: Close: %v", max, err)
+		}
+		outs = append(outs, buf.String())
+	}
+	if !strings.Contains(outs[0], "a\r\nx") {
+		t.Errorf("in memory: got %q, want a carriage return kept", outs[0])
+	}
+	for i, max := range []int{1, 2} {
+		if outs[i+1] != outs[0] {
+			t.Errorf("SortMemoryRows=%d: got %q, want %q as in memory", max, outs[i+1], outs[0])
+		}
+	}
+}
+
+// Tests that columns mixing numbers and other cells sort consistently, with
+// numbers first.
+func TestEncode_SortByMixed(t *testing.T) {
+	rows := []map[string]string{{"A": "b"}, {"A": "10"}, {"A": ""}, {"A": "9"}, {"A": "NaN"}, {"A": "a"}, {"A": "-1"}}
+	want := "A\n-1\n9\n10\n\nNaN\na\nb\n"
+	for _, max := range []int{0, 2} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(EncodeOpts{SortBy: []string{"A"}, SortMemoryRows: max})
+		for _, r := range rows {
+			if err := e.EncodeNext(r); err != nil {
+				t.Errorf("EncodeNext(%v): %v", r, err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Errorf("SortMemoryRows=%d: Close: %v", max, err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("SortMemoryRows=%d: got %q, want %q", max, got, want)
+		}
+	}
+}
+
+func TestEncode_Dedupe(t *testing.T) {
+	type row struct{ ID, Name string }
+	rows := []row{{"1", "a"}, {"2", "b"}, {"1", "a"}, {"1", "c"}, {"3", "d"}, {"3", "d"}}
+	for _, c := range []struct {
+		opts EncodeOpts
+		want string
+	}{{
+		EncodeOpts{Dedupe: true},
+		"ID,Name\n1,a\n2,b\n1,c\n3,d\n",
+	}, {
+		EncodeOpts{Dedupe: true, DedupeBy: []string{"ID"}},
+		"ID,Name\n1,a\n2,b\n3,d\n",
+	}, {
+		// Only the first two rows are remembered.
+		EncodeOpts{Dedupe: true, DedupeMaxKeys: 2},
+		"ID,Name\n1,a\n2,b\n1,c\n3,d\n3,d\n",
+	}} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(c.opts)
+		for _, r := range rows {
+			if err := e.EncodeNext(r); err != nil {
+				t.Errorf("EncodeNext(%v): %v", r, err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.opts, got, c.want)
+		}
+	}
+}
+
+func TestEncode_Mask(t *testing.T) {
+	type row struct {
+		Name  string `csv:"name"`
+		SSN   string `csv:"ssn,mask"`
+		PIN   string `csv:"pin,mask"`
+		Email string `csv:"email"`
+	}
+	var counts map[string]int
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{
+		Maskers: map[string]func(string) string{
+			"email": func(s string) string { return "redacted@" + s[strings.Index(s, "@")+1:] },
+		},
+		OnMask: func(c map[string]int) { counts = c },
+	})
+	for _, r := range []row{
+		{"alice", "123-45-6789", "1234", "alice@example.com"},
+		{"bob", "", "98765", "bob@example.org"},
+	} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	want := `name,ssn,pin,email
+alice,*******6789,****,redacted@example.com
+bob,,*****,redacted@example.org
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if want := map[string]int{"ssn": 1, "pin": 2, "email": 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("got counts %v, want %v", counts, want)
+	}
+}
+
+func TestEncode_NilRows(t *testing.T) {
+	type row struct{ A, B string }
+	var nilRow *row
+	for _, c := range []struct {
+		policy  NilPolicy
+		want    string
+		wantErr bool
+	}{
+		{SkipNil, "A,B\na,b\n", false},
+		{EmptyRowNil, "A,B\n,\na,b\n,\n", false},
+		{ErrorNil, "", true},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(EncodeOpts{NilRows: c.policy})
+		var err error
+		for _, v := range []interface{}{nilRow, row{"a", "b"}, nil} {
+			if err = e.EncodeNext(v); err != nil {
+				break
+			}
+		}
+		if gotErr := err != nil; gotErr != c.wantErr {
+			t.Errorf("policy %d: got error %v, want error %t", c.policy, err, c.wantErr)
+		}
+		if err := e.Close(); err != nil {
+			t.Errorf("policy %d: Close: %v", c.policy, err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("policy %d: got %q, want %q", c.policy, got, c.want)
+		}
+	}
+}
+
+func TestEncode_InterfaceFields(t *testing.T) {
+	type row struct {
+		N int
+		V interface{}
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{FloatFormat: "%g"})
+	for i, v := range []interface{}{"s", 42, uint8(7), 1.5, true, nil, net.IPv4(10, 0, 0, 1), float32(2.5), []int{1, 2}} {
+		if err := e.EncodeNext(row{i, v}); err != nil {
+			t.Errorf("EncodeNext(%v): %v", v, err)
+		}
+	}
+	want := "N,V\n0,s\n1,42\n2,7\n3,1.5\n4,true\n5,\n6,10.0.0.1\n7,2.5\n8,[1 2]\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_Slice(t *testing.T) {
+	type row struct{ A, B string }
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.EncodeNext([]row{{"a", "b"}, {"c", "d"}}); err != nil {
+		t.Errorf("EncodeNext([]row): %v", err)
+	}
+	if err := e.EncodeNext([]*row{{"e", "f"}, nil}); err != nil {
+		t.Errorf("EncodeNext([]*row): %v", err)
+	}
+	if err := e.EncodeNext([]map[string]interface{}{{"A": "g", "B": 1}}); err != nil {
+		t.Errorf("EncodeNext([]map): %v", err)
+	}
+	if err := e.EncodeNext([]int{1}); err == nil {
+		t.Errorf("EncodeNext([]int): expected error")
+	}
+	want := "A,B\na,b\nc,d\ne,f\ng,1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_UnitRow(t *testing.T) {
+	type row struct {
+		Time  string
+		Speed float64 `csv:"speed,unit=km/h,desc=Ground speed"`
+		Alt   int     `csv:"alt,unit=m"`
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{UnitRow: true, DescriptionRow: true, FloatFormat: "%g"})
+	if err := e.EncodeNext(row{"12:00", 88.5, 1200}); err != nil {
+		t.Errorf("EncodeNext: %v", err)
+	}
+	want := "Time,speed,alt\n,km/h,m\n,Ground speed,\n12:00,88.5,1200\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_RepeatHeaderEvery(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{RepeatHeaderEvery: 2})
+	for _, r := range []struct{ A string }{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	want := "A\na\nb\nA\nc\nd\nA\ne\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_OrderedMap(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	var m OrderedMap
+	m.Set("zeta", 1)
+	m.Set("alpha", "a")
+	m.Set("mid", true)
+	m.Set("zeta", 2)
+	if err := e.EncodeNext(&m); err != nil {
+		t.Errorf("EncodeNext: %v", err)
+	}
+	// Later rows may be plain maps.
+	if err := e.EncodeNext(map[string]interface{}{"alpha": "b", "zeta": 3}); err != nil {
+		t.Errorf("EncodeNext: %v", err)
+	}
+	want := "zeta,alpha,mid\n2,a,true\n3,b,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_Columns(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{Columns: []string{"name", "id"}})
+	if err := e.EncodeNext(map[string]interface{}{"id": 1, "name": "a", "extra": "x"}); err != nil {
+		t.Errorf("EncodeNext: %v", err)
+	}
+	want := "name,id\na,1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_ColumnsTemplate(t *testing.T) {
+	type row struct {
+		ID    int
+		Name  string
+		Email string
+	}
+	cols, err := ReadHeader(strings.NewReader("name_col,Name,Region,ID\nx,y,z,1\n"), DecodeOpts{})
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, WithColumns(cols...))
+	for _, r := range []row{{1, "a", "a@example.com"}, {2, "b", ""}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	want := "name_col,Name,Region,ID\n,a,,1\n,b,,2\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_StringMap(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, m := range []map[string]string{{"b": "1", "a": "x,y"}, {"a": "z"}} {
+		if err := e.EncodeNext(m); err != nil {
+			t.Errorf("EncodeNext(%v): %v", m, err)
+		}
+	}
+	want := "a,b\n\"x,y\",1\nz,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	type named map[string]int
+	if err := NewEncoder(&buf).EncodeNext(named{"a": 1}); err == nil {
+		t.Errorf("EncodeNext(map[string]int): expected error")
+	}
+}
+
+func TestEncode_SkipEmptyRows(t *testing.T) {
+	type row struct {
+		A string
+		B *string
+	}
+	b := "b"
+	rows := []row{{"a", &b}, {}, {"", &b}}
+	for _, c := range []struct {
+		skip bool
+		want string
+	}{
+		{false, "A,B\na,b\n,\n,b\n"},
+		{true, "A,B\na,b\n,b\n"},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(EncodeOpts{SkipEmptyRows: c.skip})
+		for _, r := range rows {
+			if err := e.EncodeNext(r); err != nil {
+				t.Errorf("EncodeNext(%v): %v", r, err)
+			}
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("SkipEmptyRows=%t: got %q, want %q", c.skip, got, c.want)
+		}
+	}
+}
+
+func TestEncode_Quote(t *testing.T) {
+	type row struct{ A, B string }
+	for _, c := range []struct {
+		opts    EncodeOpts
+		rows    []row
+		want    string
+		wantErr bool
+	}{{
+		EncodeOpts{},
+		[]row{{"a", "b,c"}, {" d", ""}},
+		"A,B\na,\"b,c\"\n\" d\",\n",
+		false,
+	}, {
+		EncodeOpts{Quote: QuoteAll},
+		[]row{{"a", "b\"c"}},
+		"\"A\",\"B\"\n\"a\",\"b\"\"c\"\n",
+		false,
+	}, {
+		EncodeOpts{Quote: QuoteNever},
+		[]row{{"a", " b"}, {"c", "d,e"}},
+		"A,B\na, b\n",
+		true,
+	}, {
+		EncodeOpts{Quote: QuoteNever, Unquotable: " "},
+		[]row{{"a", "b,c\r\nd\"e"}},
+		"A,B\na,b c d e\n",
+		false,
+	}} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(c.opts)
+		var err error
+		for _, r := range c.rows {
+			if err = e.EncodeNext(r); err != nil {
+				break
+			}
+		}
+		if (err != nil) != c.wantErr {
+			t.Errorf("%+v: got error %v, want error %t", c.opts, err, c.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), `column "B"`) {
+			t.Errorf("%+v: error %q doesn't name the column", c.opts, err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.opts, got, c.want)
+		}
+	}
+}
+
+func TestEncode_QuoteNonNumeric(t *testing.T) {
+	type row struct {
+		Name  string
+		Qty   int
+		Price *float64
+		OK    bool
+		IP    net.IP
+	}
+	price := 1.5
+	for _, c := range []struct {
+		rows []interface{}
+		want string
+	}{{
+		[]interface{}{row{"a", 1, &price, true, ip}, row{"b,c", -2, nil, false, ip}},
+		`"Name","Qty","Price","OK","IP"
+"a",1,1.500000,true,"128.0.0.1"
+"b,c",-2,"",false,"128.0.0.1"
+`,
+	}, {
+		[]interface{}{map[string]interface{}{"n": 3, "s": "x"}, map[string]interface{}{"n": 4, "s": 5}},
+		`"n","s"
+3,"x"
+4,"5"
+`,
+	}, {
+		[]interface{}{map[string]string{"n": "3"}},
+		`"n"
+"3"
+`,
+	}} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(EncodeOpts{Quote: QuoteNonNumeric})
+		for _, r := range c.rows {
+			if err := e.EncodeNext(r); err != nil {
+				t.Errorf("EncodeNext(%v): %v", r, err)
+			}
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestEncode_DedupeHeaders(t *testing.T) {
+	type row struct {
+		Name    string
+		Alias   string            `csv:"Name"`
+		Name2   string            `csv:"Name_2"`
+		Extra   map[string]string `csv:",inline"`
+		Another string            `csv:"Name"`
+	}
+	in := []row{
+		{"a", "b", "c", map[string]string{"Name": "d"}, "e"},
+		{"f", "g", "h", map[string]string{"Name": "i"}, "j"},
+	}
+	for _, c := range []struct {
+		dedupe bool
+		want   string
+	}{
+		{true, "Name,Name_3,Name_2,Name_4,Name_5\na,b,c,d,e\nf,g,h,i,j\n"},
+		{false, "Name,Name,Name_2,Name,Name\n,,c,,e\n,,h,,j\n"},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(EncodeOpts{DedupeHeaders: c.dedupe})
+		for _, r := range in {
+			if err := e.EncodeNext(r); err != nil {
+				t.Errorf("EncodeNext(%v): %v", r, err)
+			}
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("DedupeHeaders %t: got %q, want %q", c.dedupe, got, c.want)
+		}
+	}
+}
+
+func TestEncode_Newlines(t *testing.T) {
+	in := struct{ A, B string }{"a\r\nb", "c\rd\ne"}
+	for _, c := range []struct {
+		policy NewlinePolicy
+		want   string
+	}{
+		{KeepNewlines, "A,B\n\"a\r\nb\",\"c\rd\ne\"\n"},
+		{LFNewlines, "A,B\n\"a\nb\",\"c\nd\ne\"\n"},
+		{EscapeNewlines, `A,B` + "\n" + `a\nb,c\nd\ne` + "\n"},
+		{SpaceNewlines, "A,B\na b,c d e\n"},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(EncodeOpts{Newlines: c.policy})
+		if err := e.EncodeNext(in); err != nil {
+			t.Errorf("EncodeNext: %v", err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("policy %d: got %q, want %q", c.policy, got, c.want)
+		}
+	}
+}
+
+func TestEncode_ControlChars(t *testing.T) {
+	in := struct{ A string }{"a\x07b\tc\x1b[0m\u0085"}
+	for _, c := range []struct {
+		opts EncodeOpts
+		want string
+	}{
+		{EncodeOpts{}, "A\na\x07b\tc\x1b[0m\u0085\n"},
+		{EncodeOpts{ControlChars: StripControl}, "A\nab\tc[0m\n"},
+		{EncodeOpts{ControlChars: EscapeControl}, `A` + "\n" + `a\x07b` + "\t" + `c\x1b[0m\x85` + "\n"},
+		{EncodeOpts{ControlChars: ReplaceControl, ControlReplacement: "?"}, "A\na?b\tc?[0m?\n"},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(c.opts)
+		if err := e.EncodeNext(in); err != nil {
+			t.Errorf("EncodeNext: %v", err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.opts, got, c.want)
+		}
+	}
+}
+
+func TestEncode_Align(t *testing.T) {
+	type row struct {
+		Name string
+		Qty  int
+		Note string
+	}
+	in := []row{{"apple", 3, "red"}, {"kiwi", 12, "a, b"}, {"fig", 100, ""}}
+	for _, c := range []struct {
+		align AlignPolicy
+		want  string
+	}{
+		{AlignDelimited, `Name ,Qty,Note
+apple,3  ,red
+kiwi ,12 ,"a, b"
+fig  ,100,
+`},
+		{AlignSpaces, "Name   Qty  Note\n" +
+			"apple  3    red\n" +
+			"kiwi   12   \"a, b\"\n" +
+			"fig    100  \n"},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf).Opts(EncodeOpts{Align: c.align})
+		for _, r := range in {
+			if err := e.EncodeNext(r); err != nil {
+				t.Errorf("EncodeNext(%v): %v", r, err)
+			}
+		}
+		if buf.Len() != 0 {
+			t.Errorf("align %d: rows written before Close: %q", c.align, buf.String())
+		}
+		if err := e.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("align %d: got %q, want %q", c.align, got, c.want)
+		}
+	}
+}
+
 // Tests that encoding a struct then encoding a compatible map works as expected.
 func TestEncode_Hybrid(t *testing.T) {
 	var buf bytes.Buffer
@@ -241,6 +1121,40 @@ c,d
 	}
 }
 
+// Tests that encoding a map then encoding a compatible struct works as expected.
+func TestEncode_HybridMapFirst(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	m := map[string]interface{}{
+		"foo": "a",
+		"Bar": "b",
+		"Baz": 1,
+	}
+	if err := e.EncodeNext(m); err != nil {
+		t.Errorf("EncodeNext(%v): %v", m, err)
+	}
+	// Structs map into the map's columns by tag or field name.
+	s := struct {
+		Foo   string `csv:"foo"`
+		Bar   string
+		Extra string
+	}{"c", "d", "dropped"}
+	if err := e.EncodeNext(s); err != nil {
+		t.Errorf("EncodeNext(%v): %v", s, err)
+	}
+	if err := e.EncodeNext(map[string]string{"Baz": "2"}); err != nil {
+		t.Errorf("EncodeNext: %v", err)
+	}
+	want := `Bar,Baz,foo
+b,1,a
+d,,c
+,2,
+`
+	if got := buf.String(); got != want {
+		t.Errorf("EncodeNext(%v): got %s, want %s", s, got, want)
+	}
+}
+
 // Tests that values that implement encoding.TextMarshaler are correctly marshaled.
 func TestEncode_TextMarshaler(t *testing.T) {
 	var buf bytes.Buffer
@@ -269,10 +1183,76 @@ func TestEncode_Ptrs(t *testing.T) {
 	if err := e.EncodeNext(s); err != nil {
 		t.Errorf("EncodeNext(%v): %v", r, err)
 	}
+	// Pointers to structs are dereferenced, and nil pointers skipped.
+	if err := e.EncodeNext(&s); err != nil {
+		t.Errorf("EncodeNext(%v): %v", &s, err)
+	}
+	var nilRow *struct{ S string }
+	if err := e.EncodeNext(nilRow); err != nil {
+		t.Errorf("EncodeNext(nil pointer): %v", err)
+	}
 	want := `S,SP
 bar,bar
+bar,bar
 `
 	if got := buf.String(); got != want {
 		t.Errorf("EncodeNext(%v): got %s, want %s", s, got, want)
 	}
 }
+
+func TestEncode_OptsAfterHeader(t *testing.T) {
+	type row struct{ A, B string }
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{Comma: ';'})
+	if err := e.EncodeNext(row{"a", "b"}); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	// Options that don't change the layout can still be set.
+	if err := e.Opts(EncodeOpts{Comma: ';', Quote: QuoteAll}).EncodeNext(row{"c", "d"}); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	if err := e.Opts(EncodeOpts{Comma: ','}).EncodeNext(row{"e", "f"}); err == nil {
+		t.Errorf("EncodeNext after changing Comma: expected error")
+	}
+	if got, want := buf.String(), "A;B\na;b\n\"c\";\"d\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_BufferSize(t *testing.T) {
+	type row struct{ A, B string }
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{BufferSize: 64})
+	if err := e.EncodeNext(row{"a", "b"}); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q before the buffer filled", buf.String())
+	}
+	if err := e.EncodeNext(row{strings.Repeat("x", 100), "c"}); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Errorf("wrote nothing after the buffer filled")
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got, want := buf.String(), "A,B\na,b\n"+strings.Repeat("x", 100)+",c\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A *bufio.Writer is flushed on Close.
+	buf.Reset()
+	bw := bufio.NewWriter(&buf)
+	e = NewEncoder(bw)
+	if err := e.EncodeNext(row{"a", "b"}); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got, want := buf.String(), "A,B\na,b\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
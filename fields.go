@@ -0,0 +1,195 @@
+package csvstruct
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// field describes a struct field that maps to a CSV column.
+type field struct {
+	name      string // column name
+	index     []int  // index sequence of the field, as for FieldByIndex
+	omitempty bool
+	mask      bool   // redact the value when encoding
+	braced    bool   // wrap UUIDs in braces when encoding
+	upper     bool   // write UUIDs in upper case when encoding
+	inline    bool   // a map whose keys are columns
+	allowdup  bool   // may share its column name with other fields
+	onError   string // "zero" or "skiprow" to tolerate unparseable cells, from "onerror="
+	unit      string // unit of the column's values, from "unit="
+	desc      string // description of the column, from "desc="
+	lookup    string // name of the lookup of an integer field's labels, from "lookup="
+	template  string // template or template name formatting the cell, from "template="
+	format    string // fmt format of the cell, from Mapping.Format
+	order     int    // weight of the column in the header, from "order="
+}
+
+// structFields returns the fields of the struct type t that map to CSV
+// columns, in column order, as described in the package documentation. If
+// protoNames is set, untagged fields of generated protobuf messages are
+// named by their JSON names. A Mapping m, if not nil, overrides the tags of
+// the fields it names.
+func structFields(t reflect.Type, protoNames bool, m *Mapping) ([]field, error) {
+	fs, err := typeFields(t, protoNames, m)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(fs, func(i, j int) bool { return fs[i].order < fs[j].order })
+	return fs, nil
+}
+
+// typeFields returns the fields of t in declaration order, with the fields
+// of embedded structs in place.
+func typeFields(t reflect.Type, protoNames bool, m *Mapping) ([]field, error) {
+	fs := []field{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("csv")
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && tag != "-" {
+			efs, err := typeFields(f.Type, protoNames, m)
+			if err != nil {
+				return nil, err
+			}
+			for _, ef := range efs {
+				ef.index = append([]int{i}, ef.index...)
+				fs = append(fs, ef)
+			}
+			continue
+		}
+		if f.Anonymous || f.PkgPath != "" {
+			continue
+		}
+		fd := field{name: f.Name, index: []int{i}}
+		fm := m.lookup(f.Name)
+		if fm != nil {
+			if fm.ignore {
+				continue
+			}
+			if fm.tag != nil {
+				tag = *fm.tag
+			}
+		}
+		if tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if i := strings.Index(tag, ",template="); i >= 0 {
+				// Templates may contain commas, so they take the rest of
+				// the tag.
+				tag, fd.template = tag[:i], tag[i+len(",template="):]
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				fd.name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				switch opt {
+				case "omitempty":
+					fd.omitempty = true
+				case "mask":
+					fd.mask = true
+				case "braced":
+					fd.braced = true
+				case "upper":
+					fd.upper = true
+				case "allowdup":
+					fd.allowdup = true
+				case "inline":
+					fd.inline = f.Type.Kind() == reflect.Map && f.Type.Key().Kind() == reflect.String
+				default:
+					if strings.HasPrefix(opt, "unit=") {
+						fd.unit = opt[len("unit="):]
+					} else if strings.HasPrefix(opt, "desc=") {
+						fd.desc = opt[len("desc="):]
+					} else if strings.HasPrefix(opt, "onerror=") {
+						switch fd.onError = opt[len("onerror="):]; fd.onError {
+						case "zero", "skiprow", "fail":
+						default:
+							return nil, fmt.Errorf("field %s: unknown onerror value %q", f.Name, fd.onError)
+						}
+					} else if strings.HasPrefix(opt, "lookup=") {
+						fd.lookup = opt[len("lookup="):]
+					} else if strings.HasPrefix(opt, "order=") {
+						n, err := strconv.Atoi(opt[len("order="):])
+						if err != nil {
+							return nil, fmt.Errorf("field %s: order %q is not an integer", f.Name, opt[len("order="):])
+						}
+						fd.order = n
+					} else if opt == "" {
+						return nil, fmt.Errorf("field %s: empty tag option", f.Name)
+					} else {
+						return nil, fmt.Errorf("field %s: unknown tag option %q", f.Name, opt)
+					}
+				}
+			}
+		} else if protoNames {
+			if _, ok := f.Tag.Lookup("protobuf_oneof"); ok {
+				// Oneof wrappers have no single column.
+				continue
+			}
+			if n := protoName(f.Tag.Get("protobuf")); n != "" {
+				fd.name = n
+			}
+		}
+		if fm != nil {
+			if fm.column != "" {
+				fd.name = fm.column
+			}
+			fd.format = fm.format
+			if ft := f.Type; floatPrecision(fd.format) {
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() != reflect.Float32 && ft.Kind() != reflect.Float64 {
+					return nil, fmt.Errorf("field %s: Format %q has a precision, but the field isn't a float", f.Name, fd.format)
+				}
+			}
+		}
+		fs = append(fs, fd)
+	}
+
+	// Fields of t shadow the fields of embedded structs with the same
+	// column.
+	own := map[string]bool{}
+	for _, f := range fs {
+		if len(f.index) == 1 && !f.inline {
+			own[f.name] = true
+		}
+	}
+	out := fs[:0]
+	for _, f := range fs {
+		if len(f.index) == 1 || f.inline || !own[f.name] {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// protoName returns the JSON name, or else the field name, from the protobuf
+// struct tag of a generated message field, e.g.
+// `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3"`.
+func protoName(tag string) string {
+	name := ""
+	for _, part := range strings.Split(tag, ",") {
+		if strings.HasPrefix(part, "json=") {
+			return part[len("json="):]
+		}
+		if strings.HasPrefix(part, "name=") {
+			name = part[len("name="):]
+		}
+	}
+	return name
+}
+
+// inlineKeys returns the sorted keys of the inline map mv.
+func inlineKeys(mv reflect.Value) []string {
+	keys := make([]string, 0, mv.Len())
+	for _, k := range mv.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
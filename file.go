@@ -0,0 +1,132 @@
+package csvstruct
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// DecodeFile decodes every row of the named file in fsys into dst, which must
+// be a pointer to a slice of structs, struct pointers or maps.
+func DecodeFile(fsys fs.FS, name string, dst interface{}, opts DecodeOpts) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return decodeAll(NewDecoder(f).Opts(opts), dst)
+}
+
+// EncodeFile encodes every element of src, which must be a slice of structs,
+// struct pointers or maps, into the named file.
+//
+// The output is written to a temporary file in the same directory, which is
+// renamed to name only once encoding succeeds, so readers never observe a
+// partially written file.
+func EncodeFile(name string, src interface{}, opts EncodeOpts) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	e := NewEncoder(bw).Opts(opts)
+	if err := encodeAll(e, src); err != nil {
+		return err
+	}
+	if err := e.Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// decodeAll appends every remaining row decoded by d to the slice pointed to
+// by dst.
+func decodeAll(d Decoder, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("must be pointer to slice")
+	}
+	sv := rv.Elem()
+	et := sv.Type().Elem()
+	for {
+		var ev reflect.Value
+		switch et.Kind() {
+		case reflect.Ptr:
+			ev = reflect.New(et.Elem())
+		case reflect.Map:
+			ev = reflect.New(et)
+			ev.Elem().Set(reflect.MakeMap(et))
+		default:
+			ev = reflect.New(et)
+		}
+		if err := d.DecodeNext(ev.Interface()); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if et.Kind() != reflect.Ptr {
+			ev = ev.Elem()
+		}
+		sv.Set(reflect.Append(sv, ev))
+	}
+}
+
+// encodeAll encodes every element of src, a slice or channel, with e.
+func encodeAll(e Encoder, src interface{}) error {
+	return forEach(src, func(v interface{}) error { return e.EncodeNext(v) })
+}
+
+// forEach calls fn with every element of src, which must be a slice or a
+// channel that can be received from. Pointer elements are dereferenced, and
+// nil pointers are skipped.
+func forEach(src interface{}, fn func(interface{}) error) error {
+	rv := reflect.ValueOf(src)
+	var next func() (reflect.Value, bool)
+	switch rv.Kind() {
+	case reflect.Slice:
+		i := 0
+		next = func() (reflect.Value, bool) {
+			if i == rv.Len() {
+				return reflect.Value{}, false
+			}
+			i++
+			return rv.Index(i - 1), true
+		}
+	case reflect.Chan:
+		next = rv.Recv
+	default:
+		return errors.New("must be slice or channel")
+	}
+	for ev, ok := next(); ok; ev, ok = next() {
+		if ev.Kind() == reflect.Ptr {
+			if ev.IsNil() {
+				continue
+			}
+			ev = ev.Elem()
+		}
+		if err := fn(ev.Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
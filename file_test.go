@@ -0,0 +1,68 @@
+package csvstruct
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDecodeFile(t *testing.T) {
+	type row struct{ A, B string }
+	fsys := fstest.MapFS{"in.csv": {Data: []byte("A,B\na,b\nc,d\n")}}
+
+	var rows []row
+	if err := DecodeFile(fsys, "in.csv", &rows, DecodeOpts{}); err != nil {
+		t.Fatalf("DecodeFile: %v", err)
+	}
+	if want := []row{{"a", "b"}, {"c", "d"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("DecodeFile: got %v, want %v", rows, want)
+	}
+
+	var ptrs []*row
+	if err := DecodeFile(fsys, "in.csv", &ptrs, DecodeOpts{}); err != nil {
+		t.Fatalf("DecodeFile: %v", err)
+	}
+	if len(ptrs) != 2 || *ptrs[1] != (row{"c", "d"}) {
+		t.Errorf("DecodeFile: got %v", ptrs)
+	}
+
+	var maps []map[string]string
+	if err := DecodeFile(fsys, "in.csv", &maps, DecodeOpts{}); err != nil {
+		t.Fatalf("DecodeFile: %v", err)
+	}
+	if want := []map[string]string{{"A": "a", "B": "b"}, {"A": "c", "B": "d"}}; !reflect.DeepEqual(maps, want) {
+		t.Errorf("DecodeFile: got %v, want %v", maps, want)
+	}
+
+	if err := DecodeFile(fsys, "missing.csv", &rows, DecodeOpts{}); err == nil {
+		t.Errorf("DecodeFile(missing.csv): expected error")
+	}
+}
+
+func TestEncodeFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "out.csv")
+	rows := []struct{ A, B string }{{"a", "b"}, {"c", "d"}}
+	if err := EncodeFile(name, rows, EncodeOpts{Comma: ';'}); err != nil {
+		t.Fatalf("EncodeFile: %v", err)
+	}
+	got, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if want := "A;B\na;b\nc;d\n"; string(got) != want {
+		t.Errorf("EncodeFile: got %q, want %q", got, want)
+	}
+
+	// A failed encode leaves neither the target nor a temporary file behind.
+	bad := filepath.Join(dir, "bad.csv")
+	if err := EncodeFile(bad, []struct{ C complex128 }{{1}}, EncodeOpts{}); err == nil {
+		t.Errorf("EncodeFile: expected error")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("got %d files in output directory, want 1", len(entries))
+	}
+}
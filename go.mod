@@ -0,0 +1,11 @@
+module github.com/ImJasonH/csvstruct
+
+go 1.21
+
+require (
+	github.com/apache/arrow/go/arrow v0.0.0-20211112161151-bc219186db40
+	golang.org/x/text v0.22.0
+	google.golang.org/protobuf v1.36.5
+)
+
+require golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da // indirect
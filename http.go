@@ -0,0 +1,89 @@
+package csvstruct
+
+import (
+	"mime"
+	"net/http"
+)
+
+// serveFlushRows is the number of rows ServeCSV writes between flushes.
+const serveFlushRows = 100
+
+// defaultMaxUploadBytes limits the size of request bodies read by
+// DecodeRequest if DecodeOpts.MaxUploadBytes is unset.
+const defaultMaxUploadBytes = 32 << 20
+
+// uploadMemoryBytes is the amount of a multipart upload held in memory
+// before the rest is spilled to temporary files.
+const uploadMemoryBytes = 8 << 20
+
+// ServeCSV writes rows, a slice or channel of structs or maps, to w as a CSV
+// attachment named filename.
+//
+// Rows are streamed as they are encoded, and flushed to the client
+// periodically if w implements http.Flusher. If the client disconnects,
+// writing fails and ServeCSV returns the error; rows not yet received from a
+// channel are left unread.
+func ServeCSV(w http.ResponseWriter, filename string, rows interface{}, opts EncodeOpts) error {
+	ct := "text/csv; charset=" + opts.Charset.mimeName()
+	if opts.Compression == Gzip {
+		ct = "application/gzip"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	f, _ := w.(http.Flusher)
+	e := NewEncoder(w).Opts(opts)
+	n := 0
+	if err := forEach(rows, func(v interface{}) error {
+		if err := e.EncodeNext(v); err != nil {
+			return err
+		}
+		if n++; f != nil && n%serveFlushRows == 0 {
+			f.Flush()
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := e.Close(); err != nil {
+		return err
+	}
+	if f != nil {
+		f.Flush()
+	}
+	return nil
+}
+
+// DecodeRequest decodes every row of the file uploaded in the multipart form
+// field of r into dst, which must be a pointer to a slice of structs, struct
+// pointers or maps.
+//
+// Request bodies larger than opts.MaxUploadBytes are rejected. If opts does
+// not set a Charset, the charset parameter of the file's Content-Type is
+// used; byte order marks and gzip compression are detected as usual.
+func DecodeRequest(r *http.Request, field string, dst interface{}, opts DecodeOpts) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	max := opts.MaxUploadBytes
+	if max == 0 {
+		max = defaultMaxUploadBytes
+	}
+	r.Body = http.MaxBytesReader(nil, r.Body, max)
+	if err := r.ParseMultipartForm(uploadMemoryBytes); err != nil {
+		return err
+	}
+	f, fh, err := r.FormFile(field)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if opts.Charset == UTF8 {
+		if _, params, err := mime.ParseMediaType(fh.Header.Get("Content-Type")); err == nil {
+			if cs, ok := lookupCharset(params["charset"]); ok {
+				opts.Charset = cs
+			}
+		}
+	}
+	return decodeAll(NewDecoder(f).Opts(opts), dst)
+}
@@ -0,0 +1,79 @@
+package csvstruct
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServeCSV(t *testing.T) {
+	type row struct{ A, B string }
+	ch := make(chan *row, 2)
+	ch <- &row{"a", "b"}
+	ch <- &row{"c", "d"}
+	close(ch)
+
+	for _, rows := range []interface{}{[]row{{"a", "b"}, {"c", "d"}}, ch} {
+		w := httptest.NewRecorder()
+		if err := ServeCSV(w, "report 1.csv", rows, EncodeOpts{}); err != nil {
+			t.Fatalf("ServeCSV: %v", err)
+		}
+		if got, want := w.Header().Get("Content-Type"), "text/csv; charset=utf-8"; got != want {
+			t.Errorf("Content-Type: got %q, want %q", got, want)
+		}
+		if got, want := w.Header().Get("Content-Disposition"), `attachment; filename="report 1.csv"`; got != want {
+			t.Errorf("Content-Disposition: got %q, want %q", got, want)
+		}
+		if got, want := w.Body.String(), "A,B\na,b\nc,d\n"; got != want {
+			t.Errorf("body: got %q, want %q", got, want)
+		}
+		if !w.Flushed {
+			t.Errorf("response was not flushed")
+		}
+	}
+
+	if err := ServeCSV(httptest.NewRecorder(), "x.csv", row{}, EncodeOpts{}); err == nil {
+		t.Errorf("ServeCSV(struct): expected error")
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	type row struct{ Name, City string }
+	newRequest := func(contentType, body string) *http.Request {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="file"; filename="in.csv"`)
+		h.Set("Content-Type", contentType)
+		pw, _ := mw.CreatePart(h)
+		pw.Write([]byte(body))
+		mw.Close()
+		req := httptest.NewRequest("POST", "/upload", &buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req
+	}
+
+	var rows []row
+	req := newRequest("text/csv; charset=windows-1252", "Name,City\nJos\xe9,Z\xfcrich")
+	if err := DecodeRequest(req, "file", &rows, DecodeOpts{}); err != nil {
+		t.Fatalf("DecodeRequest: %v", err)
+	}
+	if want := []row{{"José", "Zürich"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("DecodeRequest: got %v, want %v", rows, want)
+	}
+
+	req = newRequest("text/csv", "Name,City\na,b")
+	if err := DecodeRequest(req, "other", &rows, DecodeOpts{}); err == nil {
+		t.Errorf("DecodeRequest(missing field): expected error")
+	}
+
+	req = newRequest("text/csv", "Name,City\n"+strings.Repeat("a,b\n", 100))
+	if err := DecodeRequest(req, "file", &rows, DecodeOpts{MaxUploadBytes: 16}); err == nil {
+		t.Errorf("DecodeRequest(oversized body): expected error")
+	}
+}
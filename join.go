@@ -0,0 +1,103 @@
+package csvstruct
+
+import (
+	"fmt"
+	"io"
+)
+
+// JoinOpts specifies options for Join.
+type JoinOpts struct {
+	Key       string // column to join on, present in both inputs
+	LeftOuter bool   // keep left rows without a match, leaving right cells empty
+
+	Decode DecodeOpts // options for reading both inputs
+	Encode EncodeOpts // options for writing the output
+}
+
+// Join writes to w the rows of left combined with each row of right that has
+// the same value in the Key column.
+//
+// The right input is held in memory, so it should be the smaller of the two;
+// the left input is streamed. The output header holds the left columns
+// followed by the right columns other than Key. Right columns whose names
+// clash with left columns are suffixed with "_right".
+func Join(w io.Writer, left, right io.Reader, opts JoinOpts) error {
+	rd := NewDecoder(right).Opts(opts.Decode).(*decoder)
+	if err := rd.readHeader(); err != nil {
+		return err
+	}
+	rkey, ok := rd.hm[opts.Key]
+	if !ok {
+		return fmt.Errorf("right input has no column %q", opts.Key)
+	}
+	table := map[string][][]string{}
+	for {
+		rec, err := rd.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if rkey < len(rec) {
+			// Copy the record, since the csv.Reader may reuse it.
+			table[rec[rkey]] = append(table[rec[rkey]], append([]string(nil), rec...))
+		}
+	}
+
+	ld := NewDecoder(left).Opts(opts.Decode).(*decoder)
+	if err := ld.readHeader(); err != nil {
+		return err
+	}
+	lkey, ok := ld.hm[opts.Key]
+	if !ok {
+		return fmt.Errorf("left input has no column %q", opts.Key)
+	}
+	header := append([]string(nil), ld.header...)
+	rcols := []int{} // indexes of the right columns in the output
+	for i, h := range rd.header {
+		if i == rkey {
+			continue
+		}
+		if _, ok := ld.hm[h]; ok {
+			h += "_right"
+		}
+		header = append(header, h)
+		rcols = append(rcols, i)
+	}
+
+	e := NewEncoder(w).Opts(opts.Encode).(*encoder)
+	if e.err != nil {
+		return e.err
+	}
+	if err := e.setHeader(header); err != nil {
+		return err
+	}
+	for {
+		rec, err := ld.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		var matches [][]string
+		if lkey < len(rec) {
+			matches = table[rec[lkey]]
+		}
+		if len(matches) == 0 && opts.LeftOuter {
+			matches = [][]string{nil}
+		}
+		for _, m := range matches {
+			row := make([]string, len(header))
+			copy(row, rec)
+			for j, i := range rcols {
+				if i < len(m) {
+					row[len(ld.header)+j] = m[i]
+				}
+			}
+			if err := e.writeRow(row); err != nil {
+				return err
+			}
+		}
+	}
+	return e.Close()
+}
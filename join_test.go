@@ -0,0 +1,45 @@
+package csvstruct
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	orders := "order,customer,total\n1,c1,10\n2,c2,20\n3,c9,30\n4,c1,40\n"
+	customers := "customer,name,total\nc1,Alice,100\nc2,Bob,200\n"
+	for _, c := range []struct {
+		outer bool
+		want  string
+	}{{
+		false,
+		`order,customer,total,name,total_right
+1,c1,10,Alice,100
+2,c2,20,Bob,200
+4,c1,40,Alice,100
+`,
+	}, {
+		true,
+		`order,customer,total,name,total_right
+1,c1,10,Alice,100
+2,c2,20,Bob,200
+3,c9,30,,
+4,c1,40,Alice,100
+`,
+	}} {
+		var buf bytes.Buffer
+		err := Join(&buf, strings.NewReader(orders), strings.NewReader(customers), JoinOpts{Key: "customer", LeftOuter: c.outer})
+		if err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("Join(LeftOuter: %t): got\n%s\nwant\n%s", c.outer, got, c.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Join(&buf, strings.NewReader(orders), strings.NewReader(customers), JoinOpts{Key: "missing"}); err == nil {
+		t.Errorf("Join: expected error for missing key column")
+	}
+}
@@ -0,0 +1,91 @@
+package csvstruct
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+)
+
+// ToJSONLines converts the CSV data read from r to JSON Lines written to w,
+// one object per row with keys in header order.
+//
+// Values are typed as when decoding into a map[string]interface{}: numbers
+// and booleans are inferred unless overridden by opts.TypeOverrides. Cells
+// of short rows are left out, and floats that JSON can't represent, such as
+// NaN, are written as strings.
+func ToJSONLines(r io.Reader, w io.Writer, opts DecodeOpts) error {
+	d := NewDecoder(r).Opts(opts).(*decoder)
+	bw := bufio.NewWriter(w)
+	m := map[string]interface{}{}
+	for {
+		// Clear the previous row, so that a short row doesn't repeat its
+		// values.
+		clear(m)
+		if err := d.DecodeNext(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		bw.WriteByte('{')
+		first := true
+		for _, h := range d.header {
+			v, ok := m[h]
+			if !ok {
+				continue
+			}
+			if !first {
+				bw.WriteByte(',')
+			}
+			first = false
+			k, _ := json.Marshal(h)
+			bw.Write(k)
+			bw.WriteByte(':')
+			if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+				v = strconv.FormatFloat(f, 'g', -1, 64)
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			bw.Write(b)
+		}
+		bw.WriteString("}\n")
+	}
+	return bw.Flush()
+}
+
+// FromJSONLines converts the JSON Lines read from r to CSV data written to w.
+// Each line must hold a JSON object; as when encoding maps, the header is
+// made of the sorted keys of the first object. Nested objects and arrays are
+// written as JSON, and nulls as empty cells.
+func FromJSONLines(r io.Reader, w io.Writer, opts EncodeOpts) error {
+	jd := json.NewDecoder(r)
+	jd.UseNumber()
+	e := NewEncoder(w).Opts(opts)
+	for {
+		var m map[string]interface{}
+		if err := jd.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		for k, v := range m {
+			switch v := v.(type) {
+			case nil:
+				m[k] = ""
+			case map[string]interface{}, []interface{}:
+				b, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				m[k] = string(b)
+			}
+		}
+		if err := e.EncodeNext(m); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
@@ -0,0 +1,59 @@
+package csvstruct
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestToJSONLines(t *testing.T) {
+	s := "name,zip,age,score,member\nAlice,02134,30,9.5,true\n\"Bob \"\"B\"\"\",94043,,7,false\n"
+	var buf bytes.Buffer
+	if err := ToJSONLines(strings.NewReader(s), &buf, DecodeOpts{TypeOverrides: map[string]Kind{"zip": KindString}}); err != nil {
+		t.Fatalf("ToJSONLines: %v", err)
+	}
+	want := `{"name":"Alice","zip":"02134","age":30,"score":9.5,"member":true}
+{"name":"Bob \"B\"","zip":"94043","age":"","score":7,"member":false}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("ToJSONLines: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestToJSONLines_Ragged(t *testing.T) {
+	s := "a,b,c\n1,,true\n2\nNan,inf,infinity\nNaN,-Inf,2\n"
+	var buf bytes.Buffer
+	opts := DecodeOpts{FieldsPerRecord: -1, TypeOverrides: map[string]Kind{"a": KindFloat}}
+	if err := ToJSONLines(strings.NewReader(s), &buf, opts); err != nil {
+		t.Fatalf("ToJSONLines: %v", err)
+	}
+	want := `{"a":1,"b":"","c":true}
+{"a":2}
+{"a":"NaN","b":"inf","c":"infinity"}
+{"a":"NaN","b":"-Inf","c":2}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("ToJSONLines: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFromJSONLines(t *testing.T) {
+	s := `{"name":"Alice","age":30,"score":9.5,"tags":["a","b"],"member":true}
+{"name":"Bob","age":null,"score":1e3,"member":false}
+`
+	var buf bytes.Buffer
+	if err := FromJSONLines(strings.NewReader(s), &buf, EncodeOpts{}); err != nil {
+		t.Fatalf("FromJSONLines: %v", err)
+	}
+	want := `age,member,name,score,tags
+30,true,Alice,9.5,"[""a"",""b""]"
+,false,Bob,1e3,
+`
+	if got := buf.String(); got != want {
+		t.Errorf("FromJSONLines: got\n%s\nwant\n%s", got, want)
+	}
+
+	if err := FromJSONLines(strings.NewReader("[1,2]"), &buf, EncodeOpts{}); err == nil {
+		t.Errorf("FromJSONLines: expected error for non-object line")
+	}
+}
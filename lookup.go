@@ -0,0 +1,118 @@
+package csvstruct
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// lookup maps the codes of an integer field to the labels written for them.
+type lookup struct {
+	labels map[int64]string
+	codes  map[string]int64
+}
+
+var (
+	lookupsMu   sync.RWMutex
+	lookups     = map[string]*lookup{}
+	typeLookups = map[reflect.Type]*lookup{}
+)
+
+func newLookup(labels map[int64]string) *lookup {
+	l := &lookup{labels: map[int64]string{}, codes: map[string]int64{}}
+	for code, label := range labels {
+		l.labels[code] = label
+		l.codes[label] = code
+	}
+	return l
+}
+
+// RegisterLookup registers labels for the codes of integer fields tagged
+// with the lookup's name, e.g. `csv:"status,lookup=status"`. Encoders write
+// each code as its label, and Decoders read the labels back as codes, so
+// that integer enums appear in files as readable labels. Codes without a
+// label, and cells that aren't labels, are an error. Labels must be unique.
+func RegisterLookup(name string, labels map[int64]string) {
+	lookupsMu.Lock()
+	defer lookupsMu.Unlock()
+	lookups[name] = newLookup(labels)
+}
+
+// RegisterTypeLookup is like RegisterLookup, but applies to all untagged
+// fields of the integer type t, or pointers to it.
+func RegisterTypeLookup(t reflect.Type, labels map[int64]string) {
+	lookupsMu.Lock()
+	defer lookupsMu.Unlock()
+	typeLookups[t] = newLookup(labels)
+}
+
+// lookupFor returns the lookup named by a field's tag, or else the one
+// registered for its type t, if t is an integer type.
+func lookupFor(name string, t reflect.Type) *lookup {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return nil
+	}
+	lookupsMu.RLock()
+	defer lookupsMu.RUnlock()
+	if name != "" {
+		return lookups[name]
+	}
+	return typeLookups[t]
+}
+
+// label returns the label of the code held by the field vf.
+func (l *lookup) label(vf reflect.Value) (string, error) {
+	if vf.Kind() == reflect.Ptr {
+		if vf.IsNil() {
+			return "", nil
+		}
+		vf = vf.Elem()
+	}
+	var code int64
+	switch vf.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		code = int64(vf.Uint())
+	default:
+		code = vf.Int()
+	}
+	s, ok := l.labels[code]
+	if !ok {
+		return "", fmt.Errorf("no label for code %d", code)
+	}
+	return s, nil
+}
+
+// setCode sets the field vf, mapped to column n, to the code labeled s.
+func (d *decoder) setCode(l *lookup, vf reflect.Value, n, s string) error {
+	code, ok := l.codes[s]
+	if !ok {
+		return fmt.Errorf("error decoding: unknown label %q", s)
+	}
+	if vf.Kind() == reflect.Ptr {
+		if vf.IsNil() {
+			vf.Set(reflect.New(vf.Type().Elem()))
+		}
+		vf = vf.Elem()
+	}
+	bits := vf.Type().Bits()
+	switch vf.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := uint64(code); code >= 0 && (bits == 64 || u>>bits == 0) {
+			vf.SetUint(u)
+			return nil
+		}
+	default:
+		if code<<(64-bits)>>(64-bits) == code {
+			vf.SetInt(code)
+			return nil
+		}
+	}
+	return d.numberError(rangeError("ParseInt", strconv.FormatInt(code, 10)), vf.Type(), n, s)
+}
@@ -0,0 +1,51 @@
+package csvstruct
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type priority uint8
+
+func TestLookup(t *testing.T) {
+	RegisterLookup("status", map[int64]string{0: "open", 1: "closed", -1: "deleted"})
+	RegisterTypeLookup(reflect.TypeOf(priority(0)), map[int64]string{1: "low", 2: "high"})
+	type ticket struct {
+		ID       int
+		Status   int8 `csv:"status,lookup=status"`
+		Priority *priority
+	}
+	high, low := priority(2), priority(1)
+	rows := []ticket{{1, 0, &high}, {2, -1, &low}, {3, 1, nil}}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, r := range rows {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if err := e.EncodeNext(ticket{4, 7, nil}); err == nil {
+		t.Errorf("EncodeNext(code without label): expected error")
+	}
+	want := "ID,status,Priority\n1,open,high\n2,deleted,low\n3,closed,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	d := NewDecoder(strings.NewReader(want + "5,lost,low\n"))
+	for _, w := range rows {
+		var got ticket
+		if err := d.DecodeNext(&got); err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("got %+v, want %+v", got, w)
+		}
+	}
+	var got ticket
+	if err := d.DecodeNext(&got); err == nil || !strings.Contains(err.Error(), `unknown label "lost"`) {
+		t.Errorf("DecodeNext(unknown label): got %v", err)
+	}
+}
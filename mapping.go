@@ -0,0 +1,84 @@
+package csvstruct
+
+// Mapping maps struct fields to columns in place of their csv tags, for
+// types that can't be annotated, like vendored or generated structs. Each
+// method after Field applies to the field it selects:
+//
+//	m := csvstruct.NewMapping().
+//		Field("CreatedAt").Column("created").Format("%.10s").
+//		Field("Internal").Ignore()
+//
+// A Mapping applies to fields of the given names in any struct type an
+// Encoder or Decoder it's set on handles. It must not be changed once in use.
+type Mapping struct {
+	fields  map[string]*fieldMapping
+	current *fieldMapping
+}
+
+type fieldMapping struct {
+	tag    *string // replaces the csv tag, if set
+	column string
+	format string
+	ignore bool
+}
+
+// NewMapping returns an empty Mapping.
+func NewMapping() *Mapping {
+	return &Mapping{fields: map[string]*fieldMapping{}}
+}
+
+// Field selects the struct field with the given Go name for the methods that
+// follow.
+func (m *Mapping) Field(name string) *Mapping {
+	fm, ok := m.fields[name]
+	if !ok {
+		fm = &fieldMapping{}
+		m.fields[name] = fm
+	}
+	m.current = fm
+	return m
+}
+
+// selected returns the selected field, panicking if there is none, as that
+// is a mistake in the program.
+func (m *Mapping) selected() *fieldMapping {
+	if m.current == nil {
+		panic("csvstruct: Mapping method called before Field")
+	}
+	return m.current
+}
+
+// Column names the column of the selected field.
+func (m *Mapping) Column(name string) *Mapping {
+	m.selected().column = name
+	return m
+}
+
+// Tag sets the csv tag of the selected field, e.g. "size,omitempty,unit=bytes",
+// in place of the one in its declaration.
+func (m *Mapping) Tag(tag string) *Mapping {
+	m.selected().tag = &tag
+	return m
+}
+
+// Format sets the fmt format, like "%.2f", that Encoders write the selected
+// field's non-nil values with. A float precision, as in "%.2f", is only
+// allowed for float fields.
+func (m *Mapping) Format(format string) *Mapping {
+	m.selected().format = format
+	return m
+}
+
+// Ignore skips the selected field, as if it were tagged "-".
+func (m *Mapping) Ignore() *Mapping {
+	m.selected().ignore = true
+	return m
+}
+
+// lookup returns the mapping of the field with the given Go name, or nil.
+func (m *Mapping) lookup(name string) *fieldMapping {
+	if m == nil {
+		return nil
+	}
+	return m.fields[name]
+}
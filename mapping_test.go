@@ -0,0 +1,69 @@
+package csvstruct
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// vendored stands for a struct that can't be given csv tags.
+type vendored struct {
+	ID       int
+	Price    float64
+	Size     int64
+	Internal string
+}
+
+func TestMapping(t *testing.T) {
+	m := NewMapping().
+		Field("ID").Column("id").
+		Field("Price").Column("price").Format("%.2f").
+		Field("Size").Tag("size,unit=bytes").
+		Field("Internal").Ignore()
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, WithMapping(m))
+	if err := e.EncodeNext(vendored{ID: 1, Price: 2.5, Size: 2048, Internal: "x"}); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	want := "id,price,size\n1,2.50,2048\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	d := NewDecoder(strings.NewReader("id,price,size,Internal\n1,2.50,2KiB,x\n"), WithMapping(m))
+	var got vendored
+	if err := d.DecodeNext(&got); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	if w := (vendored{ID: 1, Price: 2.5, Size: 2048}); !reflect.DeepEqual(got, w) {
+		t.Errorf("got %+v, want %+v", got, w)
+	}
+}
+
+func TestMapping_NoField(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Column before Field: expected panic")
+		}
+	}()
+	NewMapping().Column("a")
+}
+
+func TestMapping_FloatPrecision(t *testing.T) {
+	for _, format := range []string{"%.2f", "%8.3e", "%+.1g"} {
+		m := NewMapping().Field("ID").Format(format)
+		e := NewEncoder(&bytes.Buffer{}, WithMapping(m))
+		if err := e.EncodeNext(vendored{ID: 1}); err == nil {
+			t.Errorf("Format(%q) on an int field: expected error", format)
+		}
+	}
+	for _, format := range []string{"%05d", "%.3s", "%%.2f %d"} {
+		m := NewMapping().Field("ID").Format(format)
+		e := NewEncoder(&bytes.Buffer{}, WithMapping(m))
+		if err := e.EncodeNext(vendored{ID: 1}); err != nil {
+			t.Errorf("Format(%q) on an int field: %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package csvstruct
+
+import "io"
+
+// Merge writes the rows of each of the readers to w, in turn, under a header
+// that is the union of the readers' headers.
+//
+// Columns appear in the order they are first seen across the inputs. Cells
+// of columns an input lacks are left empty.
+func Merge(w io.Writer, readers []io.Reader, opts TransformOpts) error {
+	ds := make([]*decoder, len(readers))
+	union := []string{}
+	seen := map[string]int{}
+	for i, r := range readers {
+		ds[i] = NewDecoder(r).Opts(opts.Decode).(*decoder)
+		if err := ds[i].readHeader(); err != nil {
+			return err
+		}
+		for _, h := range ds[i].header {
+			if _, ok := seen[h]; !ok {
+				seen[h] = len(union)
+				union = append(union, h)
+			}
+		}
+	}
+
+	e := NewEncoder(w).Opts(opts.Encode).(*encoder)
+	if e.err != nil {
+		return e.err
+	}
+	if err := e.setHeader(union); err != nil {
+		return err
+	}
+	for _, d := range ds {
+		for {
+			rec, err := d.read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			row := make([]string, len(union))
+			for i, v := range rec {
+				if i < len(d.header) {
+					row[seen[d.header[i]]] = v
+				}
+			}
+			if err := e.writeRow(row); err != nil {
+				return err
+			}
+		}
+	}
+	return e.Close()
+}
@@ -0,0 +1,33 @@
+package csvstruct
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	var buf bytes.Buffer
+	err := Merge(&buf, []io.Reader{
+		strings.NewReader("id,name\n1,Alice\n2,Bob\n"),
+		strings.NewReader("name,email,id\nCarol,carol@example.com,3\n"),
+		strings.NewReader("id\n4\n"),
+	}, TransformOpts{})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	want := `id,name,email
+1,Alice,
+2,Bob,
+3,Carol,carol@example.com
+4,,
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Merge: got\n%s\nwant\n%s", got, want)
+	}
+
+	if err := Merge(&buf, []io.Reader{strings.NewReader("")}, TransformOpts{}); err == nil {
+		t.Errorf("Merge: expected error for input without a header")
+	}
+}
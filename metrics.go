@@ -0,0 +1,23 @@
+package csvstruct
+
+// Metrics receives counts from Encoders and Decoders, so that applications
+// can export them to a monitoring system. An *expvar.Map satisfies Metrics,
+// as does a small adapter around a set of Prometheus counters.
+//
+// Add is called with one of the Metric keys and a positive delta. It may be
+// called concurrently if Metrics is shared between Encoders or Decoders.
+type Metrics interface {
+	Add(key string, delta int64)
+}
+
+// Keys passed to Metrics.Add.
+const (
+	MetricRowsDecoded  = "rows_decoded"  // data rows read by a Decoder
+	MetricBytesRead    = "bytes_read"    // bytes of CSV read by a Decoder
+	MetricDecodeErrors = "decode_errors" // errors returned by DecodeNext, other than io.EOF
+
+	MetricRowsEncoded  = "rows_encoded"  // data rows written by an Encoder
+	MetricBytesWritten = "bytes_written" // bytes written by an Encoder, after compression
+	MetricFlushes      = "flushes"       // flushes of an Encoder's output
+	MetricEncodeErrors = "encode_errors" // errors returned by EncodeNext
+)
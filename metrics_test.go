@@ -0,0 +1,55 @@
+package csvstruct
+
+import (
+	"bytes"
+	"expvar"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	in := "A,B\na,b\nc,d\ne,f\n"
+	m := new(expvar.Map).Init()
+	d := NewDecoder(strings.NewReader(in)).Opts(DecodeOpts{Metrics: m})
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{Metrics: m})
+	for {
+		var row struct{ A, B string }
+		if err := d.DecodeNext(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		if err := e.EncodeNext(row); err != nil {
+			t.Errorf("EncodeNext: %v", err)
+		}
+	}
+	if err := d.DecodeNext(new(int)); err == nil {
+		t.Errorf("DecodeNext(*int): expected error")
+	}
+	if err := e.EncodeNext(1); err == nil {
+		t.Errorf("EncodeNext(int): expected error")
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+
+	for k, want := range map[string]int64{
+		MetricRowsDecoded:  3,
+		MetricBytesRead:    int64(len(in)),
+		MetricDecodeErrors: 0, // io.EOF isn't counted
+		MetricRowsEncoded:  3,
+		MetricBytesWritten: int64(buf.Len()),
+		MetricFlushes:      4, // one per row, and one on Close
+		MetricEncodeErrors: 1,
+	} {
+		var got int64
+		if v, ok := m.Get(k).(*expvar.Int); ok {
+			got = v.Value()
+		}
+		if got != want {
+			t.Errorf("%s: got %d, want %d", k, got, want)
+		}
+	}
+}
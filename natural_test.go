@@ -0,0 +1,28 @@
+package csvstruct
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	got := []string{"col10", "col2", "col1", "b", "a10b", "a9z", "col02", ""}
+	sort.Slice(got, func(i, j int) bool { return NaturalLess(got[i], got[j]) })
+	want := []string{"", "a9z", "a10b", "b", "col1", "col2", "col02", "col10"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncode_HeaderLess(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{HeaderLess: NaturalLess})
+	if err := e.EncodeNext(map[string]interface{}{"q10": 1, "q2": 2, "q1": 3}); err != nil {
+		t.Errorf("EncodeNext: %v", err)
+	}
+	if want := "q1,q2,q10\n3,2,1\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
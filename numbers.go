@@ -0,0 +1,149 @@
+package csvstruct
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// RoundingPolicy describes how a Decoder converts numbers with fractions,
+// like "12.7", for integer fields.
+type RoundingPolicy int
+
+const (
+	RoundError    RoundingPolicy = iota // fail to decode them (the default)
+	RoundTruncate                       // drop the fraction, rounding toward zero
+	RoundHalfUp                         // round to the nearest integer, and halves away from zero as spreadsheets do
+)
+
+// OverflowError is returned by DecodeNext, wrapped, when a cell holds a
+// number too large for the field it decodes into, like "300" for an int8.
+type OverflowError struct {
+	Row    int          // 1-based data row
+	Column string       // column of the cell
+	Value  string       // the cell
+	Type   reflect.Type // type of the field
+	Err    error        // the error from strconv
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("row %d: column %q: %s overflows %v", e.Row, e.Column, e.Value, e.Type)
+}
+
+func (e *OverflowError) Unwrap() error {
+	return e.Err
+}
+
+// numberError returns the error for the cell s of column n, which failed to
+// parse as a number of type t.
+func (d *decoder) numberError(err error, t reflect.Type, n, s string) error {
+	if errors.Is(err, strconv.ErrRange) {
+		err = &OverflowError{Row: d.rows, Column: n, Value: s, Type: t, Err: err}
+	}
+	return fmt.Errorf("error decoding: %w", err)
+}
+
+// parseInt parses s as an integer field of the given size in bits under
+// the Numbers and Rounding policies.
+func (d *decoder) parseInt(s string, bits int) (int64, error) {
+	if d.opts.Numbers == StrictNumbers && strings.HasPrefix(s, "+") {
+		return 0, syntaxError("ParseInt", s)
+	}
+	i, err := strconv.ParseInt(s, 10, bits)
+	if err == nil || !d.decimalInts() || errors.Is(err, strconv.ErrRange) {
+		return i, err
+	}
+	if r, ok := d.integral(s); ok {
+		if i := r.Int64(); r.IsInt64() && i<<(64-bits)>>(64-bits) == i {
+			return i, nil
+		}
+		return 0, rangeError("ParseInt", s)
+	}
+	return 0, err
+}
+
+// parseUint parses s as an unsigned integer field of the given size in bits
+// under the Numbers and Rounding policies.
+func (d *decoder) parseUint(s string, bits int) (uint64, error) {
+	if d.opts.Numbers == StrictNumbers && strings.HasPrefix(s, "+") {
+		return 0, syntaxError("ParseUint", s)
+	}
+	u, err := strconv.ParseUint(strings.TrimPrefix(s, "+"), 10, bits)
+	if err == nil || !d.decimalInts() || errors.Is(err, strconv.ErrRange) {
+		return u, err
+	}
+	if r, ok := d.integral(s); ok && r.Sign() >= 0 {
+		if u := r.Uint64(); r.IsUint64() && (bits == 64 || u>>bits == 0) {
+			return u, nil
+		}
+		return 0, rangeError("ParseUint", s)
+	}
+	return 0, err
+}
+
+// decimalInts reports whether integer fields accept decimal numbers, like
+// "3.0" or "12.7".
+func (d *decoder) decimalInts() bool {
+	return d.opts.Numbers == LenientNumbers || d.opts.Rounding != RoundError
+}
+
+// parseFloat parses s as a float field under the Numbers policy.
+func (d *decoder) parseFloat(s string) (float64, error) {
+	if d.opts.Numbers == StrictNumbers && strings.HasPrefix(s, "+") {
+		return 0, syntaxError("ParseFloat", s)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// integral returns the exact value of the decimal number s, such as "3.0"
+// or "1e3", rounded to an integer under the Rounding policy.
+func (d *decoder) integral(s string) (*big.Int, bool) {
+	r, ok := parseDecimal(s)
+	if !ok {
+		return nil, false
+	}
+	return d.round(r)
+}
+
+// parseDecimal returns the exact value of the decimal number s.
+func parseDecimal(s string) (*big.Rat, bool) {
+	if strings.Trim(s, "0123456789.eE+-") != "" {
+		// big.Rat also accepts fractions and other bases.
+		return nil, false
+	}
+	return new(big.Rat).SetString(strings.TrimPrefix(s, "+"))
+}
+
+// round rounds r to an integer under the Rounding policy.
+func (d *decoder) round(r *big.Rat) (*big.Int, bool) {
+	if r.IsInt() {
+		return new(big.Int).Set(r.Num()), true
+	}
+	// Quo truncates toward zero.
+	q, m := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	switch d.opts.Rounding {
+	case RoundTruncate:
+		return q, true
+	case RoundHalfUp:
+		if m.Abs(m).Lsh(m, 1).Cmp(r.Denom()) >= 0 {
+			q.Add(q, big.NewInt(int64(r.Sign())))
+		}
+		return q, true
+	}
+	return nil, false
+}
+
+// rangeError returns the error strconv's function fn returns for s, a number
+// out of range.
+func rangeError(fn, s string) error {
+	return &strconv.NumError{Func: fn, Num: s, Err: strconv.ErrRange}
+}
+
+// syntaxError returns the error strconv's function fn returns for the
+// unparseable s.
+func syntaxError(fn, s string) error {
+	return &strconv.NumError{Func: fn, Num: s, Err: strconv.ErrSyntax}
+}
@@ -0,0 +1,137 @@
+package csvstruct
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecode_Numbers(t *testing.T) {
+	type row struct {
+		I int
+		U uint
+		F float64
+	}
+	for _, c := range []struct {
+		in      string
+		policy  NumberPolicy
+		want    row
+		wantErr bool
+	}{
+		{"1,2,3.5", StrictNumbers, row{1, 2, 3.5}, false},
+		{"-1,2,1e3", StrictNumbers, row{-1, 2, 1000}, false},
+		{"+1,2,3", StrictNumbers, row{}, true},
+		{"1,+2,3", StrictNumbers, row{}, true},
+		{"1,2,+3", StrictNumbers, row{}, true},
+		{"3.0,2,3", StrictNumbers, row{}, true},
+		{"1e3,2,3", StrictNumbers, row{}, true},
+		{"+1,+2,+3", LenientNumbers, row{1, 2, 3}, false},
+		{"3.0,1e3,3", LenientNumbers, row{3, 1000, 3}, false},
+		{"-2.50e1,2,3", LenientNumbers, row{-25, 2, 3}, false},
+		{"3.5,2,3", LenientNumbers, row{}, true},
+		{"1e30,2,3", LenientNumbers, row{}, true},
+		{"1,-1.0,3", LenientNumbers, row{}, true},
+		{"0x10,2,3", LenientNumbers, row{}, true},
+		{"1/1,2,3", LenientNumbers, row{}, true},
+	} {
+		in := "I,U,F\n" + c.in + "\n"
+		d := NewDecoder(strings.NewReader(in)).Opts(DecodeOpts{Numbers: c.policy})
+		var got row
+		err := d.DecodeNext(&got)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("DecodeNext(%q, %d): got %v, want error", c.in, c.policy, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DecodeNext(%q, %d): %v", c.in, c.policy, err)
+		} else if got != c.want {
+			t.Errorf("DecodeNext(%q, %d): got %v, want %v", c.in, c.policy, got, c.want)
+		}
+	}
+}
+
+func TestDecode_Overflow(t *testing.T) {
+	type row struct {
+		I8  int8
+		U16 uint16
+		F   float64
+	}
+	for _, c := range []struct {
+		in, column string
+		policy     NumberPolicy
+	}{
+		{"128,1,1", "I8", StrictNumbers},
+		{"-129,1,1", "I8", StrictNumbers},
+		{"1,65536,1", "U16", StrictNumbers},
+		{"1,1,1e400", "F", StrictNumbers},
+		{"1.28e2,1,1", "I8", LenientNumbers},
+		{"1,6.5536e4,1", "U16", LenientNumbers},
+		{"1,65536,1", "U16", LenientNumbers},
+	} {
+		in := "I8,U16,F\n0,0,0\n" + c.in + "\n"
+		d := NewDecoder(strings.NewReader(in)).Opts(DecodeOpts{Numbers: c.policy})
+		var r row
+		if err := d.DecodeNext(&r); err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		err := d.DecodeNext(&r)
+		var oe *OverflowError
+		if !errors.As(err, &oe) {
+			t.Errorf("DecodeNext(%q): got %v, want an OverflowError", c.in, err)
+			continue
+		}
+		if oe.Row != 2 || oe.Column != c.column {
+			t.Errorf("DecodeNext(%q): got %+v, want row 2, column %s", c.in, oe, c.column)
+		}
+	}
+
+	d := NewDecoder(strings.NewReader("I8,U16,F\n-128,65535,1\n"))
+	var r row
+	if err := d.DecodeNext(&r); err != nil || r != (row{-128, 65535, 1}) {
+		t.Errorf("DecodeNext: got %v, %v", r, err)
+	}
+}
+
+func TestDecode_Rounding(t *testing.T) {
+	type row struct {
+		I int
+		U uint8
+	}
+	for _, c := range []struct {
+		in      string
+		policy  RoundingPolicy
+		want    row
+		wantErr bool
+	}{
+		{"12.7,1", RoundError, row{}, true},
+		{"12.0,1", RoundError, row{}, true},
+		{"12.7,2.5", RoundTruncate, row{12, 2}, false},
+		{"-12.7,1", RoundTruncate, row{-12, 1}, false},
+		{"12.0,1e2", RoundTruncate, row{12, 100}, false},
+		{"12.5,2.4", RoundHalfUp, row{13, 2}, false},
+		{"-12.5,2.6", RoundHalfUp, row{-13, 3}, false},
+		{"-12.49,255.4", RoundHalfUp, row{-12, 255}, false},
+		{"1,255.5", RoundHalfUp, row{}, true},
+		{"1,-0.4", RoundTruncate, row{1, 0}, false},
+		{"1,-1.4", RoundTruncate, row{}, true},
+		{"+1.5,1", RoundTruncate, row{}, true},
+	} {
+		in := "I,U\n" + c.in + "\n"
+		d := NewDecoder(strings.NewReader(in)).Opts(DecodeOpts{Rounding: c.policy})
+		var got row
+		err := d.DecodeNext(&got)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("DecodeNext(%q, %d): got %v, want error", c.in, c.policy, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DecodeNext(%q, %d): %v", c.in, c.policy, err)
+		} else if got != c.want {
+			t.Errorf("DecodeNext(%q, %d): got %v, want %v", c.in, c.policy, got, c.want)
+		}
+	}
+}
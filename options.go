@@ -0,0 +1,465 @@
+package csvstruct
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// An Option configures an Encoder or Decoder when passed to NewEncoder or
+// NewDecoder. Options are an alternative to calling Opts; each sets the
+// corresponding field of EncodeOpts or DecodeOpts.
+//
+// If an Option is invalid, or doesn't apply to the Encoder or Decoder it's
+// passed to, the error is returned by the first call to EncodeNext or
+// DecodeNext.
+type Option struct {
+	name string
+	enc  func(*EncodeOpts) error
+	dec  func(*DecodeOpts) error
+	call bool // may be passed to a single call to EncodeNext
+}
+
+// applyEncode applies options to o.
+func applyEncode(o *EncodeOpts, options []Option) error {
+	for _, opt := range options {
+		if opt.enc == nil {
+			return fmt.Errorf("option %s does not apply to an Encoder", opt.name)
+		}
+		if err := opt.enc(o); err != nil {
+			return fmt.Errorf("option %s: %v", opt.name, err)
+		}
+	}
+	return nil
+}
+
+// applyOverrides applies options passed to a single call to EncodeNext to o,
+// and checks that the result is valid.
+func applyOverrides(o *EncodeOpts, options []Option) error {
+	for _, opt := range options {
+		if !opt.call {
+			return fmt.Errorf("option %s can't be passed to EncodeNext", opt.name)
+		}
+	}
+	if err := applyEncode(o, options); err != nil {
+		return err
+	}
+	return o.validate()
+}
+
+// applyDecode applies options to o.
+func applyDecode(o *DecodeOpts, options []Option) error {
+	for _, opt := range options {
+		if opt.dec == nil {
+			return fmt.Errorf("option %s does not apply to a Decoder", opt.name)
+		}
+		if err := opt.dec(o); err != nil {
+			return fmt.Errorf("option %s: %v", opt.name, err)
+		}
+	}
+	return nil
+}
+
+// validDelim reports whether r can separate fields, as encoding/csv requires.
+func validDelim(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && utf8.ValidRune(r) && r != utf8.RuneError
+}
+
+// floatPrecision reports whether format gives a precision to a floating
+// point verb, like "%.2f", which only float values can be written with.
+func floatPrecision(format string) bool {
+	for i := strings.IndexByte(format, '%'); i >= 0; i = strings.IndexByte(format, '%') {
+		format = strings.TrimLeft(format[i+1:], "+-# 0123456789")
+		prec := strings.HasPrefix(format, ".")
+		format = strings.TrimLeft(format, ".0123456789")
+		if format == "" {
+			return false
+		}
+		if prec && strings.IndexByte("eEfFgG", format[0]) >= 0 {
+			return true
+		}
+		format = format[1:]
+	}
+	return false
+}
+
+// comma returns r, or the default delimiter if r is zero.
+func comma(r rune) rune {
+	if r == 0 {
+		return ','
+	}
+	return r
+}
+
+// encodable reports whether cs can represent every character of s.
+func encodable(cs Charset, s string) bool {
+	if cs == UTF8 {
+		return true
+	}
+	for _, r := range s {
+		if _, ok := encodeRune(cs, r); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// validate reports invalid settings and combinations of settings in o.
+func (o EncodeOpts) validate() error {
+	switch {
+	case o.Comma != 0 && !validDelim(o.Comma):
+		return fmt.Errorf("invalid Comma %q", o.Comma)
+	case o.Compression != NoCompression && o.Compression != Gzip:
+		return fmt.Errorf("unknown Compression %d", o.Compression)
+	case o.CompressionLevel != 0 && o.Compression == NoCompression:
+		return errors.New("CompressionLevel set without Compression")
+	case o.SortMemoryRows < 0:
+		return errors.New("negative SortMemoryRows")
+	case o.SortMemoryRows != 0 && len(o.SortBy) == 0:
+		return errors.New("SortMemoryRows set without SortBy")
+	case o.DedupeMaxKeys < 0:
+		return errors.New("negative DedupeMaxKeys")
+	case (len(o.DedupeBy) > 0 || o.DedupeMaxKeys != 0) && !o.Dedupe:
+		return errors.New("DedupeBy or DedupeMaxKeys set without Dedupe")
+	case o.RepeatHeaderEvery < 0:
+		return errors.New("negative RepeatHeaderEvery")
+	case o.BufferSize < 0:
+		return errors.New("negative BufferSize")
+	case o.NilRows < SkipNil || o.NilRows > ErrorNil:
+		return fmt.Errorf("unknown NilRows policy %d", o.NilRows)
+	case o.Quote < QuoteMinimal || o.Quote > QuoteNonNumeric:
+		return fmt.Errorf("unknown Quote policy %d", o.Quote)
+	case o.Unquotable != "" && o.Quote != QuoteNever:
+		return errors.New("Unquotable set without QuoteNever")
+	case strings.ContainsAny(o.Unquotable, "\"\r\n") || (o.Unquotable != "" && strings.ContainsRune(o.Unquotable, comma(o.Comma))):
+		return fmt.Errorf("Unquotable %q itself needs quoting", o.Unquotable)
+	case o.Newlines < KeepNewlines || o.Newlines > SpaceNewlines:
+		return fmt.Errorf("unknown Newlines policy %d", o.Newlines)
+	case o.ControlChars < KeepControl || o.ControlChars > ReplaceControl:
+		return fmt.Errorf("unknown ControlChars policy %d", o.ControlChars)
+	case o.ControlReplacement != "" && o.ControlChars != ReplaceControl:
+		return errors.New("ControlReplacement set without ReplaceControl")
+	case o.Charset != UTF8 && o.Charset != Latin1 && o.Charset != Windows1252:
+		return fmt.Errorf("Charset %d can't be used for output", o.Charset)
+	case o.Unmappable != "" && o.Charset == UTF8:
+		return errors.New("Unmappable set without a Charset")
+	case !encodable(o.Charset, string(comma(o.Comma))+o.Unmappable):
+		return fmt.Errorf("Comma or Unmappable can't be encoded in Charset %d", o.Charset)
+	case o.Align < NoAlign || o.Align > AlignSpaces:
+		return fmt.Errorf("unknown Align policy %d", o.Align)
+	case o.UseCRLF && o.Newlines == LFNewlines:
+		return errors.New("LFNewlines can't be used with UseCRLF, which writes line breaks in cells as CRLF")
+	case o.TrailingComma && o.Align == AlignSpaces:
+		return errors.New("TrailingComma can't be used with AlignSpaces")
+	case o.SchemaHash && o.SkipHeader:
+		return errors.New("SchemaHash set with SkipHeader")
+	case o.Provenance != nil && o.SkipHeader:
+		return errors.New("Provenance set with SkipHeader")
+	case o.Trailer && (o.Align != NoAlign || o.TrailingComma || o.Unmappable != ""):
+		return errors.New("Trailer can't be used with Align, TrailingComma or Unmappable")
+	case o.NilRows == EmptyRowNil && o.SkipEmptyRows:
+		return errors.New("NilRows is EmptyRowNil, but SkipEmptyRows is set")
+	case o.Schema != nil && o.Columns != nil:
+		return errors.New("Schema and Columns can't both be set")
+	case o.Schema != nil && o.DedupeHeaders:
+		return errors.New("Schema can't be used with DedupeHeaders")
+	case o.SortHeaders && (o.Schema != nil || o.Columns != nil):
+		return errors.New("SortHeaders can't be used with Columns or Schema, which order the header")
+	}
+	if o.Schema != nil {
+		return o.Schema.validate()
+	}
+	return nil
+}
+
+// validate reports invalid settings and combinations of settings in o.
+func (o DecodeOpts) validate() error {
+	switch {
+	case o.Comma != 0 && !validDelim(o.Comma):
+		return fmt.Errorf("invalid Comma %q", o.Comma)
+	case o.Comment != 0 && !validDelim(o.Comment):
+		return fmt.Errorf("invalid Comment %q", o.Comment)
+	case o.Comment != 0 && o.Comment == o.Comma, o.Comment == ',' && o.Comma == 0:
+		return fmt.Errorf("Comment and Comma are both %q", o.Comment)
+	case o.Charset < UTF8 || o.Charset > ShiftJIS:
+		return fmt.Errorf("unknown Charset %d", o.Charset)
+	case (o.SchemaHash != "" || o.Provenance) && o.Comment == '#':
+		return errors.New("SchemaHash and Provenance can't be used with Comment '#', which would skip the lines before the header")
+	case o.VerifyTrailer && o.Comment == '#':
+		return errors.New("VerifyTrailer can't be used with Comment '#', which would skip the trailer")
+	case o.BufferSize < 0:
+		return errors.New("negative BufferSize")
+	case o.MaxUploadBytes < 0:
+		return errors.New("negative MaxUploadBytes")
+	case o.SampleEvery < 0:
+		return errors.New("negative SampleEvery")
+	case o.Numbers < StrictNumbers || o.Numbers > LenientNumbers:
+		return fmt.Errorf("unknown Numbers policy %d", o.Numbers)
+	case o.Rounding < RoundError || o.Rounding > RoundHalfUp:
+		return fmt.Errorf("unknown Rounding policy %d", o.Rounding)
+	case o.InvalidUTF8 < KeepInvalid || o.InvalidUTF8 > StripInvalid:
+		return fmt.Errorf("unknown InvalidUTF8 policy %d", o.InvalidUTF8)
+	case o.Schema != nil && len(o.Columns) > 0:
+		return errors.New("Schema and Columns can't both be set")
+	case o.RFC4180 && o.Comma >= utf8.RuneSelf:
+		return fmt.Errorf("RFC4180 requires a single-byte Comma, not %q", o.Comma)
+	case o.RFC4180 && (o.Comment != 0 || o.LazyQuotes):
+		return errors.New("RFC4180 can't be used with Comment or LazyQuotes")
+	case o.RFC4180 && (o.SchemaHash != "" || o.Provenance || o.VerifyTrailer):
+		return errors.New("RFC4180 can't be used with SchemaHash, Provenance or VerifyTrailer, which add lines that aren't records")
+	}
+	if o.Schema != nil {
+		if err := o.Schema.validate(); err != nil {
+			return err
+		}
+	}
+	for col, k := range o.TypeOverrides {
+		if k < KindInfer || k > KindBool {
+			return fmt.Errorf("unknown Kind %d for column %q", k, col)
+		}
+	}
+	return nil
+}
+
+// WithComma sets the field delimiter of an Encoder or Decoder.
+func WithComma(r rune) Option {
+	return Option{
+		name: "WithComma",
+		enc:  func(o *EncodeOpts) error { o.Comma = r; return nil },
+		dec:  func(o *DecodeOpts) error { o.Comma = r; return nil },
+	}
+}
+
+// WithProtoNames maps columns to untagged fields of generated protobuf
+// message structs by their JSON names.
+func WithProtoNames() Option {
+	return Option{
+		name: "WithProtoNames",
+		enc:  func(o *EncodeOpts) error { o.ProtoNames = true; return nil },
+		dec:  func(o *DecodeOpts) error { o.ProtoNames = true; return nil },
+	}
+}
+
+// WithMetrics sets the Metrics of an Encoder or Decoder.
+func WithMetrics(m Metrics) Option {
+	return Option{
+		name: "WithMetrics",
+		enc:  func(o *EncodeOpts) error { o.Metrics = m; return nil },
+		dec:  func(o *DecodeOpts) error { o.Metrics = m; return nil },
+	}
+}
+
+// WithOnSkip sets the OnSkip callback of an Encoder or Decoder.
+func WithOnSkip(fn func(Skip)) Option {
+	return Option{
+		name: "WithOnSkip",
+		enc:  func(o *EncodeOpts) error { o.OnSkip = fn; return nil },
+		dec:  func(o *DecodeOpts) error { o.OnSkip = fn; return nil },
+		call: true,
+	}
+}
+
+// WithFloatFormat sets the format an Encoder uses for float fields.
+func WithFloatFormat(format string) Option {
+	return Option{
+		name: "WithFloatFormat",
+		enc:  func(o *EncodeOpts) error { o.FloatFormat = format; return nil },
+		call: true,
+	}
+}
+
+// WithMaskers adds to the Maskers of an Encoder.
+func WithMaskers(maskers map[string]func(string) string) Option {
+	return Option{
+		name: "WithMaskers",
+		enc: func(o *EncodeOpts) error {
+			m := make(map[string]func(string) string, len(o.Maskers)+len(maskers))
+			for k, v := range o.Maskers {
+				m[k] = v
+			}
+			for k, v := range maskers {
+				m[k] = v
+			}
+			o.Maskers = m
+			return nil
+		},
+		call: true,
+	}
+}
+
+// WithSkipHeader causes an Encoder not to write the header row.
+func WithSkipHeader() Option {
+	return Option{
+		name: "WithSkipHeader",
+		enc:  func(o *EncodeOpts) error { o.SkipHeader = true; return nil },
+	}
+}
+
+// WithSortHeaders causes an Encoder to order the columns of structs as it
+// orders the keys of maps.
+func WithSortHeaders() Option {
+	return Option{
+		name: "WithSortHeaders",
+		enc:  func(o *EncodeOpts) error { o.SortHeaders = true; return nil },
+	}
+}
+
+// WithCRLF causes an Encoder to end lines with \r\n.
+func WithCRLF() Option {
+	return Option{
+		name: "WithCRLF",
+		enc:  func(o *EncodeOpts) error { o.UseCRLF = true; return nil },
+	}
+}
+
+// WithGzip causes an Encoder to compress its output with gzip at the given
+// level, or gzip.DefaultCompression if level is zero.
+func WithGzip(level int) Option {
+	return Option{
+		name: "WithGzip",
+		enc: func(o *EncodeOpts) error {
+			o.Compression, o.CompressionLevel = Gzip, level
+			return nil
+		},
+	}
+}
+
+// WithMiddleware appends to the Middleware of an Encoder.
+func WithMiddleware(mws ...WriterMiddleware) Option {
+	return Option{
+		name: "WithMiddleware",
+		enc: func(o *EncodeOpts) error {
+			o.Middleware = append(o.Middleware, mws...)
+			return nil
+		},
+	}
+}
+
+// WithComment sets the comment character of a Decoder.
+func WithComment(r rune) Option {
+	return Option{
+		name: "WithComment",
+		dec:  func(o *DecodeOpts) error { o.Comment = r; return nil },
+	}
+}
+
+// WithLazyQuotes allows quotes in unquoted fields, and unescaped quotes in
+// quoted fields, when decoding.
+func WithLazyQuotes() Option {
+	return Option{
+		name: "WithLazyQuotes",
+		dec:  func(o *DecodeOpts) error { o.LazyQuotes = true; return nil },
+	}
+}
+
+// WithRFC4180 causes a Decoder to check its input strictly against RFC
+// 4180.
+func WithRFC4180() Option {
+	return Option{
+		name: "WithRFC4180",
+		dec:  func(o *DecodeOpts) error { o.RFC4180 = true; return nil },
+	}
+}
+
+// WithQuotedEmpty causes a Decoder to tell cells written as "" apart from
+// empty cells.
+func WithQuotedEmpty() Option {
+	return Option{
+		name: "WithQuotedEmpty",
+		dec:  func(o *DecodeOpts) error { o.QuotedEmpty = true; return nil },
+	}
+}
+
+// WithTrimLeadingSpace causes a Decoder to ignore leading white space in
+// fields.
+func WithTrimLeadingSpace() Option {
+	return Option{
+		name: "WithTrimLeadingSpace",
+		dec:  func(o *DecodeOpts) error { o.TrimLeadingSpace = true; return nil },
+	}
+}
+
+// WithFieldsPerRecord sets the number of fields a Decoder expects in each
+// record, as for csv.Reader.
+func WithFieldsPerRecord(n int) Option {
+	return Option{
+		name: "WithFieldsPerRecord",
+		dec:  func(o *DecodeOpts) error { o.FieldsPerRecord = n; return nil },
+	}
+}
+
+// WithCharset sets the character encoding of a Decoder's input or an
+// Encoder's output.
+func WithCharset(cs Charset) Option {
+	return Option{
+		name: "WithCharset",
+		enc:  func(o *EncodeOpts) error { o.Charset = cs; return nil },
+		dec:  func(o *DecodeOpts) error { o.Charset = cs; return nil },
+	}
+}
+
+// WithColumns restricts a Decoder to the named columns, or sets the
+// columns an Encoder writes.
+func WithColumns(cols ...string) Option {
+	return Option{
+		name: "WithColumns",
+		enc:  func(o *EncodeOpts) error { o.Columns = cols; return nil },
+		dec:  func(o *DecodeOpts) error { o.Columns = cols; return nil },
+	}
+}
+
+// WithBufferSize sets the size of the buffer an Encoder writes or a Decoder
+// reads through.
+func WithBufferSize(n int) Option {
+	return Option{
+		name: "WithBufferSize",
+		enc:  func(o *EncodeOpts) error { o.BufferSize = n; return nil },
+		dec:  func(o *DecodeOpts) error { o.BufferSize = n; return nil },
+	}
+}
+
+// WithSchema sets the Schema of an Encoder or Decoder.
+func WithSchema(s *Schema) Option {
+	return Option{
+		name: "WithSchema",
+		enc:  func(o *EncodeOpts) error { o.Schema = s; return nil },
+		dec:  func(o *DecodeOpts) error { o.Schema = s; return nil },
+	}
+}
+
+// WithMapping sets the Mapping of an Encoder or Decoder.
+func WithMapping(m *Mapping) Option {
+	return Option{
+		name: "WithMapping",
+		enc:  func(o *EncodeOpts) error { o.Mapping = m; return nil },
+		dec:  func(o *DecodeOpts) error { o.Mapping = m; return nil },
+	}
+}
+
+// WithStats sets the Stats collected by a Decoder.
+func WithStats(s *Stats) Option {
+	return Option{
+		name: "WithStats",
+		dec:  func(o *DecodeOpts) error { o.Stats = s; return nil },
+	}
+}
+
+// WithNilRows sets how an Encoder handles nil rows.
+func WithNilRows(p NilPolicy) Option {
+	return Option{
+		name: "WithNilRows",
+		enc:  func(o *EncodeOpts) error { o.NilRows = p; return nil },
+		call: true,
+	}
+}
+
+// WithArrayDelimiter sets the delimiter between the elements of array fields.
+func WithArrayDelimiter(delim string) Option {
+	return Option{
+		name: "WithArrayDelimiter",
+		enc:  func(o *EncodeOpts) error { o.ArrayDelimiter = delim; return nil },
+		dec:  func(o *DecodeOpts) error { o.ArrayDelimiter = delim; return nil },
+		call: true,
+	}
+}
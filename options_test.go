@@ -0,0 +1,129 @@
+package csvstruct
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, WithComma(';'), WithCRLF())
+	if err := e.EncodeNext(struct{ A, B string }{"a", "b"}); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if want := "A;B\r\na;b\r\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	var row struct{ A, B string }
+	d := NewDecoder(&buf, WithComma(';'))
+	if err := d.DecodeNext(&row); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	if row.A != "a" || row.B != "b" {
+		t.Errorf("got %+v", row)
+	}
+}
+
+func TestOptions_Invalid(t *testing.T) {
+	for _, e := range []Encoder{
+		NewEncoder(&bytes.Buffer{}, WithComma('\n')),
+		NewEncoder(&bytes.Buffer{}, WithLazyQuotes()),
+	} {
+		if err := e.EncodeNext(struct{ A string }{"a"}); err == nil {
+			t.Errorf("EncodeNext: expected error")
+		}
+		if err := e.Close(); err == nil {
+			t.Errorf("Close: expected error")
+		}
+	}
+	for _, d := range []Decoder{
+		NewDecoder(strings.NewReader("A\na\n"), WithComma('"')),
+		NewDecoder(strings.NewReader("A\na\n"), WithSkipHeader()),
+		NewDecoder(strings.NewReader("A\na\n"), WithCharset(Charset(42))),
+	} {
+		var row struct{ A string }
+		if err := d.DecodeNext(&row); err == nil {
+			t.Errorf("DecodeNext: expected error")
+		}
+	}
+}
+
+func TestOpts_Validate(t *testing.T) {
+	for _, o := range []EncodeOpts{
+		{Comma: '"'},
+		{Compression: Compression(7)},
+		{CompressionLevel: 9},
+		{SortMemoryRows: 10},
+		{SortBy: []string{"A"}, SortMemoryRows: -1},
+		{DedupeBy: []string{"A"}},
+		{Charset: UTF16LE},
+		{Unmappable: "?"},
+		{Charset: Latin1, Unmappable: "€"},
+		{SchemaHash: true, SkipHeader: true},
+		{Trailer: true, TrailingComma: true},
+		{UseCRLF: true, Newlines: LFNewlines},
+		{Schema: &Schema{Columns: []SchemaColumn{{Name: "A", Type: KindInt, Format: "%.2f"}}}},
+	} {
+		e := NewEncoder(&bytes.Buffer{}).Opts(o)
+		if err := e.EncodeNext(struct{ A string }{"a"}); err == nil {
+			t.Errorf("%+v: expected error", o)
+		}
+	}
+	for _, o := range []DecodeOpts{
+		{Comma: '\r'},
+		{Comment: '#', Comma: '#'},
+		{Comment: ','},
+		{Charset: Charset(-1)},
+		{InvalidUTF8: InvalidUTF8Policy(9)},
+		{SchemaHash: "0123456789abcdef", Comment: '#'},
+		{VerifyTrailer: true, Comment: '#'},
+		{TypeOverrides: map[string]Kind{"A": Kind(9)}},
+		{MaxUploadBytes: -1},
+	} {
+		d := NewDecoder(strings.NewReader("A\na\n")).Opts(o)
+		var row struct{ A string }
+		if err := d.DecodeNext(&row); err == nil {
+			t.Errorf("%+v: expected error", o)
+		}
+	}
+}
+
+func TestEncodeNext_Overrides(t *testing.T) {
+	type row struct {
+		Name  string
+		Value float64
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, WithFloatFormat("%.1f"))
+	rows := []row{{"a", 1.25}, {"b", 2.5}}
+	for _, r := range rows {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	hide := WithMaskers(map[string]func(string) string{"Name": func(string) string { return "x" }})
+	if err := e.EncodeNext(row{"total", 3.75}, WithFloatFormat("%.3f"), hide); err != nil {
+		t.Errorf("EncodeNext with overrides: %v", err)
+	}
+	if err := e.EncodeNext(row{"c", 0.5}); err != nil {
+		t.Errorf("EncodeNext: %v", err)
+	}
+	if err := e.EncodeNext(row{"d", 1}, WithComma(';')); err == nil {
+		t.Errorf("EncodeNext(WithComma): expected error")
+	}
+	if err := e.EncodeNext(row{"d", 1}, WithNilRows(NilPolicy(99))); err == nil {
+		t.Errorf("EncodeNext(WithNilRows(99)): expected error")
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	want := "Name,Value\na,1.2\nb,2.5\nx,3.750\nc,0.5\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,39 @@
+package csvstruct
+
+// OrderedMap is a row that remembers the order its columns were set in.
+// When the first row an Encoder encodes is an OrderedMap, the header lists
+// its columns in that order, rather than sorted as for a map.
+//
+// The zero OrderedMap is empty and ready to use.
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// Set sets the value of the named column, adding the column after the
+// existing ones if it's new.
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = map[string]interface{}{}
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get returns the value of the named column, and whether it is set.
+func (m *OrderedMap) Get(key string) (interface{}, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// Keys returns the columns in the order they were first set.
+func (m *OrderedMap) Keys() []string {
+	return append([]string(nil), m.keys...)
+}
+
+// Len returns the number of columns set.
+func (m *OrderedMap) Len() int {
+	return len(m.keys)
+}
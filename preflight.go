@@ -0,0 +1,148 @@
+package csvstruct
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxExamples bounds the offending values kept for each column of a
+// PreflightReport.
+const maxExamples = 5
+
+// PreflightReport describes the result of checking every row of an input
+// against a struct type with Preflight.
+type PreflightReport struct {
+	Rows    int            // data rows checked
+	Missing []string       // columns of the struct's fields that the header lacks
+	Columns []ColumnReport // the struct's mapped fields, in header order
+}
+
+// ColumnReport describes the cells of a column checked by Preflight.
+type ColumnReport struct {
+	Name     string
+	Checked  int            // cells checked
+	Failures map[string]int // cells that failed to decode, by reason
+	Examples []string       // up to five distinct cells that failed to decode
+}
+
+// OK reports whether the header had a column for every field and every cell
+// decoded.
+func (r *PreflightReport) OK() bool {
+	if len(r.Missing) > 0 {
+		return false
+	}
+	for _, c := range r.Columns {
+		if len(c.Failures) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Preflight decodes every row of r into the struct type of v, a struct or a
+// pointer to one, as a Decoder configured by options would, and reports the
+// cells of each column that fail to decode, so that an import can be
+// checked before any of it is committed. Unlike DecodeNext, it carries on
+// past bad cells. An error reading the input ends the pass, returning the
+// report so far.
+func Preflight(r io.Reader, v interface{}, options ...Option) (*PreflightReport, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, errors.New("must preflight struct type")
+	}
+	d := NewDecoder(r, options...).(*decoder)
+	rep := &PreflightReport{}
+	fields, err := d.structFields(t)
+	if err != nil {
+		return nil, err
+	}
+	// The columns are checked against the header before any rows, so that
+	// input without rows reports missing columns too.
+	if _, err := d.Header(); err != nil {
+		return rep, err
+	}
+	var cols []int // indexes into fields of the reported columns
+	for i, f := range fields {
+		if f.inline {
+			continue
+		}
+		if _, ok := d.hm[f.name]; !ok {
+			rep.Missing = append(rep.Missing, f.name)
+			continue
+		}
+		cols = append(cols, i)
+		rep.Columns = append(rep.Columns, ColumnReport{Name: f.name, Failures: map[string]int{}})
+	}
+	row := reflect.New(t).Elem()
+	for {
+		line, err := d.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return rep, err
+		}
+		rep.Rows++
+		var seen map[string]int
+		for n, i := range cols {
+			f := fields[i]
+			idx := d.hm[f.name]
+			if f.allowdup {
+				if seen == nil {
+					seen = map[string]int{}
+				}
+				var ok bool
+				if idx, ok = d.nthColumn(f.name, seen[f.name]); !ok {
+					continue
+				}
+				seen[f.name]++
+			}
+			c := &rep.Columns[n]
+			c.Checked++
+			if idx >= len(line) {
+				c.Failures["missing cell"]++
+				continue
+			}
+			vf := row.FieldByIndex(f.index)
+			vf.Set(reflect.Zero(vf.Type()))
+			if err := d.setStructField(vf, f, line[idx], d.quotedEmpty(idx)); err != nil {
+				c.fail(failureReason(err), line[idx])
+			}
+		}
+	}
+	for i := range rep.Columns {
+		if len(rep.Columns[i].Failures) == 0 {
+			rep.Columns[i].Failures = nil
+		}
+	}
+	return rep, nil
+}
+
+// fail records a cell that failed to decode.
+func (c *ColumnReport) fail(reason, cell string) {
+	c.Failures[reason]++
+	if len(c.Examples) >= maxExamples {
+		return
+	}
+	for _, e := range c.Examples {
+		if e == cell {
+			return
+		}
+	}
+	c.Examples = append(c.Examples, cell)
+}
+
+// failureReason returns the reason for a decoding error, without the cell,
+// so that failures can be counted by reason.
+func failureReason(err error) string {
+	var ne *strconv.NumError
+	if errors.As(err, &ne) {
+		return ne.Err.Error()
+	}
+	return strings.TrimPrefix(err.Error(), "error decoding: ")
+}
@@ -0,0 +1,76 @@
+package csvstruct
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	type row struct {
+		Name  string
+		Age   int
+		Score float64
+		Zip   string
+	}
+	in := "Name,Age,Score\n" +
+		"alice,30,1.5\n" +
+		"bob,old,x\n" +
+		"carol,99999999999999999999,2\n" +
+		"dave,old,3\n" +
+		"eve,41\n"
+	rep, err := Preflight(strings.NewReader(in), &row{}, WithFieldsPerRecord(-1))
+	if err != nil {
+		t.Fatalf("Preflight: %v", err)
+	}
+	want := &PreflightReport{
+		Rows:    5,
+		Missing: []string{"Zip"},
+		Columns: []ColumnReport{
+			{Name: "Name", Checked: 5},
+			{
+				Name:     "Age",
+				Checked:  5,
+				Failures: map[string]int{"invalid syntax": 2, "value out of range": 1},
+				Examples: []string{"old", "99999999999999999999"},
+			},
+			{
+				Name:     "Score",
+				Checked:  5,
+				Failures: map[string]int{"invalid syntax": 1, "missing cell": 1},
+				Examples: []string{"x"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(rep, want) {
+		t.Errorf("got %+v, want %+v", rep, want)
+	}
+	if rep.OK() {
+		t.Errorf("OK: got true, want false")
+	}
+
+	rep, err = Preflight(strings.NewReader("Name,Age,Score,Zip\nalice,30,1.5,02134\n"), row{})
+	if err != nil || !rep.OK() {
+		t.Errorf("Preflight: got %+v, %v, want OK", rep, err)
+	}
+
+	// Input without rows still reports missing columns.
+	rep, err = Preflight(strings.NewReader("Name,Age\n"), row{})
+	if err != nil {
+		t.Fatalf("Preflight: %v", err)
+	}
+	want = &PreflightReport{
+		Missing: []string{"Score", "Zip"},
+		Columns: []ColumnReport{{Name: "Name"}, {Name: "Age"}},
+	}
+	if !reflect.DeepEqual(rep, want) {
+		t.Errorf("header only: got %+v, want %+v", rep, want)
+	}
+	if rep.OK() {
+		t.Errorf("header only: OK: got true, want false")
+	}
+
+	if _, err := Preflight(strings.NewReader(in), map[string]string{}); err == nil {
+		t.Errorf("Preflight(map): expected error")
+	}
+}
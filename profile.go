@@ -0,0 +1,139 @@
+package csvstruct
+
+import (
+	"io"
+	"sort"
+)
+
+// Top values are tracked with the Space-Saving algorithm, which keeps a
+// fixed number of counters however many distinct values there are.
+const (
+	profileTop      = 10  // values reported in ColumnProfile.Top
+	profileCounters = 100 // values tracked for each column
+)
+
+// ColumnProfile describes the cells of a column read by Profile.
+type ColumnProfile struct {
+	ColumnStats
+
+	NullRate float64      // fraction of the cells that are empty
+	Kinds    map[Kind]int // non-empty cells by the Kind an interface{} target infers for them
+
+	// Top holds up to ten of the most frequent non-empty cells, most
+	// frequent first. When a column has more than a hundred distinct
+	// values, the counts are estimates, which may be too high by up to
+	// Error.
+	Top []ValueCount
+}
+
+// ValueCount is a cell value and the number of times it was read.
+type ValueCount struct {
+	Value string
+	Count int
+	Error int // the most Count may exceed the true count by
+}
+
+// Profile reads every row of r, as a Decoder configured by options would,
+// and describes each column: the types its cells would decode to, how many
+// are empty, how many distinct values it has and which are the most
+// frequent. It helps in designing the struct and validations for a file.
+// Only the columns given by DecodeOpts.Columns are profiled, if it is set.
+func Profile(r io.Reader, options ...Option) ([]ColumnProfile, error) {
+	d := NewDecoder(r, options...).(*decoder)
+	stats := &Stats{}
+	kinds := map[string]map[Kind]int{}
+	top := map[string]*topValues{}
+	for {
+		line, err := d.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		stats.observe(d.header, d.hm, line)
+		for h, i := range d.hm {
+			if i >= len(line) || line[i] == "" {
+				continue
+			}
+			if kinds[h] == nil {
+				kinds[h], top[h] = map[Kind]int{}, &topValues{counts: map[string]*ValueCount{}}
+			}
+			kinds[h][inferKind(line[i])]++
+			top[h].add(line[i])
+		}
+	}
+	rep := stats.Report()
+	profiles := make([]ColumnProfile, len(rep))
+	for i, c := range rep {
+		p := ColumnProfile{ColumnStats: c, Kinds: kinds[c.Name]}
+		if p.Kinds == nil {
+			p.Kinds = map[Kind]int{}
+		}
+		if c.Count > 0 {
+			p.NullRate = float64(c.Nulls) / float64(c.Count)
+		}
+		if t := top[c.Name]; t != nil {
+			p.Top = t.top(profileTop)
+		}
+		profiles[i] = p
+	}
+	return profiles, nil
+}
+
+// inferKind returns the Kind that the KindInfer rules give the cell s.
+func inferKind(s string) Kind {
+	v, _ := KindInfer.parse(s)
+	switch v.(type) {
+	case int64:
+		return KindInt
+	case float64:
+		return KindFloat
+	case bool:
+		return KindBool
+	}
+	return KindString
+}
+
+// topValues counts the most frequent values of a column.
+type topValues struct {
+	counts map[string]*ValueCount
+}
+
+// add counts v. When every counter is taken, the value with the lowest
+// count gives up its counter to v, which inherits its count as the error.
+func (t *topValues) add(v string) {
+	if c, ok := t.counts[v]; ok {
+		c.Count++
+		return
+	}
+	if len(t.counts) < profileCounters {
+		t.counts[v] = &ValueCount{Value: v, Count: 1}
+		return
+	}
+	var min *ValueCount
+	for _, c := range t.counts {
+		if min == nil || c.Count < min.Count || (c.Count == min.Count && c.Value < min.Value) {
+			min = c
+		}
+	}
+	delete(t.counts, min.Value)
+	t.counts[v] = &ValueCount{Value: v, Count: min.Count + 1, Error: min.Count}
+}
+
+// top returns the n values with the highest counts, ties broken by value.
+func (t *topValues) top(n int) []ValueCount {
+	out := make([]ValueCount, 0, len(t.counts))
+	for _, c := range t.counts {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Value < out[j].Value
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package csvstruct
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	in := "id,score,flag,city\n" +
+		"1,1.5,true,Paris\n" +
+		"2,,false,Paris\n" +
+		"3,2,yes,Oslo\n" +
+		"4,x,,Paris\n"
+	got, err := Profile(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Profile: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d columns, want 4", len(got))
+	}
+	id, score, flag, city := got[0], got[1], got[2], got[3]
+	if id.Name != "id" || id.Count != 4 || id.Distinct != 4 || !reflect.DeepEqual(id.Kinds, map[Kind]int{KindInt: 4}) {
+		t.Errorf("id: got %+v", id)
+	}
+	if score.NullRate != 0.25 || !reflect.DeepEqual(score.Kinds, map[Kind]int{KindFloat: 1, KindInt: 1, KindString: 1}) {
+		t.Errorf("score: got %+v", score)
+	}
+	if !reflect.DeepEqual(flag.Kinds, map[Kind]int{KindBool: 2, KindString: 1}) {
+		t.Errorf("flag: got %+v", flag)
+	}
+	if want := []ValueCount{{"Paris", 3, 0}, {"Oslo", 1, 0}}; !reflect.DeepEqual(city.Top, want) {
+		t.Errorf("city.Top: got %v, want %v", city.Top, want)
+	}
+
+	got, err = Profile(strings.NewReader(in), WithColumns("city"))
+	if err != nil || len(got) != 1 || got[0].Name != "city" {
+		t.Errorf("Profile(WithColumns): got %+v, %v", got, err)
+	}
+}
+
+func TestProfile_ManyValues(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("v\n")
+	for i := 0; i < 1000; i++ {
+		// "common" is a tenth of the cells; the rest are distinct.
+		if i%10 == 0 {
+			b.WriteString("common\n")
+		} else {
+			fmt.Fprintf(&b, "v%d\n", i)
+		}
+	}
+	got, err := Profile(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("Profile: %v", err)
+	}
+	top := got[0].Top
+	if len(top) != profileTop || top[0].Value != "common" || top[0].Count-top[0].Error > 100 || top[0].Count < 100 {
+		t.Errorf("Top: got %v", top)
+	}
+}
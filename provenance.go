@@ -0,0 +1,184 @@
+package csvstruct
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provenance describes where encoded output came from. It is written as
+// comment lines before the header by EncodeOpts.Provenance, and read back
+// by Decoder.Provenance.
+type Provenance struct {
+	GeneratedAt time.Time // when the output was written; the current time if zero
+	Tool        string    // name and version of the program that wrote it
+	Source      string    // identifier of the source of the data
+	Rows        int       // number of data rows, or -1 if not known; set by the Encoder
+}
+
+// rowsPlaceholder holds the place of the row count in the Provenance
+// lines until Close fills it in. It's wide enough for any int64.
+var rowsPlaceholder = strings.Repeat(" ", 20)
+
+// preambleLine returns the comment line holding key and value, written
+// before the header.
+func preambleLine(key, value string) string {
+	return "#" + key + ": " + value
+}
+
+// parsePreamble returns the key and value of rec, if it is a comment line
+// written before the header. A trailing delimiter is allowed.
+func parsePreamble(rec []string) (key, value string, ok bool) {
+	if len(rec) == 0 || !strings.HasPrefix(rec[0], "#") {
+		return "", "", false
+	}
+	for _, c := range rec[1:] {
+		if c != "" {
+			return "", "", false
+		}
+	}
+	key, value, ok = strings.Cut(rec[0][1:], ": ")
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", false
+	}
+	return key, strings.TrimRight(value, " "), true
+}
+
+// writeProvenance writes the lines describing p, recording where the row
+// count is to be filled in.
+func (e *encoder) writeProvenance(p Provenance) error {
+	t := p.GeneratedAt
+	if t.IsZero() {
+		t = time.Now().UTC()
+	}
+	lines := []string{preambleLine("generated-at", t.Format(time.RFC3339))}
+	if p.Tool != "" {
+		lines = append(lines, preambleLine("tool", p.Tool))
+	}
+	if p.Source != "" {
+		lines = append(lines, preambleLine("source", p.Source))
+	}
+	for _, l := range lines {
+		if err := e.w.Write([]string{l}); err != nil {
+			return err
+		}
+	}
+	rows := preambleLine("rows", rowsPlaceholder)
+	if e.w.Align == NoAlign {
+		e.rowsAt = e.cw.n + int64(e.w.w.Buffered()) + int64(len(rows)-len(rowsPlaceholder))
+		if e.w.needsQuotes(rows, false) {
+			e.rowsAt++
+		}
+	}
+	return e.w.Write([]string{rows})
+}
+
+// rowCount returns the number of data rows, padded to fill the
+// placeholder.
+func (e *encoder) rowCount() string {
+	return fmt.Sprintf("%-*d", len(rowsPlaceholder), e.rows)
+}
+
+// fillAlignedRows fills in the Provenance row count in the records
+// buffered by Align.
+func (e *encoder) fillAlignedRows() {
+	if e.opts.Provenance == nil {
+		return
+	}
+	placeholder := preambleLine("rows", rowsPlaceholder)
+	for _, cells := range e.w.aligned {
+		if len(cells) > 0 && strings.Contains(cells[0], placeholder) {
+			cells[0] = strings.Replace(cells[0], placeholder, preambleLine("rows", e.rowCount()), 1)
+			return
+		}
+	}
+}
+
+// fillRows fills in the Provenance row count in the flushed output, if the
+// Writer can seek. If it can't, the placeholder is left in place.
+func (e *encoder) fillRows() error {
+	if e.rowsAt < 0 || len(e.chain) > 0 {
+		return nil
+	}
+	ws, ok := e.cw.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// Not seekable after all, e.g. a pipe.
+		return nil
+	}
+	// The Writer may not have been empty when the Encoder started.
+	if _, err := ws.Seek(end-e.cw.n+e.rowsAt, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(ws, e.rowCount()); err != nil {
+		return err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// readPreamble reads the comment lines before the header, returning the
+// header.
+func (d *decoder) readPreamble() ([]string, error) {
+	// Comment lines have a single field, so don't let the first set the
+	// number of fields expected in later records.
+	fpr := d.r.FieldsPerRecord
+	if fpr == 0 {
+		d.r.FieldsPerRecord = -1
+	}
+	d.preamble = map[string]string{}
+	for {
+		rec, err := d.readRecord()
+		if err != nil {
+			d.r.FieldsPerRecord = fpr
+			return nil, err
+		}
+		if k, v, ok := parsePreamble(rec); ok {
+			d.preamble[k] = v
+			continue
+		}
+		if fpr == 0 {
+			fpr = len(rec)
+		}
+		d.r.FieldsPerRecord = fpr
+		return rec, nil
+	}
+}
+
+func (d *decoder) Provenance() (Provenance, error) {
+	p := Provenance{Rows: -1}
+	if d.err != nil {
+		return p, d.err
+	}
+	if !d.opts.Provenance {
+		return p, errors.New("DecodeOpts.Provenance is not set")
+	}
+	if d.hm == nil {
+		if err := d.readHeader(); err != nil {
+			return p, err
+		}
+	}
+	if s, ok := d.preamble["generated-at"]; ok {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return p, fmt.Errorf("error reading provenance: %v", err)
+		}
+		p.GeneratedAt = t
+	}
+	p.Tool = d.preamble["tool"]
+	p.Source = d.preamble["source"]
+	if s := d.preamble["rows"]; s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return p, fmt.Errorf("error reading provenance: %v", err)
+		}
+		p.Rows = n
+	}
+	return p, nil
+}
@@ -0,0 +1,87 @@
+package csvstruct
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProvenance(t *testing.T) {
+	type row struct{ A, B string }
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	p := &Provenance{GeneratedAt: at, Tool: "export v1.2", Source: "db://orders"}
+
+	f, err := os.CreateTemp(t.TempDir(), "out.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	io.WriteString(f, "junk")
+	var buf bytes.Buffer
+	for _, w := range []io.Writer{&buf, f} {
+		e := NewEncoder(w).Opts(EncodeOpts{Provenance: p, SchemaHash: true})
+		for _, r := range []row{{"a", "b"}, {"c", "d"}} {
+			if err := e.EncodeNext(r); err != nil {
+				t.Errorf("EncodeNext(%v): %v", r, err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	}
+	hash, _ := SchemaHash(row{})
+	want := "#generated-at: 2024-03-01T12:00:00Z\n#tool: export v1.2\n#source: db://orders\n#rows: %s\n#schema: " + hash + "\nA,B\na,b\nc,d\n"
+	if got, want := buf.String(), strings.Replace(want, "%s", rowsPlaceholder, 1); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	filled := strings.Replace(want, "%s", "2"+rowsPlaceholder[1:], 1)
+	if b, err := os.ReadFile(f.Name()); err != nil || string(b) != "junk"+filled {
+		t.Errorf("ReadFile: got %q, %v, want %q", b, err, "junk"+filled)
+	}
+
+	for _, c := range []struct {
+		in   string
+		rows int
+	}{
+		{buf.String(), -1},
+		{filled, 2},
+	} {
+		d := NewDecoder(strings.NewReader(c.in)).Opts(DecodeOpts{Provenance: true, SchemaHash: hash})
+		got, err := d.Provenance()
+		if err != nil {
+			t.Fatalf("Provenance: %v", err)
+		}
+		if want := (Provenance{at, "export v1.2", "db://orders", c.rows}); got != want {
+			t.Errorf("Provenance: got %+v, want %+v", got, want)
+		}
+		var r row
+		if err := d.DecodeNext(&r); err != nil || r != (row{"a", "b"}) {
+			t.Errorf("DecodeNext: got %v, %v", r, err)
+		}
+	}
+
+	if _, err := NewDecoder(strings.NewReader("A\na\n")).Provenance(); err == nil {
+		t.Errorf("Provenance without DecodeOpts.Provenance: expected error")
+	}
+}
+
+func TestProvenance_Align(t *testing.T) {
+	var buf bytes.Buffer
+	p := &Provenance{GeneratedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	e := NewEncoder(&buf).Opts(EncodeOpts{Provenance: p, Align: AlignDelimited})
+	for _, r := range []struct{ A, B string }{{"a", "b"}, {"cc", "d"}, {"e", "f"}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	d := NewDecoder(&buf).Opts(DecodeOpts{Provenance: true})
+	if got, err := d.Provenance(); err != nil || got.Rows != 3 {
+		t.Errorf("Provenance: got %+v, %v, want 3 rows", got, err)
+	}
+}
@@ -0,0 +1,195 @@
+package csvstruct
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+// quoteReader passes input through to a csv.Reader, noting the empty
+// fields of each record that were written as "", which the csv.Reader
+// doesn't report. Records are identified by the input offset after them.
+type quoteReader struct {
+	r io.Reader
+
+	comma, comment rune
+	lazy, trim     bool
+
+	off     int64  // offset of the input passed through
+	ch      []byte // bytes of an incomplete rune
+	state   int
+	dirty   bool  // whether the record has any runes yet
+	field   int   // index of the field in the record
+	n       int   // runes in the quoted field
+	quoted  []int // quoted empty fields of the record
+	records []quotedRecord
+}
+
+// quotedRecord lists the quoted empty fields of the record ending at end.
+type quotedRecord struct {
+	end    int64
+	fields []int
+}
+
+// commentLine is the state of a quoteReader in a comment line. Its other
+// states are those of an rfcScanner.
+const commentLine = carriage + 1
+
+func newQuoteReader(r io.Reader) *quoteReader {
+	return &quoteReader{r: r, comma: ','}
+}
+
+func (q *quoteReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	for _, b := range p[:n] {
+		q.off++
+		q.ch = append(q.ch, b)
+		for len(q.ch) > 0 && utf8.FullRune(q.ch) {
+			r, size := utf8.DecodeRune(q.ch)
+			q.ch = q.ch[:copy(q.ch, q.ch[size:])]
+			q.step(r)
+		}
+	}
+	if err == io.EOF && q.dirty {
+		if q.state == quote {
+			q.closeField()
+		}
+		q.endRecord()
+	}
+	return n, err
+}
+
+func (q *quoteReader) step(r rune) {
+	first := !q.dirty
+	q.dirty = true
+	switch q.state {
+	case commentLine:
+		if r == '\n' {
+			q.state, q.dirty = fieldStart, false
+		}
+	case fieldStart:
+		switch {
+		case first && q.comment != 0 && r == q.comment:
+			q.state = commentLine
+		case r == '"':
+			q.state, q.n = quoted, 0
+		case r == q.comma:
+			q.field++
+		case r == '\n':
+			q.endRecord()
+		case q.trim && unicode.IsSpace(r):
+		default:
+			q.state = unquoted
+		}
+	case unquoted:
+		switch r {
+		case q.comma:
+			q.state = fieldStart
+			q.field++
+		case '\n':
+			q.endRecord()
+		}
+	case quoted:
+		if r == '"' {
+			q.state = quote
+		} else {
+			q.n++
+		}
+	case quote:
+		switch {
+		case r == '"':
+			// An escaped quote.
+			q.state = quoted
+			q.n++
+		case r == q.comma:
+			q.closeField()
+			q.state = fieldStart
+			q.field++
+		case r == '\n':
+			q.closeField()
+			q.endRecord()
+		case r == '\r':
+			q.closeField()
+			q.state = unquoted
+		case q.lazy:
+			// The quote is part of the field.
+			q.state = quoted
+			q.n += 2
+		default:
+			q.state = unquoted
+		}
+	}
+}
+
+// closeField ends a quoted field.
+func (q *quoteReader) closeField() {
+	if q.n == 0 {
+		q.quoted = append(q.quoted, q.field)
+	}
+}
+
+// endRecord ends the record at the current offset.
+func (q *quoteReader) endRecord() {
+	if q.quoted != nil {
+		q.records = append(q.records, quotedRecord{q.off, q.quoted})
+	}
+	q.state, q.dirty, q.field, q.quoted = fieldStart, false, 0, nil
+}
+
+// quotedFields returns the quoted empty fields of the record ending at end,
+// forgetting those of the records before it.
+func (q *quoteReader) quotedFields(end int64) []int {
+	for len(q.records) > 0 && q.records[0].end < end {
+		q.records = q.records[1:]
+	}
+	if len(q.records) > 0 && q.records[0].end == end {
+		return q.records[0].fields
+	}
+	return nil
+}
+
+// quotedEmpty reports whether the cell in column idx of the last record
+// read was written as "".
+func (d *decoder) quotedEmpty(idx int) bool {
+	for _, i := range d.quoted {
+		if i == idx {
+			return true
+		}
+	}
+	return false
+}
+
+// setEmpty sets the pointer or sql.Scanner field vf from an empty cell,
+// reporting whether it did. Unquoted empty cells leave pointers nil and
+// are scanned as SQL NULL; quoted ones, "", set *string fields to point to
+// an empty string, and are scanned as an empty string.
+func (d *decoder) setEmpty(vf reflect.Value, quoted bool) (bool, error) {
+	if !vf.CanSet() || registeredDecoder(vf.Type()) != nil {
+		return false, nil
+	}
+	if vf.Addr().Type().Implements(scannerType) {
+		var src interface{}
+		if quoted {
+			src = ""
+		}
+		if err := vf.Addr().Interface().(sql.Scanner).Scan(src); err != nil {
+			return true, fmt.Errorf("error decoding: %v", err)
+		}
+		return true, nil
+	}
+	if vf.Kind() != reflect.Ptr {
+		return false, nil
+	}
+	switch {
+	case !quoted:
+		vf.Set(reflect.Zero(vf.Type()))
+	case vf.Type().Elem().Kind() == reflect.String && !vf.Type().Implements(textUnmarshalerType):
+		vf.Set(reflect.New(vf.Type().Elem()))
+	default:
+		return false, nil
+	}
+	return true, nil
+}
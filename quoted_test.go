@@ -0,0 +1,63 @@
+package csvstruct
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode_QuotedEmpty(t *testing.T) {
+	type row struct {
+		ID    int
+		Name  *string
+		Note  sql.NullString
+		Count *int
+	}
+	in := "# comment with \"quote\n" +
+		"ID,Name,Note,Count\n" +
+		"1,\"\",\"\",\n" +
+		"\n" +
+		"2,,,\n" +
+		"3,\"a\nb\",\"\"\"\",\"\"\r\n" +
+		"4,x,\"\"," +
+		"\n5,\"\",,"
+	empty, ab, x := "", "a\nb", "x"
+	// Small buffers split records across reads.
+	for _, size := range []int{0, 16} {
+		d := NewDecoder(strings.NewReader(in), WithComment('#'), WithQuotedEmpty(), WithBufferSize(size))
+		for _, want := range []row{
+			{1, &empty, sql.NullString{Valid: true}, nil},
+			{2, nil, sql.NullString{}, nil},
+			{3, &ab, sql.NullString{String: `"`, Valid: true}, nil},
+			{4, &x, sql.NullString{Valid: true}, nil},
+			{5, &empty, sql.NullString{}, nil},
+		} {
+			var got row
+			err := d.DecodeNext(&got)
+			if want.ID == 3 {
+				// "" is not a valid int.
+				if err == nil {
+					t.Errorf("DecodeNext(quoted empty int): expected error")
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("DecodeNext: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		}
+	}
+
+	// Without QuotedEmpty, both decode alike.
+	var got row
+	d := NewDecoder(strings.NewReader("ID,Name,Note\n1,\"\",\"\"\n"))
+	if err := d.DecodeNext(&got); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	if got.Name == nil || *got.Name != "" || got.Note.Valid {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,162 @@
+package csvstruct
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReshapeOpts specifies options for Melt and Pivot.
+type ReshapeOpts struct {
+	IDs         []string // columns that identify a wide row
+	NameColumn  string   // long column holding wide column names; "name" by default
+	ValueColumn string   // long column holding wide cell values; "value" by default
+
+	Decode DecodeOpts // options for reading the input
+	Encode EncodeOpts // options for writing the output
+}
+
+func (o ReshapeOpts) names() (string, string) {
+	name, value := o.NameColumn, o.ValueColumn
+	if name == "" {
+		name = "name"
+	}
+	if value == "" {
+		value = "value"
+	}
+	return name, value
+}
+
+// Melt converts wide rows read from r, with one column per metric, to long
+// rows written to w. Each cell of each column other than IDs becomes a row
+// holding the IDs, the column's name and the cell's value.
+//
+// Melt streams its input.
+func Melt(w io.Writer, r io.Reader, opts ReshapeOpts) error {
+	d := NewDecoder(r).Opts(opts.Decode).(*decoder)
+	if err := d.readHeader(); err != nil {
+		return err
+	}
+	ids, err := columnIndexes(d.hm, opts.IDs)
+	if err != nil {
+		return err
+	}
+	isID := map[int]bool{}
+	for _, i := range ids {
+		isID[i] = true
+	}
+	name, value := opts.names()
+	e := NewEncoder(w).Opts(opts.Encode).(*encoder)
+	if e.err != nil {
+		return e.err
+	}
+	if err := e.setHeader(append(append([]string(nil), opts.IDs...), name, value)); err != nil {
+		return err
+	}
+	for {
+		rec, err := d.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		for i, h := range d.header {
+			if isID[i] {
+				continue
+			}
+			row := make([]string, 0, len(ids)+2)
+			for _, c := range ids {
+				row = append(row, cell(rec, c))
+			}
+			if err := e.writeRow(append(row, h, cell(rec, i))); err != nil {
+				return err
+			}
+		}
+	}
+	return e.Close()
+}
+
+// Pivot converts long rows read from r to wide rows written to w, reversing
+// Melt. Rows with the same IDs are combined into one row, with a column for
+// each distinct value of NameColumn holding the corresponding value of
+// ValueColumn. Rows and columns appear in the order they are first seen.
+//
+// Pivot holds the whole output in memory, since the columns are not known
+// until the input has been read.
+func Pivot(w io.Writer, r io.Reader, opts ReshapeOpts) error {
+	d := NewDecoder(r).Opts(opts.Decode).(*decoder)
+	if err := d.readHeader(); err != nil {
+		return err
+	}
+	ids, err := columnIndexes(d.hm, opts.IDs)
+	if err != nil {
+		return err
+	}
+	name, value := opts.names()
+	nv, err := columnIndexes(d.hm, []string{name, value})
+	if err != nil {
+		return err
+	}
+
+	header := append([]string(nil), opts.IDs...)
+	cols := map[string]int{} // output column of each name
+	rows := map[string]map[int]string{}
+	var order [][]string // IDs of each row, in order
+	for {
+		rec, err := d.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		key := make([]string, len(ids))
+		for i, c := range ids {
+			key[i] = cell(rec, c)
+		}
+		k := strings.Join(key, "\x00")
+		row, ok := rows[k]
+		if !ok {
+			row = map[int]string{}
+			rows[k] = row
+			order = append(order, key)
+		}
+		n := cell(rec, nv[0])
+		c, ok := cols[n]
+		if !ok {
+			c = len(header)
+			cols[n] = c
+			header = append(header, n)
+		}
+		if _, ok := row[c]; ok {
+			return fmt.Errorf("row %d: duplicate value for %q", d.rows, n)
+		}
+		row[c] = cell(rec, nv[1])
+	}
+
+	e := NewEncoder(w).Opts(opts.Encode).(*encoder)
+	if e.err != nil {
+		return e.err
+	}
+	if err := e.setHeader(header); err != nil {
+		return err
+	}
+	for _, key := range order {
+		out := make([]string, len(header))
+		copy(out, key)
+		for c, v := range rows[strings.Join(key, "\x00")] {
+			out[c] = v
+		}
+		if err := e.writeRow(out); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// cell returns rec[i], or "" if rec is too short.
+func cell(rec []string, i int) string {
+	if i < len(rec) {
+		return rec[i]
+	}
+	return ""
+}
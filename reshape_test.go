@@ -0,0 +1,46 @@
+package csvstruct
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const (
+	wide = `city,year,temp,rain
+Oslo,2020,6,800
+Rome,2020,16,
+`
+	long = `city,year,name,value
+Oslo,2020,temp,6
+Oslo,2020,rain,800
+Rome,2020,temp,16
+Rome,2020,rain,
+`
+)
+
+func TestMelt(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Melt(&buf, strings.NewReader(wide), ReshapeOpts{IDs: []string{"city", "year"}}); err != nil {
+		t.Fatalf("Melt: %v", err)
+	}
+	if got := buf.String(); got != long {
+		t.Errorf("got\n%s\nwant\n%s", got, long)
+	}
+}
+
+func TestPivot(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Pivot(&buf, strings.NewReader(long), ReshapeOpts{IDs: []string{"city", "year"}}); err != nil {
+		t.Fatalf("Pivot: %v", err)
+	}
+	if got := buf.String(); got != wide {
+		t.Errorf("got\n%s\nwant\n%s", got, wide)
+	}
+
+	buf.Reset()
+	in := "id,metric,v\n1,a,x\n1,a,y\n"
+	if err := Pivot(&buf, strings.NewReader(in), ReshapeOpts{IDs: []string{"id"}, NameColumn: "metric", ValueColumn: "v"}); err == nil {
+		t.Errorf("Pivot: expected error for duplicate value")
+	}
+}
@@ -0,0 +1,208 @@
+package csvstruct
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Violation is a departure from RFC 4180 in CSV input.
+type Violation struct {
+	Line   int // line of the violation, from 1
+	Column int // byte offset in the line, from 1
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("RFC 4180 violation at line %d, column %d: %s", v.Line, v.Column, v.Reason)
+}
+
+// Validate reads all of r and returns the ways it departs from RFC 4180,
+// in order, so that files can be checked before they are accepted: bare
+// CRs, quotes in unquoted fields, unescaped quotes in quoted fields,
+// unterminated quoted fields, empty lines, records with a different number
+// of fields than the first, and a missing final newline. Lines may end in
+// LF as well as CRLF. An error reading r is reported as a final Violation.
+func Validate(r io.Reader) []Violation {
+	var vs []Violation
+	s := newRFCScanner(',', func(v Violation) { vs = append(vs, v) })
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			s.end()
+			return vs
+		} else if err != nil {
+			s.report(Violation{s.line, s.col + 1, err.Error()})
+			return vs
+		}
+		s.step(c)
+	}
+}
+
+// States of an rfcScanner.
+const (
+	fieldStart = iota // at the start of a field
+	unquoted          // in an unquoted field
+	quoted            // in a quoted field
+	quote             // after a quote in a quoted field
+	carriage          // after a CR outside a quoted field
+)
+
+// rfcScanner checks CSV input against RFC 4180 a byte at a time.
+type rfcScanner struct {
+	comma  byte
+	report func(Violation)
+
+	state      int
+	line, col  int  // position of the last byte
+	crCol      int  // column of the CR, in state carriage
+	qLine      int  // line of the opening quote, in state quoted
+	qCol       int  // column of the opening quote, in state quoted
+	blank      bool // whether the record has no bytes yet
+	recLine    int  // line the record starts on
+	fields     int  // fields of the record ended so far
+	wantFields int  // fields in the first record, or zero
+}
+
+func newRFCScanner(comma byte, report func(Violation)) *rfcScanner {
+	return &rfcScanner{comma: comma, report: report, line: 1, blank: true, recLine: 1}
+}
+
+func (s *rfcScanner) violate(line, col int, format string, args ...interface{}) {
+	s.report(Violation{line, col, fmt.Sprintf(format, args...)})
+}
+
+// step scans the next byte of input.
+func (s *rfcScanner) step(c byte) {
+	s.col++
+	if s.state == carriage {
+		if c == '\n' {
+			s.endRecord()
+			return
+		}
+		// The CR is part of the field.
+		s.violate(s.line, s.crCol, "bare CR")
+		s.state = unquoted
+	}
+	if c != '\r' && c != '\n' {
+		s.blank = false
+	}
+	switch s.state {
+	case fieldStart, unquoted:
+		switch c {
+		case s.comma:
+			s.fields++
+			s.state = fieldStart
+		case '\r':
+			s.state, s.crCol = carriage, s.col
+		case '\n':
+			s.endRecord()
+		case '"':
+			if s.state == fieldStart {
+				s.state, s.qLine, s.qCol = quoted, s.line, s.col
+			} else {
+				s.violate(s.line, s.col, "quote in unquoted field")
+			}
+		default:
+			s.state = unquoted
+		}
+	case quoted:
+		switch c {
+		case '"':
+			s.state = quote
+		case '\n':
+			s.line, s.col = s.line+1, 0
+		}
+	case quote:
+		switch c {
+		case '"':
+			// An escaped quote.
+			s.state = quoted
+		case s.comma, '\r', '\n':
+			// The closing quote.
+			s.state = unquoted
+			s.col--
+			s.step(c)
+		default:
+			s.violate(s.line, s.col-1, "unescaped quote in quoted field")
+			s.state = unquoted
+		}
+	}
+}
+
+// endRecord ends the record at a newline.
+func (s *rfcScanner) endRecord() {
+	if s.blank {
+		s.violate(s.line, 1, "empty line")
+	} else {
+		s.checkFields()
+	}
+	s.state, s.fields, s.blank = fieldStart, 0, true
+	s.line, s.col = s.line+1, 0
+	s.recLine = s.line
+}
+
+// checkFields compares the number of fields in the record to the first's.
+func (s *rfcScanner) checkFields() {
+	n := s.fields + 1
+	if s.wantFields == 0 {
+		s.wantFields = n
+	} else if n != s.wantFields {
+		s.violate(s.recLine, 1, "record has %d fields, want %d", n, s.wantFields)
+	}
+}
+
+// end scans the end of the input.
+func (s *rfcScanner) end() {
+	switch s.state {
+	case quoted:
+		s.violate(s.qLine, s.qCol, "unterminated quoted field")
+		return
+	case carriage:
+		s.violate(s.line, s.crCol, "bare CR")
+	}
+	if !s.blank || s.state == carriage {
+		s.violate(s.line, s.col+1, "missing final newline")
+		s.checkFields()
+	}
+}
+
+// rfcReader passes input through, failing at the first violation of RFC
+// 4180 after passing on the input before it.
+type rfcReader struct {
+	r   io.Reader
+	s   *rfcScanner
+	v   *Violation // the first violation
+	err error
+}
+
+func newRFCReader(r io.Reader, comma rune) *rfcReader {
+	rr := &rfcReader{r: r}
+	rr.s = newRFCScanner(byte(comma), func(v Violation) {
+		if rr.v == nil {
+			rr.v = &v
+		}
+	})
+	return rr
+}
+
+func (r *rfcReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	for i := 0; i < n; i++ {
+		if r.s.step(p[i]); r.v != nil {
+			r.err = r.v
+			return i, nil
+		}
+	}
+	if err == io.EOF {
+		if r.s.end(); r.v != nil {
+			r.err = r.v
+			return n, nil
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,69 @@
+package csvstruct
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want []Violation
+	}{
+		{"a,b\r\n1,2\r\n", nil},
+		{"a,b\n\"x,\"\"y\"\"\n\",2\n", nil},
+		{"", nil},
+		{"a,b\n1,2", []Violation{{2, 4, "missing final newline"}}},
+		{"a,b\n1\r2,3\n", []Violation{{2, 2, "bare CR"}}},
+		{"a,b\n1,x\"y\"\n", []Violation{{2, 4, "quote in unquoted field"}, {2, 6, "quote in unquoted field"}}},
+		{"a,b\n\"x\"y,2\n", []Violation{{2, 3, "unescaped quote in quoted field"}}},
+		{"a,b\n1,2,3\n4\n", []Violation{{2, 1, "record has 3 fields, want 2"}, {3, 1, "record has 1 fields, want 2"}}},
+		{"a,b\n\n1,2\n", []Violation{{2, 1, "empty line"}}},
+		{"a,b\n1,\"2\n", []Violation{{2, 3, "unterminated quoted field"}}},
+	} {
+		if got := Validate(strings.NewReader(c.in)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Validate(%q): got %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestDecode_RFC4180(t *testing.T) {
+	type row struct{ A, B string }
+	d := NewDecoder(strings.NewReader("A,B\n1,2\n3,4\n5,x\"y\n7,8\n"), WithRFC4180())
+	for _, want := range []row{{"1", "2"}, {"3", "4"}} {
+		var got row
+		if err := d.DecodeNext(&got); err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	var got row
+	err := d.DecodeNext(&got)
+	var v *Violation
+	if !errors.As(err, &v) || *v != (Violation{4, 4, "quote in unquoted field"}) {
+		t.Errorf("DecodeNext(violation): got %v", err)
+	}
+
+	// Valid input decodes to the end.
+	d = NewDecoder(strings.NewReader("A;B\r\n1;2\r\n"), WithComma(';'), WithRFC4180())
+	if err := d.DecodeNext(&got); err != nil {
+		t.Errorf("DecodeNext: %v", err)
+	}
+	if err := d.DecodeNext(&got); err != io.EOF {
+		t.Errorf("DecodeNext at end: got %v, want EOF", err)
+	}
+
+	d = NewDecoder(strings.NewReader("A,B\n1,2"), WithRFC4180())
+	if err := d.DecodeNext(&got); !errors.As(err, &v) || v.Reason != "missing final newline" {
+		t.Errorf("DecodeNext(no final newline): got %v", err)
+	}
+
+	if err := NewDecoder(strings.NewReader(""), WithRFC4180(), WithLazyQuotes()).DecodeNext(&got); err == nil {
+		t.Errorf("RFC4180 with LazyQuotes: expected error")
+	}
+}
@@ -59,3 +59,255 @@ c,d,456,foo,128.0.0.1
 	}
 
 }
+
+// userMessage mimics the shape of a struct generated by protoc-gen-go.
+type userMessage struct {
+	state         struct{}
+	sizeCache     int32
+	unknownFields []byte
+
+	UserId      int64  `protobuf:"varint,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	DisplayName string `protobuf:"bytes,2,opt,name=display_name,json=displayName,proto3" json:"display_name,omitempty"`
+	Admin       bool   `protobuf:"varint,3,opt,name=admin,proto3" json:"admin,omitempty"`
+	// Types that are valid to be assigned to Contact: ...
+	Contact interface{} `protobuf_oneof:"contact"`
+}
+
+func TestRoundTrip_ProtoNames(t *testing.T) {
+	in := []userMessage{{UserId: 1, DisplayName: "Alice", Admin: true}, {UserId: 2, DisplayName: "Bob"}}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{ProtoNames: true})
+	for _, m := range in {
+		if err := e.EncodeNext(m); err != nil {
+			t.Errorf("EncodeNext(%v): %v", m, err)
+		}
+	}
+	want := `userId,displayName,admin
+1,Alice,true
+2,Bob,false
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	d := NewDecoder(&buf).Opts(DecodeOpts{ProtoNames: true})
+	out := []userMessage{}
+	for {
+		var m userMessage
+		if err := d.DecodeNext(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		out = append(out, m)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %v, want %v", out, in)
+	}
+}
+
+func TestRoundTrip_Arrays(t *testing.T) {
+	type row struct {
+		Name  string
+		Point [2]int
+		Tags  [3]string
+	}
+	in := []row{{"a", [2]int{1, -2}, [3]string{"x", "y", "z"}}, {"b", [2]int{}, [3]string{"x", "", ""}}}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{ArrayDelimiter: ";"})
+	for _, i := range in {
+		if err := e.EncodeNext(i); err != nil {
+			t.Errorf("unexpected error encoding %v: %v", i, err)
+		}
+	}
+	want := `Name,Point,Tags
+a,1;-2,x;y;z
+b,0;0,x;;
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected result, got %s, want %s", got, want)
+	}
+
+	out := []row{}
+	d := NewDecoder(&buf).Opts(DecodeOpts{ArrayDelimiter: ";"})
+	for {
+		var r row
+		if err := d.DecodeNext(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Errorf("unexpected error decoding: %v", err)
+		}
+		out = append(out, r)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got unexpected result, got %v, want %v", out, in)
+	}
+
+	d = NewDecoder(bytes.NewBufferString("Point\n1|2|3\n"))
+	var r row
+	if err := d.DecodeNext(&r); err == nil {
+		t.Errorf("expected error decoding too many array elements")
+	}
+}
+
+// textUUID mimics github.com/google/uuid.UUID.
+type textUUID [16]byte
+
+func (u textUUID) MarshalText() ([]byte, error) { return []byte(formatUUID(reflect.ValueOf(u))), nil }
+
+func (u *textUUID) UnmarshalText(b []byte) error {
+	return parseUUID(reflect.ValueOf(u).Elem(), string(b))
+}
+
+func TestRoundTrip_UUID(t *testing.T) {
+	type row struct {
+		ID     [16]byte
+		Ref    textUUID  `csv:"ref,braced,upper"`
+		Parent *[16]byte `csv:"Parent,omitempty"`
+	}
+	id := [16]byte{0xf4, 0x7a, 0xc1, 0x0b, 0x58, 0xcc, 0x43, 0x72, 0xa5, 0x67, 0x0e, 0x02, 0xb2, 0xc3, 0xd4, 0x79}
+	in := []row{{id, textUUID(id), &id}, {ID: id}}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, i := range in {
+		if err := e.EncodeNext(i); err != nil {
+			t.Errorf("unexpected error encoding %v: %v", i, err)
+		}
+	}
+	want := `ID,ref,Parent
+f47ac10b-58cc-4372-a567-0e02b2c3d479,{F47AC10B-58CC-4372-A567-0E02B2C3D479},f47ac10b-58cc-4372-a567-0e02b2c3d479
+f47ac10b-58cc-4372-a567-0e02b2c3d479,{00000000-0000-0000-0000-000000000000},
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected result, got %s, want %s", got, want)
+	}
+
+	out := []row{}
+	d := NewDecoder(&buf).Opts(DecodeOpts{})
+	for {
+		var r row
+		if err := d.DecodeNext(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Errorf("unexpected error decoding: %v", err)
+		}
+		out = append(out, r)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got unexpected result, got %v, want %v", out, in)
+	}
+
+	for _, s := range []string{"urn:uuid:F47AC10B-58CC-4372-A567-0E02B2C3D479", "f47ac10b58cc4372a5670e02b2c3d479"} {
+		var got [16]byte
+		if err := parseUUID(reflect.ValueOf(&got).Elem(), s); err != nil || got != id {
+			t.Errorf("parseUUID(%q): got %x, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"f47ac10b-58cc-4372-a567", "f47ac10b_58cc_4372_a567_0e02b2c3d479", "g47ac10b-58cc-4372-a567-0e02b2c3d479"} {
+		var got [16]byte
+		if err := parseUUID(reflect.ValueOf(&got).Elem(), s); err == nil {
+			t.Errorf("parseUUID(%q): expected error", s)
+		}
+	}
+}
+
+func TestRoundTrip_Inline(t *testing.T) {
+	type row struct {
+		ID    int
+		Attrs map[string]string `csv:",inline"`
+	}
+	in := []row{
+		{1, map[string]string{"color": "red", "size": "L"}},
+		{2, map[string]string{"size": "M", "weight": "3"}},
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, i := range in {
+		if err := e.EncodeNext(i); err != nil {
+			t.Errorf("unexpected error encoding %v: %v", i, err)
+		}
+	}
+	// The columns come from the first row, so "weight" is dropped.
+	want := `ID,color,size
+1,red,L
+2,,M
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected result, got %s, want %s", got, want)
+	}
+
+	out := []row{}
+	d := NewDecoder(&buf)
+	for {
+		var r row
+		if err := d.DecodeNext(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Errorf("unexpected error decoding: %v", err)
+		}
+		out = append(out, r)
+	}
+	wantRows := []row{
+		{1, map[string]string{"color": "red", "size": "L"}},
+		{2, map[string]string{"color": "", "size": "M"}},
+	}
+	if !reflect.DeepEqual(out, wantRows) {
+		t.Errorf("got unexpected result, got %v, want %v", out, wantRows)
+	}
+
+	var dyn struct {
+		ID    string
+		Attrs map[string]interface{} `csv:",inline"`
+	}
+	d = NewDecoder(bytes.NewBufferString("ID,n,ok\nx,3,true\n"))
+	if err := d.DecodeNext(&dyn); err != nil {
+		t.Fatalf("unexpected error decoding: %v", err)
+	}
+	if want := map[string]interface{}{"n": int64(3), "ok": true}; !reflect.DeepEqual(dyn.Attrs, want) {
+		t.Errorf("got %v, want %v", dyn.Attrs, want)
+	}
+}
+
+func TestRoundTrip_AllowDup(t *testing.T) {
+	type row struct {
+		ID     int
+		Key1   string `csv:"key,allowdup"`
+		Value1 string `csv:"value,allowdup"`
+		Key2   string `csv:"key,allowdup"`
+		Value2 string `csv:"value,allowdup"`
+	}
+	in := []row{{1, "color", "red", "size", "L"}, {2, "color", "blue", "", ""}}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, i := range in {
+		if err := e.EncodeNext(i); err != nil {
+			t.Errorf("unexpected error encoding %v: %v", i, err)
+		}
+	}
+	want := `ID,key,value,key,value
+1,color,red,size,L
+2,color,blue,,
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected result, got %s, want %s", got, want)
+	}
+
+	out := []row{}
+	d := NewDecoder(&buf)
+	for {
+		var r row
+		if err := d.DecodeNext(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Errorf("unexpected error decoding: %v", err)
+		}
+		out = append(out, r)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got unexpected result, got %v, want %v", out, in)
+	}
+}
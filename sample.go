@@ -0,0 +1,50 @@
+package csvstruct
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+)
+
+// Sample returns n rows of r chosen uniformly at random, decoded into Ts, in
+// a single pass over the input. Only the rows chosen are decoded. If r has
+// n rows or fewer, all of them are returned, in order; otherwise the order
+// is random. A chosen row that a field tagged "onerror=skiprow" skips is
+// passed over.
+func Sample[T any](r io.Reader, n int, opts DecodeOpts) ([]T, error) {
+	if n < 0 {
+		return nil, errors.New("negative sample size")
+	}
+	d := NewDecoder(r).Opts(opts).(*decoder)
+	out := make([]T, 0, n)
+	for seen := 0; ; {
+		line, err := d.read()
+		if err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// Reservoir sampling: the row replaces a random one of those
+		// chosen so far with probability n/(seen+1).
+		i := seen
+		if seen >= n {
+			i = rand.Intn(seen + 1)
+			if i >= n {
+				seen++
+				continue
+			}
+		}
+		var row T
+		if err := d.decodeRow(&row, line); err == errSkipRow {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		seen++
+		if i == len(out) {
+			out = append(out, row)
+		} else {
+			out[i] = row
+		}
+	}
+}
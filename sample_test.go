@@ -0,0 +1,58 @@
+package csvstruct
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	type row struct{ N int }
+	var b strings.Builder
+	b.WriteString("N\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	in := b.String()
+
+	all, err := Sample[row](strings.NewReader(in), 50, DecodeOpts{})
+	if err != nil {
+		t.Fatalf("Sample: %v", err)
+	}
+	if len(all) != 20 || all[0].N != 0 || all[19].N != 19 {
+		t.Errorf("Sample(50): got %v, want all rows in order", all)
+	}
+
+	counts := make([]int, 20)
+	for trial := 0; trial < 500; trial++ {
+		got, err := Sample[row](strings.NewReader(in), 5, DecodeOpts{})
+		if err != nil {
+			t.Fatalf("Sample: %v", err)
+		}
+		if len(got) != 5 {
+			t.Fatalf("Sample(5): got %d rows", len(got))
+		}
+		seen := map[int]bool{}
+		for _, r := range got {
+			if seen[r.N] {
+				t.Fatalf("Sample(5): row %d chosen twice in %v", r.N, got)
+			}
+			seen[r.N] = true
+			counts[r.N]++
+		}
+	}
+	// Each row is chosen with probability 1/4, so about 125 times.
+	for n, c := range counts {
+		if c < 60 || c > 200 {
+			t.Errorf("row %d chosen %d times in 500 samples; want about 125", n, c)
+		}
+	}
+
+	if got, err := Sample[row](strings.NewReader(in), 0, DecodeOpts{}); err != nil || !reflect.DeepEqual(got, []row{}) {
+		t.Errorf("Sample(0): got %v, %v", got, err)
+	}
+	if _, err := Sample[row](strings.NewReader("N\nx\n"), 1, DecodeOpts{}); err == nil {
+		t.Errorf("Sample(bad row): expected error")
+	}
+}
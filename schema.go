@@ -0,0 +1,238 @@
+package csvstruct
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+var orderedMapType = reflect.TypeOf(OrderedMap{})
+
+// SchemaHash returns the hash of the columns an Encoder configured by
+// options would write for the row v: their names, order and Go types. It is
+// the value written by EncodeOpts.SchemaHash, for consumers to set as
+// DecodeOpts.SchemaHash.
+func SchemaHash(v interface{}, options ...Option) (string, error) {
+	e := NewEncoder(io.Discard, append(options, WithSkipHeader())...).(*encoder)
+	if err := e.EncodeNext(v); err != nil {
+		return "", err
+	}
+	return e.schemaHash(), nil
+}
+
+// schemaHash returns the hash of the encoder's header and the types of the
+// values in its columns.
+func (e *encoder) schemaHash() string {
+	h := sha256.New()
+	var n [binary.MaxVarintLen64]byte
+	add := func(s string) {
+		// Prefix each value with its length so that values can't run
+		// together.
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+	types := columnTypes(e.rowType, e.opts.ProtoNames, e.opts.Mapping)
+	for i, c := range e.headers {
+		add(c)
+		if t := types(e.names[i]); t != nil {
+			add(t.String())
+		} else {
+			add("")
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+var (
+	stringType    = reflect.TypeOf("")
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// columnTypes returns a function giving the Go type of the values in each
+// column of rows of type t, or nil if it isn't known. Columns of rows
+// written by the row-level utilities, which have no type, are strings.
+func columnTypes(t reflect.Type, protoNames bool, m *Mapping) func(column string) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == nil:
+		return func(string) reflect.Type { return stringType }
+	case t == orderedMapType:
+		return func(string) reflect.Type { return interfaceType }
+	case t.Kind() == reflect.Map:
+		return func(string) reflect.Type { return t.Elem() }
+	case t.Kind() != reflect.Struct:
+		return func(string) reflect.Type { return nil }
+	}
+	// A malformed tag fails encoding before the types are needed.
+	fields, _ := structFields(t, protoNames, m)
+	types := map[string]reflect.Type{}
+	var inline reflect.Type
+	for _, f := range fields {
+		if f.inline {
+			inline = t.FieldByIndex(f.index).Type.Elem()
+			continue
+		}
+		types[f.name] = t.FieldByIndex(f.index).Type
+	}
+	return func(c string) reflect.Type {
+		if t, ok := types[c]; ok {
+			return t
+		}
+		return inline
+	}
+}
+
+// checkSchema checks that the hash in the schema line read before the
+// header is want.
+func (d *decoder) checkSchema(want string) error {
+	got, ok := d.preamble["schema"]
+	if !ok {
+		return fmt.Errorf("missing schema hash; want %s", want)
+	}
+	if got != want {
+		return fmt.Errorf("schema hash %s does not match %s", got, want)
+	}
+	return nil
+}
+
+// Schema describes the columns of a CSV file and the struct fields or map
+// keys they hold, so that a mapping can be loaded at runtime with
+// LoadSchema rather than compiled into struct tags. Set it as
+// EncodeOpts.Schema or DecodeOpts.Schema.
+type Schema struct {
+	Columns []SchemaColumn `json:"columns"`
+}
+
+// SchemaColumn describes a column of a Schema.
+type SchemaColumn struct {
+	Name string `json:"name"` // column name in the header
+
+	// Field is the name of the struct field, as given by its tag, or the
+	// map key holding the column's values. If empty, it is Name.
+	Field string `json:"field,omitempty"`
+
+	// Type is the Kind the column's cells decode to when the target is an
+	// interface{} value, as with DecodeOpts.TypeOverrides. When encoding
+	// with QuoteNonNumeric, KindInt, KindFloat and KindBool columns aren't
+	// quoted.
+	Type Kind `json:"type,omitempty"`
+
+	// Format, if set, is the fmt format the column's values are encoded
+	// with, e.g. "%.2f" or "%05d". A float precision, as in "%.2f", is
+	// only allowed for columns of Kind KindFloat or KindInfer.
+	Format string `json:"format,omitempty"`
+
+	// Required columns must be in the header when decoding, and their
+	// cells must not be empty when encoding or decoding.
+	Required bool `json:"required,omitempty"`
+}
+
+// key returns the field or map key of the column.
+func (c SchemaColumn) key() string {
+	if c.Field != "" {
+		return c.Field
+	}
+	return c.Name
+}
+
+// LoadSchema reads a Schema from the JSON document r, such as
+//
+//	{"columns": [
+//		{"name": "Zip Code", "field": "zip", "type": "string", "required": true},
+//		{"name": "Price", "field": "price", "type": "float", "format": "%.2f"}
+//	]}
+func LoadSchema(r io.Reader) (*Schema, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var s Schema
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("error reading schema: %v", err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// validate reports columns of s without names, and repeated names and
+// keys.
+func (s *Schema) validate() error {
+	names, keys := map[string]bool{}, map[string]bool{}
+	for i, c := range s.Columns {
+		switch {
+		case c.Name == "":
+			return fmt.Errorf("schema column %d has no name", i)
+		case names[c.Name]:
+			return fmt.Errorf("schema column %q is repeated", c.Name)
+		case keys[c.key()]:
+			return fmt.Errorf("schema field %q is repeated", c.key())
+		case c.Type < KindInfer || c.Type > KindBool:
+			return fmt.Errorf("unknown Kind %d for schema column %q", c.Type, c.Name)
+		case floatPrecision(c.Format) && c.Type != KindInfer && c.Type != KindFloat:
+			return fmt.Errorf("schema column %q has Format %q, but is of Kind %v", c.Name, c.Format, c.Type)
+		}
+		names[c.Name], keys[c.key()] = true, true
+	}
+	return nil
+}
+
+// names returns the column names of s.
+func (s *Schema) names() []string {
+	out := make([]string, len(s.Columns))
+	for i, c := range s.Columns {
+		out[i] = c.Name
+	}
+	return out
+}
+
+// keys returns the fields or map keys of the columns of s.
+func (s *Schema) keys() []string {
+	out := make([]string, len(s.Columns))
+	for i, c := range s.Columns {
+		out[i] = c.key()
+	}
+	return out
+}
+
+// project maps the keys of the columns of s to their indexes in the header
+// mapped by hm.
+func (s *Schema) project(hm map[string]int) map[string]int {
+	m := make(map[string]int, len(s.Columns))
+	for _, c := range s.Columns {
+		if i, ok := hm[c.Name]; ok {
+			m[c.key()] = i
+		}
+	}
+	return m
+}
+
+// checkRequired checks that the required columns of the Schema are in the
+// header.
+func (d *decoder) checkRequired() error {
+	for _, c := range d.opts.Schema.Columns {
+		if _, ok := d.hm[c.key()]; c.Required && !ok {
+			return fmt.Errorf("missing required column %q", c.Name)
+		}
+	}
+	return nil
+}
+
+// checkCells checks that the cells of line in the required columns of the
+// Schema aren't empty.
+func (d *decoder) checkCells(line []string) error {
+	for _, c := range d.opts.Schema.Columns {
+		if !c.Required {
+			continue
+		}
+		if i, ok := d.hm[c.key()]; ok && (i >= len(line) || line[i] == "") {
+			return fmt.Errorf("row %d: required column %q is empty", d.rows, c.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package csvstruct
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSchemaHash(t *testing.T) {
+	type row struct {
+		Name string
+		Age  int
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{SchemaHash: true})
+	for _, r := range []row{{"alice", 30}, {"bob", 40}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	hash, err := SchemaHash(row{})
+	if err != nil {
+		t.Fatalf("SchemaHash: %v", err)
+	}
+	want := "#schema: " + hash + "\nName,Age\nalice,30\nbob,40\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	d := NewDecoder(strings.NewReader(want)).Opts(DecodeOpts{SchemaHash: hash})
+	var rows []row
+	for {
+		var r row
+		if err := d.DecodeNext(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		rows = append(rows, r)
+	}
+	if len(rows) != 2 {
+		t.Errorf("got %d rows, want 2", len(rows))
+	}
+
+	// Renaming, reordering or retyping a column changes the hash.
+	for _, v := range []interface{}{
+		struct {
+			Name  string
+			Years int
+		}{},
+		struct {
+			Age  int
+			Name string
+		}{},
+		struct {
+			Name string
+			Age  int64
+		}{},
+		map[string]string{"Name": "", "Age": ""},
+	} {
+		h, err := SchemaHash(v)
+		if err != nil {
+			t.Errorf("SchemaHash(%T): %v", v, err)
+		}
+		if h == hash {
+			t.Errorf("SchemaHash(%T) = %s, same as %T", v, h, row{})
+		}
+		var r row
+		err = NewDecoder(strings.NewReader(want)).Opts(DecodeOpts{SchemaHash: h}).DecodeNext(&r)
+		if err == nil || !strings.Contains(err.Error(), "does not match") {
+			t.Errorf("DecodeNext with hash of %T: expected mismatch, got %v", v, err)
+		}
+	}
+
+	var r row
+	err = NewDecoder(strings.NewReader("Name,Age\nalice,30\n")).Opts(DecodeOpts{SchemaHash: hash}).DecodeNext(&r)
+	if err == nil || !strings.Contains(err.Error(), "missing schema hash") {
+		t.Errorf("DecodeNext without schema line: expected error, got %v", err)
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	const doc = `{"columns": [
+		{"name": "Zip Code", "field": "zip", "type": "string", "required": true},
+		{"name": "Price", "field": "price", "type": "Float", "format": "%.2f"},
+		{"name": "note"}
+	]}`
+	s, err := LoadSchema(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("LoadSchema: %v", err)
+	}
+
+	type item struct {
+		Zip   string  `csv:"zip"`
+		Price float64 `csv:"price"`
+		Note  string  `csv:"note"`
+		Other string
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, WithSchema(s))
+	for _, it := range []item{{"02134", 3, "a", "x"}, {"10001", 2.5, "", "y"}} {
+		if err := e.EncodeNext(it); err != nil {
+			t.Errorf("EncodeNext(%v): %v", it, err)
+		}
+	}
+	if err := e.EncodeNext(item{Price: 1}); err == nil || !strings.Contains(err.Error(), `required column "Zip Code" is empty`) {
+		t.Errorf("EncodeNext(missing zip): got %v", err)
+	}
+	want := "Zip Code,Price,note\n02134,3.00,a\n10001,2.50,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	d := NewDecoder(strings.NewReader(want+"00501,1,b\n"), WithSchema(s))
+	var got []item
+	for {
+		var it item
+		if err := d.DecodeNext(&it); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		got = append(got, it)
+	}
+	if len(got) != 3 || got[0] != (item{Zip: "02134", Price: 3, Note: "a"}) || got[2].Zip != "00501" {
+		t.Errorf("decoded %v", got)
+	}
+
+	d = NewDecoder(strings.NewReader(want), WithSchema(s))
+	m := map[string]interface{}{}
+	if err := d.DecodeNext(&m); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	if m["zip"] != "02134" || m["price"] != 3.0 || m["note"] != "a" || len(m) != 3 {
+		t.Errorf("decoded %v", m)
+	}
+
+	for _, in := range []string{"Price,note\n3,a\n", "Zip Code,Price\n,3\n"} {
+		d := NewDecoder(strings.NewReader(in), WithSchema(s))
+		var it item
+		if err := d.DecodeNext(&it); err == nil || !strings.Contains(err.Error(), `"Zip Code"`) {
+			t.Errorf("DecodeNext(%q): got %v, want error", in, err)
+		}
+	}
+
+	for _, doc := range []string{
+		`{"columns": [{"name": "a"}, {"name": "a", "field": "b"}]}`,
+		`{"columns": [{"name": "a"}, {"name": "b", "field": "a"}]}`,
+		`{"columns": [{"field": "a"}]}`,
+		`{"columns": [{"name": "a", "type": "date"}]}`,
+		`{"columns": [{"name": "a", "width": 3}]}`,
+	} {
+		if _, err := LoadSchema(strings.NewReader(doc)); err == nil {
+			t.Errorf("LoadSchema(%s): expected error", doc)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package csvstruct
+
+import (
+	"io"
+	"strings"
+)
+
+// Section is one section of a multi-section CSV file.
+type Section struct {
+	Title   string  // first field of the section's title row, if any
+	Decoder Decoder // decodes the section's header and data rows
+}
+
+// SectionOpts specifies options for reading multi-section CSV files.
+type SectionOpts struct {
+	// DecodeOpts are applied when reading the file and to each section's
+	// Decoder. FieldsPerRecord only applies within a section.
+	DecodeOpts DecodeOpts
+
+	// IsTitle reports whether a record is a section title row. A title row
+	// always starts a new section, even without a preceding blank line. If
+	// nil, sections are only separated by blank lines and have no title.
+	IsTitle func(record []string) bool
+}
+
+// SectionReader splits a CSV file into sections separated by blank lines or
+// title rows, such as those found in bank statements and instrument exports.
+// Each section has its own header row.
+//
+// Since encoding/csv discards blank lines, they are detected from gaps in
+// line numbers; comment lines also separate sections when DecodeOpts.Comment
+// is set.
+type SectionReader struct {
+	d       *decoder // reads the file's records
+	opts    SectionOpts
+	next    *bufferedRecord // record read ahead of the current section
+	endLine int             // line on which the last record read ended
+}
+
+// bufferedRecord is a record of a section, held in memory until the
+// section's Decoder reads it.
+type bufferedRecord struct {
+	fields []string
+	quoted []int // columns holding "", when QuotedEmpty is set
+	line   int   // line on which the record starts
+}
+
+// NewSectionReader returns a SectionReader that reads from r.
+func NewSectionReader(r io.Reader, opts SectionOpts) *SectionReader {
+	ropts := opts.DecodeOpts
+	ropts.FieldsPerRecord = -1
+	ropts.ReuseRecord = false
+	return &SectionReader{d: NewDecoder(r).Opts(ropts).(*decoder), opts: opts}
+}
+
+// Next returns the next section of the file, or io.EOF if there are no more
+// sections. The section's rows are buffered in memory.
+func (s *SectionReader) Next() (*Section, error) {
+	rec, gap, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	sec := &Section{}
+	if s.opts.IsTitle != nil && s.opts.IsTitle(rec.fields) {
+		sec.Title = rec.fields[0]
+		rec, gap, err = s.read()
+		if err == io.EOF || gap {
+			s.next = rec
+			rec = nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var recs []bufferedRecord
+	for rec != nil {
+		recs = append(recs, *rec)
+		rec, gap, err = s.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if gap || (s.opts.IsTitle != nil && s.opts.IsTitle(rec.fields)) {
+			s.next = rec
+			break
+		}
+	}
+
+	d := NewDecoder(strings.NewReader("")).(*decoder)
+	d.buffered = recs
+	sec.Decoder = d.Opts(s.opts.DecodeOpts)
+	return sec, nil
+}
+
+// read returns the next record, and whether blank lines preceded it.
+func (s *SectionReader) read() (*bufferedRecord, bool, error) {
+	if s.next != nil {
+		rec := s.next
+		s.next = nil
+		return rec, false, nil
+	}
+	if s.d.err != nil {
+		return nil, false, s.d.err
+	}
+	fields, err := s.d.readRecord()
+	if err != nil {
+		s.d.err = err
+		return nil, false, err
+	}
+	start, _ := s.d.r.FieldPos(0)
+	gap := s.endLine > 0 && start > s.endLine+1
+	last := len(fields) - 1
+	end, _ := s.d.r.FieldPos(last)
+	s.endLine = end + strings.Count(fields[last], "\n")
+	return &bufferedRecord{fields, s.d.quoted, start}, gap, nil
+}
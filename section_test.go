@@ -0,0 +1,111 @@
+package csvstruct
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSectionReader(t *testing.T) {
+	s := `Account Summary
+Account,Balance
+123,10.50
+456,"2,000.00"
+Transactions
+Date,Amount,Memo
+2015-03-01,-5.00,"multi
+line"
+2015-03-02,7.25,coffee
+
+Date,Note
+2015-03-03,untitled section
+`
+	type account struct{ Account, Balance string }
+	type txn struct {
+		Date   string
+		Amount float64
+		Memo   string
+	}
+	type note struct{ Date, Note string }
+
+	sr := NewSectionReader(strings.NewReader(s), SectionOpts{
+		IsTitle: func(rec []string) bool { return len(rec) == 1 },
+	})
+
+	var titles []string
+	var accounts []account
+	var txns []txn
+	var notes []note
+	for i := 0; ; i++ {
+		sec, err := sr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		titles = append(titles, sec.Title)
+		var dst interface{}
+		switch i {
+		case 0:
+			dst = &accounts
+		case 1:
+			dst = &txns
+		default:
+			dst = &notes
+		}
+		if err := decodeAll(sec.Decoder, dst); err != nil {
+			t.Fatalf("section %d: %v", i, err)
+		}
+	}
+
+	if want := []string{"Account Summary", "Transactions", ""}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("got titles %q, want %q", titles, want)
+	}
+	if want := []account{{"123", "10.50"}, {"456", "2,000.00"}}; !reflect.DeepEqual(accounts, want) {
+		t.Errorf("got accounts %v, want %v", accounts, want)
+	}
+	if want := []txn{{"2015-03-01", -5, "multi\nline"}, {"2015-03-02", 7.25, "coffee"}}; !reflect.DeepEqual(txns, want) {
+		t.Errorf("got transactions %v, want %v", txns, want)
+	}
+	if want := []note{{"2015-03-03", "untitled section"}}; !reflect.DeepEqual(notes, want) {
+		t.Errorf("got notes %v, want %v", notes, want)
+	}
+}
+
+func TestSectionReader_QuotedEmpty(t *testing.T) {
+	s := "Name,Note\n\"\",\"bare\rCR\"\n,x\n\nName\n\"\"\n"
+	type row struct {
+		Name *string
+		Note string
+	}
+	sr := NewSectionReader(strings.NewReader(s), SectionOpts{
+		DecodeOpts: DecodeOpts{QuotedEmpty: true},
+	})
+	var got [][]row
+	for {
+		sec, err := sr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		var rows []row
+		if err := decodeAll(sec.Decoder, &rows); err != nil {
+			t.Fatalf("section %d: %v", len(got), err)
+		}
+		got = append(got, rows)
+	}
+	if len(got) != 2 || len(got[0]) != 2 || len(got[1]) != 1 {
+		t.Fatalf("got sections %v, want 2 sections of 2 and 1 rows", got)
+	}
+	if r := got[0][0]; r.Name == nil || *r.Name != "" || r.Note != "bare\rCR" {
+		t.Errorf("got first row %+v, want empty name and note %q", r, "bare\rCR")
+	}
+	if r := got[0][1]; r.Name != nil {
+		t.Errorf("got name %q for an unquoted empty cell, want nil", *r.Name)
+	}
+	if r := got[1][0]; r.Name == nil || *r.Name != "" {
+		t.Errorf("got name %v in second section, want empty", r.Name)
+	}
+}
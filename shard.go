@@ -0,0 +1,175 @@
+package csvstruct
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ShardOpts specifies options for an Encoder that splits its output across
+// multiple files.
+type ShardOpts struct {
+	MaxRowsPerFile  int   // data rows written to each file; 0 for no limit
+	MaxBytesPerFile int64 // approximate bytes written to each file; 0 for no limit
+
+	// Create opens the named file for writing. If nil, os.Create is used.
+	Create func(name string) (io.WriteCloser, error)
+
+	// OnClose, if set, is called with the name of each file after it is
+	// closed, so that it can be processed while encoding continues.
+	OnClose func(name string) error
+}
+
+type shardEncoder struct {
+	tmpl  string
+	sopts ShardOpts
+	opts  EncodeOpts
+	n     int            // number of files opened
+	name  string         // name of the current file
+	f     io.WriteCloser // current file
+	cw    *countWriter   // counts bytes written to f
+	e     *encoder       // encoder for the current file
+
+	// The header established by the first file, as the columns of the
+	// rows before DedupeHeaders or Schema rename them, and what later
+	// files need to write it as the first did.
+	hdr      []string
+	notes    [][]string // rows following the header
+	allowDup map[string]bool
+	rowType  reflect.Type
+	numeric  []bool // columns QuoteNonNumeric leaves unquoted
+
+	closed bool // Close has been called
+}
+
+// NewShardEncoder returns an Encoder that writes to a sequence of files. The
+// name of each file is produced by formatting nameTmpl with its 1-based
+// sequence number, e.g. "export-%03d.csv". A template without a formatting
+// verb is used as-is, which is useful when no limits are set.
+//
+// A new file is started once the current one holds MaxRowsPerFile data rows,
+// or once at least MaxBytesPerFile bytes have been written to it. The rows of
+// a slice passed to EncodeNext may be split across files.
+// Every file begins with the header row established by the first call to
+// EncodeNext. Close must be called to close the last file.
+func NewShardEncoder(nameTmpl string, opts ShardOpts) Encoder {
+	if opts.Create == nil {
+		opts.Create = func(name string) (io.WriteCloser, error) { return os.Create(name) }
+	}
+	return &shardEncoder{tmpl: nameTmpl, sopts: opts}
+}
+
+func (s *shardEncoder) Opts(opts EncodeOpts) Encoder {
+	s.opts = opts
+	if s.e != nil {
+		s.e.Opts(opts)
+	}
+	return s
+}
+
+func (s *shardEncoder) EncodeNext(v interface{}, overrides ...Option) error {
+	if s.closed {
+		return errClosed
+	}
+	// Encode the rows of a slice one at a time, so that the limits apply
+	// to each of them.
+	if rv := reflect.Indirect(reflect.ValueOf(v)); rv.Kind() == reflect.Slice {
+		for i := 0; i < rv.Len(); i++ {
+			if err := s.EncodeNext(rv.Index(i).Interface(), overrides...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if s.e != nil && s.full() {
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+	}
+	if s.e == nil {
+		if err := s.openFile(); err != nil {
+			return err
+		}
+	}
+	err := s.e.EncodeNext(v, overrides...)
+	if s.hdr == nil && s.e.hm != nil {
+		s.hdr, s.notes = s.e.names, s.e.notes
+		s.allowDup, s.rowType, s.numeric = s.e.allowDup, s.e.rowType, s.e.w.Numeric
+	}
+	return err
+}
+
+func (s *shardEncoder) EncodeNextContext(ctx context.Context, v interface{}, overrides ...Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.EncodeNext(v, overrides...)
+}
+
+func (s *shardEncoder) Close() error {
+	s.closed = true
+	if s.e == nil {
+		return nil
+	}
+	return s.closeFile()
+}
+
+// full reports whether the current file has reached its size limits.
+func (s *shardEncoder) full() bool {
+	return (s.sopts.MaxRowsPerFile > 0 && s.e.rows >= s.sopts.MaxRowsPerFile) ||
+		(s.sopts.MaxBytesPerFile > 0 && s.cw.n >= s.sopts.MaxBytesPerFile)
+}
+
+// openFile starts the next file, writing the established header, if any.
+func (s *shardEncoder) openFile() error {
+	s.n++
+	s.name = s.tmpl
+	if strings.Contains(s.tmpl, "%") {
+		s.name = fmt.Sprintf(s.tmpl, s.n)
+	}
+	f, err := s.sopts.Create(s.name)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	s.cw = &countWriter{w: f}
+	s.e = NewEncoder(s.cw).Opts(s.opts).(*encoder)
+	if s.hdr != nil {
+		s.e.notes, s.e.allowDup, s.e.rowType = s.notes, s.allowDup, s.rowType
+		if err := s.e.setHeader(s.hdr); err != nil {
+			return err
+		}
+		// The first file classified the columns by the values of its
+		// first row, which later files haven't seen.
+		s.e.w.Numeric = s.numeric
+	}
+	return nil
+}
+
+// closeFile finishes the current file.
+func (s *shardEncoder) closeFile() error {
+	err := s.e.Close()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.e, s.f, s.cw = nil, nil, nil
+	if err == nil && s.sopts.OnClose != nil {
+		err = s.sopts.OnClose(s.name)
+	}
+	return err
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,176 @@
+package csvstruct
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// memFiles collects files created by a sharding encoder in memory.
+type memFiles struct {
+	names []string
+	files map[string]*bytes.Buffer
+}
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func (m *memFiles) create(name string) (io.WriteCloser, error) {
+	if m.files == nil {
+		m.files = map[string]*bytes.Buffer{}
+	}
+	m.names = append(m.names, name)
+	m.files[name] = &bytes.Buffer{}
+	return nopCloser{m.files[name]}, nil
+}
+
+func TestShardEncoder_MaxRows(t *testing.T) {
+	var m memFiles
+	e := NewShardEncoder("part-%02d.csv", ShardOpts{MaxRowsPerFile: 2, Create: m.create})
+	for _, r := range []struct{ A, B string }{{"a", "b"}, {"c", "d"}, {"e", "f"}, {"g", "h"}, {"i", "j"}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := e.EncodeNext(struct{ A, B string }{"k", "l"}); err == nil {
+		t.Errorf("EncodeNext after Close: expected error")
+	}
+	if want := []string{"part-01.csv", "part-02.csv", "part-03.csv"}; !reflect.DeepEqual(m.names, want) {
+		t.Errorf("got files %v, want %v", m.names, want)
+	}
+	for name, want := range map[string]string{
+		"part-01.csv": "A,B\na,b\nc,d\n",
+		"part-02.csv": "A,B\ne,f\ng,h\n",
+		"part-03.csv": "A,B\ni,j\n",
+	} {
+		if got := m.files[name].String(); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Tests that the rows of a slice are split across files.
+func TestShardEncoder_Slice(t *testing.T) {
+	var m memFiles
+	e := NewShardEncoder("part-%d.csv", ShardOpts{MaxRowsPerFile: 2, Create: m.create})
+	rows := []struct{ A, B string }{{"a", "b"}, {"c", "d"}, {"e", "f"}}
+	if err := e.EncodeNext(rows); err != nil {
+		t.Errorf("EncodeNext(%v): %v", rows, err)
+	}
+	if err := e.EncodeNext(&rows); err != nil {
+		t.Errorf("EncodeNext(&%v): %v", rows, err)
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	for name, want := range map[string]string{
+		"part-1.csv": "A,B\na,b\nc,d\n",
+		"part-2.csv": "A,B\ne,f\na,b\n",
+		"part-3.csv": "A,B\nc,d\ne,f\n",
+	} {
+		if got := m.files[name].String(); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestShardEncoder_MaxBytes(t *testing.T) {
+	var m memFiles
+	var closed []string
+	e := NewShardEncoder("part-%d.csv", ShardOpts{
+		MaxBytesPerFile: 10,
+		Create:          m.create,
+		OnClose: func(name string) error {
+			closed = append(closed, name)
+			return nil
+		},
+	})
+	// The header and first row are 8 bytes, so the second row spills over
+	// the limit and the third starts a new file.
+	for _, r := range []struct{ A, B string }{{"a", "b"}, {"c", "d"}, {"e", "f"}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if want := []string{"part-1.csv"}; !reflect.DeepEqual(closed, want) {
+		t.Errorf("before Close: got closed %v, want %v", closed, want)
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if want := []string{"part-1.csv", "part-2.csv"}; !reflect.DeepEqual(closed, want) {
+		t.Errorf("got closed %v, want %v", closed, want)
+	}
+	for name, want := range map[string]string{
+		"part-1.csv": "A,B\na,b\nc,d\n",
+		"part-2.csv": "A,B\ne,f\n",
+	} {
+		if got := m.files[name].String(); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Tests that every file has the header, and schema hash, of the first.
+func TestShardEncoder_SchemaHash(t *testing.T) {
+	type row struct {
+		ID   int    `csv:"id"`
+		Name string `csv:"name,allowdup"`
+		Alt  string `csv:"name,allowdup"`
+	}
+	opts := EncodeOpts{SchemaHash: true}
+	var m memFiles
+	e := NewShardEncoder("part-%d.csv", ShardOpts{MaxRowsPerFile: 1, Create: m.create}).Opts(opts)
+	rows := []row{{1, "a", "b"}, {2, "c", "d"}, {3, "e", "f"}}
+	if err := e.EncodeNext(rows); err != nil {
+		t.Errorf("EncodeNext(%v): %v", rows, err)
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	hash, err := SchemaHash(row{})
+	if err != nil {
+		t.Fatalf("SchemaHash: %v", err)
+	}
+	if len(m.names) != len(rows) {
+		t.Fatalf("got files %v, want %d", m.names, len(rows))
+	}
+	for i, name := range m.names {
+		d := NewDecoder(m.files[name]).Opts(DecodeOpts{SchemaHash: hash})
+		var got row
+		if err := d.DecodeNext(&got); err != nil {
+			t.Errorf("%s: DecodeNext: %v", name, err)
+			continue
+		}
+		if got != rows[i] {
+			t.Errorf("%s: got %+v, want %+v", name, got, rows[i])
+		}
+	}
+}
+
+// Tests that later files quote the same columns as the first.
+func TestShardEncoder_QuoteNonNumeric(t *testing.T) {
+	var m memFiles
+	e := NewShardEncoder("part-%d.csv", ShardOpts{MaxRowsPerFile: 1, Create: m.create}).Opts(EncodeOpts{Quote: QuoteNonNumeric})
+	for _, r := range []map[string]interface{}{{"n": 1, "s": "a"}, {"n": 2, "s": "b"}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	for name, want := range map[string]string{
+		"part-1.csv": "\"n\",\"s\"\n1,\"a\"\n",
+		"part-2.csv": "\"n\",\"s\"\n2,\"b\"\n",
+	} {
+		if got := m.files[name].String(); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
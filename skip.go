@@ -0,0 +1,69 @@
+package csvstruct
+
+import "reflect"
+
+// Skip describes data that an Encoder or Decoder dropped without returning
+// an error. It is passed to the OnSkip option, if set.
+type Skip struct {
+	Row    int        // 1-based data row, or 0 if not specific to a row
+	Column string     // column, field or map key dropped, if any
+	Reason SkipReason // why it was dropped
+}
+
+// SkipReason explains why data was skipped.
+type SkipReason string
+
+const (
+	SkipUnmappedColumn SkipReason = "column has no matching field"         // reported once per struct type decoded
+	SkipUnmappedField  SkipReason = "field has no matching column"         // reported once per struct type
+	SkipUnmappedKey    SkipReason = "map key has no matching column"       // reported for each row
+	SkipShortRow       SkipReason = "row has no cell for column"           // reported for each missing cell
+	SkipRepeatedHeader SkipReason = "row repeats the header"               // see SkipRepeatedHeaders
+	SkipNilRow         SkipReason = "nil value"                            // DecodeNext(nil) or EncodeNext(nil)
+	SkipEmptyRow       SkipReason = "no fields match the header"           // nothing to encode, or see SkipEmptyRows
+	SkipDuplicateRow   SkipReason = "row duplicates an earlier row"        // see Dedupe
+	SkipInvalidCell    SkipReason = "cell can't be decoded"                // field tagged "onerror=zero" left zero
+	SkipInvalidRow     SkipReason = "row has a cell that can't be decoded" // field tagged "onerror=skiprow"
+)
+
+// skipper reports skipped data to an OnSkip callback.
+type skipper struct {
+	fn       func(Skip)
+	reported map[reflect.Type]bool // struct types whose mapping was reported
+}
+
+func (s *skipper) skip(row int, column string, reason SkipReason) {
+	if s.fn != nil {
+		s.fn(Skip{row, column, reason})
+	}
+}
+
+// mapping reports, once for each struct type t, the fields that have no
+// column in hm and the columns of header that have no field.
+func (s *skipper) mapping(t reflect.Type, fields []field, hm map[string]int, header []string) {
+	if s.fn == nil || s.reported[t] {
+		return
+	}
+	if s.reported == nil {
+		s.reported = map[reflect.Type]bool{}
+	}
+	s.reported[t] = true
+	names := map[string]bool{}
+	inline := false
+	for _, f := range fields {
+		if f.inline {
+			// Unmatched columns go to the inline map.
+			inline = true
+			continue
+		}
+		names[f.name] = true
+		if _, ok := hm[f.name]; !ok {
+			s.skip(0, f.name, SkipUnmappedField)
+		}
+	}
+	for _, h := range header {
+		if _, ok := hm[h]; ok && !names[h] && !inline {
+			s.skip(0, h, SkipUnmappedColumn)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package csvstruct
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOnSkip_Decode(t *testing.T) {
+	in := "A,B,C\na,b,c\nA,B,C\nd\n"
+	var got []Skip
+	d := NewDecoder(strings.NewReader(in)).Opts(DecodeOpts{
+		FieldsPerRecord:     -1,
+		SkipRepeatedHeaders: true,
+		OnSkip:              func(s Skip) { got = append(got, s) },
+	})
+	for {
+		var row struct{ A, B, D string }
+		if err := d.DecodeNext(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+	}
+	want := []Skip{
+		{0, "D", SkipUnmappedField},
+		{0, "C", SkipUnmappedColumn},
+		{0, "", SkipRepeatedHeader},
+		{2, "B", SkipShortRow},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOnSkip_Encode(t *testing.T) {
+	var got []Skip
+	e := NewEncoder(&bytes.Buffer{}).Opts(EncodeOpts{
+		Dedupe: true,
+		OnSkip: func(s Skip) { got = append(got, s) },
+	})
+	for _, v := range []interface{}{
+		struct{ A, B string }{"a", "b"},
+		nil,
+		struct{ A, C string }{"a", "c"},
+		struct{ C string }{"c"},
+		struct{ A, B string }{"a", "b"},
+	} {
+		if err := e.EncodeNext(v); err != nil {
+			t.Errorf("EncodeNext(%v): %v", v, err)
+		}
+	}
+	want := []Skip{
+		{2, "", SkipNilRow},
+		{0, "C", SkipUnmappedField},
+		{0, "C", SkipUnmappedField},
+		{3, "", SkipEmptyRow},
+		{3, "", SkipDuplicateRow},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
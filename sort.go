@@ -0,0 +1,190 @@
+package csvstruct
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// sorter buffers rows and produces them in sorted order, spilling sorted
+// runs to temporary files when more than max rows are buffered.
+//
+// Runs hold each row as its number of cells followed by each cell's length
+// and bytes, all lengths as uvarints, so that rows read back exactly as
+// written. CSV would lose empty one-cell rows and carriage returns in cells.
+type sorter struct {
+	cols []int // indexes of the columns to sort by
+	max  int
+	rows [][]string
+	runs []*os.File
+}
+
+func newSorter(hm map[string]int, by []string, max int) (*sorter, error) {
+	cols, err := columnIndexes(hm, by)
+	if err != nil {
+		return nil, fmt.Errorf("error sorting: %v", err)
+	}
+	return &sorter{cols: cols, max: max}, nil
+}
+
+func (s *sorter) add(row []string) error {
+	s.rows = append(s.rows, append([]string(nil), row...))
+	if s.max > 0 && len(s.rows) >= s.max {
+		return s.spill()
+	}
+	return nil
+}
+
+// less reports whether row a sorts before row b.
+func (s *sorter) less(a, b []string) bool {
+	for _, c := range s.cols {
+		var x, y string
+		if c < len(a) {
+			x = a[c]
+		}
+		if c < len(b) {
+			y = b[c]
+		}
+		if x == y {
+			continue
+		}
+		fx, okx := sortNumber(x)
+		fy, oky := sortNumber(y)
+		switch {
+		case okx && oky && fx != fy:
+			return fx < fy
+		case okx != oky:
+			// Numbers sort before other cells, so that the order is
+			// transitive when a column mixes both.
+			return okx
+		}
+		return x < y
+	}
+	return false
+}
+
+// sortNumber returns the value of cell, and whether it is a number. NaN is
+// not, since it compares unequal to every number.
+func sortNumber(cell string) (float64, bool) {
+	f, err := strconv.ParseFloat(cell, 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+func (s *sorter) sortRows() {
+	sort.SliceStable(s.rows, func(i, j int) bool { return s.less(s.rows[i], s.rows[j]) })
+}
+
+// spill writes the buffered rows, sorted, to a new temporary file.
+func (s *sorter) spill() error {
+	f, err := os.CreateTemp("", "csvstruct-sort-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+	s.sortRows()
+	w := bufio.NewWriter(f)
+	var n [binary.MaxVarintLen64]byte
+	for _, row := range s.rows {
+		w.Write(n[:binary.PutUvarint(n[:], uint64(len(row)))])
+		for _, cell := range row {
+			w.Write(n[:binary.PutUvarint(n[:], uint64(len(cell)))])
+			w.WriteString(cell)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	s.rows = s.rows[:0]
+	return nil
+}
+
+// flush passes all rows to write in sorted order and removes any temporary
+// files.
+func (s *sorter) flush(write func([]string) error) error {
+	defer s.cleanup()
+	if len(s.runs) == 0 {
+		s.sortRows()
+		for _, row := range s.rows {
+			if err := write(row); err != nil {
+				return err
+			}
+		}
+		s.rows = nil
+		return nil
+	}
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	// Merge the runs, taking the least head row each time. Ties go to the
+	// earliest run, which keeps the sort stable.
+	readers := make([]*bufio.Reader, len(s.runs))
+	heads := make([][]string, len(s.runs))
+	for i, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		readers[i] = bufio.NewReader(f)
+		if err := s.advance(readers, heads, i); err != nil {
+			return err
+		}
+	}
+	for {
+		min := -1
+		for i, h := range heads {
+			if h != nil && (min < 0 || s.less(h, heads[min])) {
+				min = i
+			}
+		}
+		if min < 0 {
+			return nil
+		}
+		if err := write(heads[min]); err != nil {
+			return err
+		}
+		if err := s.advance(readers, heads, min); err != nil {
+			return err
+		}
+	}
+}
+
+// advance reads the next row of run i into heads[i], or nil at its end.
+func (s *sorter) advance(readers []*bufio.Reader, heads [][]string, i int) error {
+	r := readers[i]
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		heads[i] = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+	row := make([]string, n)
+	for j := range row {
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, l)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		row[j] = string(b)
+	}
+	heads[i] = row
+	return nil
+}
+
+func (s *sorter) cleanup() {
+	for _, f := range s.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	s.runs = nil
+}
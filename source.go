@@ -0,0 +1,78 @@
+package csvstruct
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// sniffReader inspects the start of r on the first call to Read. Gzip input
+// is transparently decompressed. A byte order mark is stripped, and the rest
+// of the stream is transcoded according to the mark; streams without a mark
+// are transcoded from cs.
+type sniffReader struct {
+	r       io.Reader
+	cs      Charset
+	sniffed bool
+	skipped int64 // length of the byte order mark, if any
+	size    int   // size of the read buffer, or zero for the default
+}
+
+func newSniffReader(r io.Reader, cs Charset, size int) *sniffReader {
+	return &sniffReader{r: r, cs: cs, size: size}
+}
+
+// newReader returns a buffered Reader reading from r, or r itself if it is a
+// *bufio.Reader with a buffer that is large enough.
+func (s *sniffReader) newReader(r io.Reader) *bufio.Reader {
+	if s.size <= 0 {
+		return bufio.NewReader(r)
+	}
+	return bufio.NewReaderSize(r, s.size)
+}
+
+func (s *sniffReader) Read(p []byte) (int, error) {
+	if !s.sniffed {
+		s.sniffed = true
+		br := s.newReader(s.r)
+		if b, _ := br.Peek(4); bytes.HasPrefix(b, gzipMagic) {
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				s.r = errReader{err}
+				return 0, err
+			}
+			br = s.newReader(zr)
+		} else if bytes.Equal(b, zstdMagic) {
+			s.r = errReader{errZstd}
+			return 0, errZstd
+		}
+		cs := s.cs
+		b, _ := br.Peek(3)
+		switch {
+		case len(b) >= 3 && b[0] == 0xef && b[1] == 0xbb && b[2] == 0xbf:
+			br.Discard(3)
+			s.skipped, cs = 3, UTF8
+		case len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe:
+			br.Discard(2)
+			s.skipped, cs = 2, UTF16LE
+		case len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff:
+			br.Discard(2)
+			s.skipped, cs = 2, UTF16BE
+		}
+		s.r = decodeCharset(br, cs)
+	}
+	return s.r.Read(p)
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	errZstd   = errors.New("zstd-compressed input is not supported")
+)
+
+// errReader is a Reader that always returns err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,32 @@
+package csvstruct
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+func TestDecode_Gzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("\xef\xbb\xbfA,B\na,b\n"))
+	zw.Close()
+
+	var r struct{ A, B string }
+	d := NewDecoder(&buf)
+	if err := d.DecodeNext(&r); err != nil {
+		t.Fatalf("DecodeNext: %v", err)
+	}
+	if r.A != "a" || r.B != "b" {
+		t.Errorf("DecodeNext: got %v", r)
+	}
+	if !isDone(d) {
+		t.Errorf("decoder unexpectedly not done")
+	}
+
+	zstd := "\x28\xb5\x2f\xfd..."
+	if err := NewDecoder(strings.NewReader(zstd)).DecodeNext(&r); err == nil {
+		t.Errorf("DecodeNext(%q): expected error", zstd)
+	}
+}
@@ -0,0 +1,169 @@
+package csvstruct
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strconv"
+	"sync"
+)
+
+// Stats collects statistics about the columns read by a Decoder. To collect
+// them, set DecodeOpts.Stats to a new Stats. A Stats may be shared by
+// several Decoders, and Report may be called while they are decoding.
+type Stats struct {
+	mu    sync.Mutex
+	cols  map[string]*columnStats
+	order []string
+}
+
+// ColumnStats reports the statistics collected for a column.
+type ColumnStats struct {
+	Name  string
+	Count int // cells read
+	Nulls int // empty cells
+
+	// Min and Max are the least and greatest non-empty cells. They are
+	// compared as numbers if every non-empty cell is a number, and as
+	// strings otherwise.
+	Min, Max string
+
+	Distinct      uint64 // estimated number of distinct non-empty cells
+	ParseFailures int    // cells that failed to decode into their target
+}
+
+type columnStats struct {
+	ColumnStats
+	numeric        bool // every non-empty cell so far is a number
+	minNum, maxNum string
+	minF, maxF     float64
+	minStr, maxStr string
+	registers      [hllRegisters]uint8
+}
+
+// The distinct count is estimated with a HyperLogLog sketch, which has a
+// standard error of about 1.04/sqrt(hllRegisters), or 3%.
+const (
+	hllPrecision = 10
+	hllRegisters = 1 << hllPrecision
+)
+
+// Report returns the statistics collected for each column, in the order the
+// columns were first seen.
+func (s *Stats) Report() []ColumnStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rep := make([]ColumnStats, len(s.order))
+	for i, n := range s.order {
+		c := s.cols[n]
+		rep[i] = c.ColumnStats
+		if c.numeric {
+			rep[i].Min, rep[i].Max = c.minNum, c.maxNum
+		} else {
+			rep[i].Min, rep[i].Max = c.minStr, c.maxStr
+		}
+		rep[i].Distinct = c.estimate()
+	}
+	return rep
+}
+
+func (s *Stats) column(name string) *columnStats {
+	if s.cols == nil {
+		s.cols = map[string]*columnStats{}
+	}
+	c, ok := s.cols[name]
+	if !ok {
+		c = &columnStats{ColumnStats: ColumnStats{Name: name}, numeric: true}
+		s.cols[name] = c
+		s.order = append(s.order, name)
+	}
+	return c
+}
+
+// observe records the cells of a data row.
+func (s *Stats) observe(header []string, hm map[string]int, line []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, h := range header {
+		if j, ok := hm[h]; !ok || j != i {
+			// Not a decoded column.
+			continue
+		}
+		c := s.column(h)
+		c.Count++
+		if i >= len(line) || line[i] == "" {
+			c.Nulls++
+			continue
+		}
+		c.add(line[i])
+	}
+}
+
+// fail records a cell of the named column that failed to decode.
+func (s *Stats) fail(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.column(name).ParseFailures++
+}
+
+func (c *columnStats) add(v string) {
+	first := c.Count-c.Nulls == 1
+	if first || v < c.minStr {
+		c.minStr = v
+	}
+	if first || v > c.maxStr {
+		c.maxStr = v
+	}
+	if c.numeric {
+		if f, err := strconv.ParseFloat(v, 64); err != nil {
+			c.numeric = false
+		} else {
+			if first || f < c.minF {
+				c.minF, c.minNum = f, v
+			}
+			if first || f > c.maxF {
+				c.maxF, c.maxNum = f, v
+			}
+		}
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(v))
+	x := mix(h.Sum64())
+	// The first bits choose a register, which keeps the longest run of
+	// leading zeros seen in the remaining bits.
+	r := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > c.registers[r] {
+		c.registers[r] = rank
+	}
+}
+
+// mix scrambles the bits of an FNV hash, whose high bits are poorly
+// distributed for short inputs, using the MurmurHash3 finalizer.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// estimate returns the estimated number of distinct values added.
+func (c *columnStats) estimate() uint64 {
+	const m = float64(hllRegisters)
+	sum, zeros := 0.0, 0
+	for _, r := range c.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// Use linear counting for small cardinalities.
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
@@ -0,0 +1,70 @@
+package csvstruct
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	in := `name,age,zip
+alice,30,02134
+bob,,10001
+carol,x,
+dave,9,94110
+`
+	var s Stats
+	d := NewDecoder(strings.NewReader(in)).Opts(DecodeOpts{Stats: &s})
+	failed := 0
+	for {
+		var row struct {
+			Name string `csv:"name"`
+			Age  int    `csv:"age"`
+		}
+		if err := d.DecodeNext(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			failed++
+		}
+	}
+	// Both the empty and non-numeric ages fail to decode.
+	if failed != 2 {
+		t.Errorf("got %d decoding errors, want 2", failed)
+	}
+	want := []ColumnStats{
+		{Name: "name", Count: 4, Min: "alice", Max: "dave", Distinct: 4},
+		{Name: "age", Count: 4, Nulls: 1, Min: "30", Max: "x", Distinct: 3, ParseFailures: 2},
+		{Name: "zip", Count: 4, Nulls: 1, Min: "02134", Max: "94110", Distinct: 3},
+	}
+	got := s.Report()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestStats_Numeric(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("n\n")
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(&b, "%d\n", i%1000)
+	}
+	var s Stats
+	d := NewDecoder(strings.NewReader(b.String())).Opts(DecodeOpts{Stats: &s})
+	for {
+		m := map[string]string{}
+		if err := d.DecodeNext(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+	}
+	got := s.Report()[0]
+	// Numbers are compared numerically, not as strings.
+	if got.Min != "0" || got.Max != "999" {
+		t.Errorf("got min %q max %q, want 0 and 999", got.Min, got.Max)
+	}
+	if got.Distinct < 900 || got.Distinct > 1100 {
+		t.Errorf("got distinct estimate %d, want about 1000", got.Distinct)
+	}
+}
@@ -0,0 +1,267 @@
+// Package tagcheck reports mistakes in the csv struct tags read by
+// csvstruct, which would otherwise only show up when rows are encoded or
+// decoded: duplicate column names, tags on fields csvstruct skips,
+// malformed tag options and field types that can't be decoded.
+//
+// Only struct types with at least one csv tag are checked, since others may
+// not be meant for csvstruct. The checks are syntactic, so named types whose
+// underlying type is unsupported aren't reported. The csvvet command runs
+// them with go vet.
+package tagcheck
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a mistake found in a struct type.
+type Diagnostic struct {
+	Pos     token.Pos
+	Message string
+}
+
+// flags are the tag options that take no value.
+var flags = map[string]bool{
+	"omitempty": true,
+	"mask":      true,
+	"braced":    true,
+	"upper":     true,
+	"allowdup":  true,
+	"inline":    true,
+}
+
+// Check returns the mistakes in the csv tags of the struct types declared in
+// files, in the order of their positions.
+func Check(files []*ast.File) []Diagnostic {
+	var diags []Diagnostic
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			if st, ok := n.(*ast.StructType); ok {
+				diags = append(diags, checkStruct(st)...)
+			}
+			return true
+		})
+	}
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Pos < diags[j].Pos })
+	return diags
+}
+
+// column is a struct field mapped to a column.
+type column struct {
+	field    string
+	allowdup bool
+}
+
+func checkStruct(st *ast.StructType) []Diagnostic {
+	tagged := false
+	for _, f := range st.Fields.List {
+		if _, ok := csvTag(f); ok {
+			tagged = true
+			break
+		}
+	}
+	if !tagged {
+		return nil
+	}
+	var diags []Diagnostic
+	report := func(pos token.Pos, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{pos, fmt.Sprintf(format, args...)})
+	}
+	columns := map[string]column{}
+	for _, f := range st.Fields.List {
+		tag, hasTag := csvTag(f)
+		if len(f.Names) == 0 {
+			if hasTag && tag != "-" {
+				report(f.Pos(), "csv tag on embedded field %s is ignored", typeString(f.Type))
+			}
+			continue
+		}
+		for _, name := range f.Names {
+			if !name.IsExported() {
+				if hasTag && tag != "-" {
+					report(name.Pos(), "csv tag on unexported field %s is ignored", name.Name)
+				}
+				continue
+			}
+			if tag == "-" {
+				continue
+			}
+			col, opts := name.Name, ""
+			if hasTag {
+				col, opts = tag, ""
+				if i := strings.Index(tag, ","); i >= 0 {
+					col, opts = tag[:i], tag[i+1:]
+				}
+				if col == "" {
+					col = name.Name
+				}
+			}
+			inline, allowdup := false, false
+			for _, msg := range checkOptions(opts, &inline, &allowdup) {
+				report(f.Tag.Pos(), "field %s: %s", name.Name, msg)
+			}
+			if inline {
+				if !isStringMap(f.Type) {
+					report(f.Tag.Pos(), "field %s: inline requires a map with string keys", name.Name)
+				}
+				continue
+			}
+			if msg := unsupported(f.Type); msg != "" {
+				report(f.Type.Pos(), "field %s: %s", name.Name, msg)
+			}
+			if prev, ok := columns[col]; ok && !(prev.allowdup && allowdup) {
+				report(name.Pos(), "field %s: duplicate csv column %q, also used by %s", name.Name, col, prev.field)
+				continue
+			}
+			columns[col] = column{name.Name, allowdup}
+		}
+	}
+	return diags
+}
+
+// csvTag returns the csv tag of the field f, and whether it has one.
+func csvTag(f *ast.Field) (string, bool) {
+	if f.Tag == nil {
+		return "", false
+	}
+	s, err := strconv.Unquote(f.Tag.Value)
+	if err != nil {
+		return "", false
+	}
+	return reflect.StructTag(s).Lookup("csv")
+}
+
+// checkOptions returns the problems with the options of a csv tag, after the
+// column name, noting whether they include inline and allowdup.
+func checkOptions(opts string, inline, allowdup *bool) []string {
+	if opts == "" {
+		return nil
+	}
+	var msgs []string
+	if i := strings.Index(","+opts, ",template="); i >= 0 {
+		// Templates may contain commas, so they take the rest of the tag.
+		if opts[i+len("template="):] == "" {
+			msgs = append(msgs, "empty template")
+		}
+		if opts = strings.TrimSuffix(opts[:i], ","); opts == "" {
+			return msgs
+		}
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		switch {
+		case flags[opt]:
+			*inline = *inline || opt == "inline"
+			*allowdup = *allowdup || opt == "allowdup"
+		case strings.HasPrefix(opt, "onerror="):
+			switch v := opt[len("onerror="):]; v {
+			case "zero", "skiprow", "fail":
+			default:
+				msgs = append(msgs, fmt.Sprintf("unknown onerror value %q", v))
+			}
+		case strings.HasPrefix(opt, "unit="), strings.HasPrefix(opt, "lookup="):
+			if k, v, _ := strings.Cut(opt, "="); v == "" {
+				msgs = append(msgs, fmt.Sprintf("empty %s", k))
+			}
+		case strings.HasPrefix(opt, "order="):
+			if _, err := strconv.Atoi(opt[len("order="):]); err != nil {
+				msgs = append(msgs, fmt.Sprintf("order %q is not an integer", opt[len("order="):]))
+			}
+		case strings.HasPrefix(opt, "desc="):
+		case opt == "":
+			msgs = append(msgs, "empty option")
+		default:
+			msgs = append(msgs, fmt.Sprintf("unknown option %q", opt))
+		}
+	}
+	return msgs
+}
+
+// isStringMap reports whether the type expression e is a map with string
+// keys.
+func isStringMap(e ast.Expr) bool {
+	m, ok := e.(*ast.MapType)
+	if !ok {
+		return false
+	}
+	id, ok := m.Key.(*ast.Ident)
+	return ok && id.Name == "string"
+}
+
+// unsupported describes why values of the type expression e can't be
+// decoded from cells, or returns "" if they may be.
+func unsupported(e ast.Expr) string {
+	switch t := e.(type) {
+	case *ast.StarExpr:
+		if _, ok := t.X.(*ast.StarExpr); ok {
+			return fmt.Sprintf("unsupported type %s", typeString(e))
+		}
+		return unsupported(t.X)
+	case *ast.ParenExpr:
+		return unsupported(t.X)
+	case *ast.ChanType, *ast.FuncType, *ast.MapType, *ast.StructType:
+		return fmt.Sprintf("unsupported type %s", typeString(e))
+	case *ast.ArrayType:
+		if t.Len == nil {
+			// Only a decoder registered for the slice type itself
+			// could decode it, which is rare enough to report.
+			return fmt.Sprintf("unsupported slice type %s", typeString(e))
+		}
+		if _, ok := t.Elt.(*ast.ArrayType); ok {
+			return fmt.Sprintf("unsupported nested array type %s", typeString(e))
+		}
+		return unsupported(t.Elt)
+	case *ast.Ident:
+		switch t.Name {
+		case "float32", "complex64", "complex128", "uintptr":
+			return fmt.Sprintf("unsupported type %s", t.Name)
+		}
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok && x.Name == "unsafe" && t.Sel.Name == "Pointer" {
+			return "unsupported type unsafe.Pointer"
+		}
+	}
+	return ""
+}
+
+// typeString returns the source of the type expression e, abbreviating function
+// and struct types.
+func typeString(e ast.Expr) string {
+	switch t := e.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return "*" + typeString(t.X)
+	case *ast.ParenExpr:
+		return "(" + typeString(t.X) + ")"
+	case *ast.SelectorExpr:
+		return typeString(t.X) + "." + t.Sel.Name
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return "[]" + typeString(t.Elt)
+		}
+		if l, ok := t.Len.(*ast.BasicLit); ok {
+			return "[" + l.Value + "]" + typeString(t.Elt)
+		}
+		return "[...]" + typeString(t.Elt)
+	case *ast.MapType:
+		return "map[" + typeString(t.Key) + "]" + typeString(t.Value)
+	case *ast.ChanType:
+		return "chan " + typeString(t.Value)
+	case *ast.FuncType:
+		return "func(...)"
+	case *ast.StructType:
+		return "struct{...}"
+	case *ast.InterfaceType:
+		return "interface{...}"
+	case *ast.IndexExpr:
+		return typeString(t.X) + "[...]"
+	case *ast.IndexListExpr:
+		return typeString(t.X) + "[...]"
+	}
+	return "?"
+}
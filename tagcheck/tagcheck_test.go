@@ -0,0 +1,73 @@
+package tagcheck
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+const src = `package p
+
+import "unsafe"
+
+type Untagged struct {
+	A, B chan int
+}
+
+type Row struct {
+	ID      int               ` + "`csv:\"id\"`" + `
+	Other   int               ` + "`csv:\"id\"`" + `
+	Dup1    string            ` + "`csv:\"dup,allowdup\"`" + `
+	Dup2    string            ` + "`csv:\"dup,allowdup\"`" + `
+	hidden  string            ` + "`csv:\"hidden\"`" + `
+	skipped string            ` + "`csv:\"-\"`" + `
+	Bad     int               ` + "`csv:\"bad,omitempy,onerror=panic,unit=,order=x\"`" + `
+	Tmpl    string            ` + "`csv:\"tmpl,upper,order=-2,template={{.A}}, {{.B}}\"`" + `
+	Extra   map[string]string ` + "`csv:\",inline\"`" + `
+	NotMap  []string          ` + "`csv:\"notmap,inline\"`" + `
+	C       complex128
+	F       func()
+	M       map[string]int
+	P       **int
+	U       unsafe.Pointer
+	Grid    [2][2]int
+	Nums    []int
+	Ratio   float32
+	Ok      *[4]int
+	Row
+}
+`
+
+func TestCheck(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range Check([]*ast.File{f}) {
+		got = append(got, fset.Position(d.Pos).String()+": "+d.Message)
+	}
+	want := []string{
+		`p.go:11:2: field Other: duplicate csv column "id", also used by ID`,
+		`p.go:14:2: csv tag on unexported field hidden is ignored`,
+		`p.go:16:28: field Bad: unknown option "omitempy"`,
+		`p.go:16:28: field Bad: unknown onerror value "panic"`,
+		`p.go:16:28: field Bad: empty unit`,
+		`p.go:16:28: field Bad: order "x" is not an integer`,
+		`p.go:19:28: field NotMap: inline requires a map with string keys`,
+		`p.go:20:10: field C: unsupported type complex128`,
+		`p.go:21:10: field F: unsupported type func(...)`,
+		`p.go:22:10: field M: unsupported type map[string]int`,
+		`p.go:23:10: field P: unsupported type **int`,
+		`p.go:24:10: field U: unsupported type unsafe.Pointer`,
+		`p.go:25:10: field Grid: unsupported nested array type [2][2]int`,
+		`p.go:26:10: field Nums: unsupported slice type []int`,
+		`p.go:27:10: field Ratio: unsupported type float32`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
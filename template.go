@@ -0,0 +1,65 @@
+package csvstruct
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+var (
+	templatesMu sync.RWMutex
+	templates   = map[string]*template.Template{}
+	// parsed caches the templates given inline in struct tags, by text.
+	parsed = map[string]*template.Template{}
+)
+
+// RegisterTemplate registers a template by name, for struct fields tagged
+// with it, e.g. `csv:"name,template=fullname"`. See cellTemplate.
+func RegisterTemplate(name string, t *template.Template) {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	templates[name] = t
+}
+
+// cellTemplate returns the template given by a field's "template=" tag
+// option: the name of a template registered by RegisterTemplate or, if it
+// contains an action, like "{{.Last}}, {{.First}}", the template's text.
+// Encoders execute it with the whole row struct as its data, and write the
+// output as the field's cell. Decoders read the cell as usual.
+func cellTemplate(text string) (*template.Template, error) {
+	templatesMu.RLock()
+	t, ok := templates[text]
+	if !ok {
+		t, ok = parsed[text]
+	}
+	templatesMu.RUnlock()
+	if ok {
+		return t, nil
+	}
+	if !strings.Contains(text, "{{") {
+		return nil, fmt.Errorf("no template named %q", text)
+	}
+	t, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	parsed[text] = t
+	return t, nil
+}
+
+// execute formats the row value rv with the template given by text.
+func execute(text string, rv reflect.Value) (string, error) {
+	t, err := cellTemplate(text)
+	if err != nil {
+		return "", fmt.Errorf("error encoding: %v", err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, rv.Interface()); err != nil {
+		return "", fmt.Errorf("error encoding: %v", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,41 @@
+package csvstruct
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestEncode_Template(t *testing.T) {
+	RegisterTemplate("initials", template.Must(template.New("").Parse(`{{slice .First 0 1}}{{slice .Last 0 1}}`)))
+	type person struct {
+		First    string `csv:"-"`
+		Last     string `csv:"-"`
+		Name     string `csv:"name,template={{.Last}}, {{.First}}"`
+		Initials string `csv:"initials,template=initials"`
+		Age      int
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.EncodeNext(person{First: "Ada", Last: "Lovelace", Age: 36}); err != nil {
+		t.Fatalf("EncodeNext: %v", err)
+	}
+	want := "name,initials,Age\n\"Lovelace, Ada\",AL,36\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	type bad struct {
+		A string `csv:"a,template=nosuch"`
+	}
+	if err := NewEncoder(&buf).EncodeNext(bad{}); err == nil || !strings.Contains(err.Error(), `no template named "nosuch"`) {
+		t.Errorf("EncodeNext(unregistered template): got %v", err)
+	}
+	type missing struct {
+		A string `csv:"a,template={{.Nope}}"`
+	}
+	if err := NewEncoder(&buf).EncodeNext(missing{}); err == nil {
+		t.Errorf("EncodeNext(template with unknown field): expected error")
+	}
+}
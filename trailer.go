@@ -0,0 +1,118 @@
+package csvstruct
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strconv"
+)
+
+// trailerTag is the first field of the trailer record.
+const trailerTag = "#TRAILER"
+
+// trailer counts and checksums data rows for the trailer record.
+type trailer struct {
+	rows int
+	crc  hash.Hash32
+	seen bool // the trailer record has been read
+}
+
+func newTrailer() *trailer {
+	return &trailer{crc: crc32.NewIEEE()}
+}
+
+// add counts row and adds its cells to the checksum.
+func (t *trailer) add(row []string) {
+	t.rows++
+	for _, c := range row {
+		t.crc.Write([]byte(c))
+		t.crc.Write([]byte{0x1f})
+	}
+	t.crc.Write([]byte{0x1e})
+}
+
+// record returns the trailer record.
+func (t *trailer) record() []string {
+	return []string{trailerTag, strconv.Itoa(t.rows), fmt.Sprintf("%08x", t.crc.Sum32())}
+}
+
+// TrailerError is returned by a Decoder with VerifyTrailer set when the
+// trailer record doesn't match the rows read, or is missing, as happens when
+// a transfer is truncated.
+type TrailerError struct {
+	Missing  bool   // no trailer record was found
+	Rows     int    // number of data rows read
+	WantRows int    // number of data rows in the trailer
+	Sum      uint32 // checksum of the data rows read
+	WantSum  uint32 // checksum in the trailer
+}
+
+func (e *TrailerError) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("missing trailer record after %d rows", e.Rows)
+	}
+	return fmt.Sprintf("trailer mismatch: read %d rows with checksum %08x, trailer has %d rows with checksum %08x",
+		e.Rows, e.Sum, e.WantRows, e.WantSum)
+}
+
+// isTrailer reports whether the record read by csv.Reader is a trailer
+// record. Since it usually has fewer fields than the header, an error
+// counting its fields is ignored.
+func isTrailer(rec []string, err error) bool {
+	var pe *csv.ParseError
+	if err != nil && !(errors.As(err, &pe) && pe.Err == csv.ErrFieldCount) {
+		return false
+	}
+	return len(rec) > 0 && rec[0] == trailerTag
+}
+
+// verify checks the trailer record rec against the rows read.
+func (t *trailer) verify(rec []string) error {
+	if len(rec) < 3 {
+		return fmt.Errorf("malformed trailer record %q", rec)
+	}
+	rows, err := strconv.Atoi(rec[1])
+	if err != nil {
+		return fmt.Errorf("malformed trailer record %q: %v", rec, err)
+	}
+	sum, err := strconv.ParseUint(rec[2], 16, 32)
+	if err != nil {
+		return fmt.Errorf("malformed trailer record %q: %v", rec, err)
+	}
+	t.seen = true
+	if rows != t.rows || uint32(sum) != t.crc.Sum32() {
+		return &TrailerError{Rows: t.rows, WantRows: rows, Sum: t.crc.Sum32(), WantSum: uint32(sum)}
+	}
+	return nil
+}
+
+// readTrailer handles the end of the data rows of the current input when
+// VerifyTrailer is set: a trailer record, which is verified and must be the
+// last record, or the end of the input, which must follow one.
+func (d *decoder) readTrailer(rec []string, err error) ([]string, error) {
+	if d.trail == nil {
+		d.trail = newTrailer()
+	}
+	if d.trail.seen {
+		return rec, err
+	}
+	if isTrailer(rec, err) {
+		if err := d.trail.verify(rec); err != nil {
+			return nil, err
+		}
+		if rec, err := d.readRecord(); err != io.EOF {
+			if err == nil {
+				err = fmt.Errorf("data after trailer record: %q", rec)
+			}
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err == io.EOF {
+		return nil, &TrailerError{Missing: true, Rows: d.trail.rows}
+	}
+	return rec, err
+}
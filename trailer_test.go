@@ -0,0 +1,94 @@
+package csvstruct
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEncode_Trailer(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{Trailer: true, Newlines: EscapeNewlines})
+	for _, r := range []struct{ A, B string }{{"a", "b"}, {"c", "d\ne"}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	// Cells are checksummed as they are written.
+	sum := crc32.ChecksumIEEE([]byte("a\x1fb\x1f\x1ec\x1fd\\ne\x1f\x1e"))
+	want := fmt.Sprintf("A,B\na,b\nc,d\\ne\n#TRAILER,2,%08x\n", sum)
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Closing again doesn't write another trailer.
+	if err := e.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("after second Close: got %q, want %q", got, want)
+	}
+}
+
+func TestDecode_VerifyTrailer(t *testing.T) {
+	type row struct{ A, B string }
+	var buf bytes.Buffer
+	e := NewEncoder(&buf).Opts(EncodeOpts{Trailer: true})
+	for _, r := range []row{{"a", "b"}, {"c", "d"}, {"e", "f"}} {
+		if err := e.EncodeNext(r); err != nil {
+			t.Errorf("EncodeNext(%v): %v", r, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	good := buf.String()
+	trailer := good[strings.Index(good, "#TRAILER"):]
+
+	decodeAll := func(in string) (int, error) {
+		d := NewDecoder(strings.NewReader(in)).Opts(DecodeOpts{VerifyTrailer: true})
+		n := 0
+		for {
+			var r row
+			if err := d.DecodeNext(&r); err == io.EOF {
+				return n, nil
+			} else if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	if n, err := decodeAll(good); err != nil || n != 3 {
+		t.Errorf("decoding %q: got %d rows, %v", good, n, err)
+	}
+
+	for _, c := range []struct {
+		in      string
+		missing bool
+	}{
+		{strings.Replace(good, trailer, "", 1), true},            // truncated
+		{strings.Replace(good, "e,f\n", "", 1), false},           // row lost
+		{strings.Replace(good, "c,d", "c,x", 1), false},          // row changed
+		{strings.Replace(good, "a,b\n", "a,b\na,b\n", 1), false}, // row repeated
+	} {
+		_, err := decodeAll(c.in)
+		te, ok := err.(*TrailerError)
+		if !ok {
+			t.Errorf("decoding %q: got %v, want *TrailerError", c.in, err)
+			continue
+		}
+		if te.Missing != c.missing {
+			t.Errorf("decoding %q: got %+v, want Missing %t", c.in, te, c.missing)
+		}
+	}
+
+	if _, err := decodeAll(good + "g,h\n"); err == nil || !strings.Contains(err.Error(), "after trailer") {
+		t.Errorf("decoding with data after the trailer: got %v", err)
+	}
+}
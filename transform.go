@@ -0,0 +1,39 @@
+package csvstruct
+
+import "io"
+
+// TransformOpts specifies options for Transform.
+type TransformOpts struct {
+	Decode DecodeOpts // options for reading the input
+	Encode EncodeOpts // options for writing the output
+}
+
+// Transform decodes each row of r into a T, passes it to fn, and encodes the
+// row fn returns to w.
+//
+// fn may modify and return its argument, or return a different row. Rows for
+// which fn returns false, or a nil row, are dropped. If fn returns an error,
+// Transform stops and returns it.
+func Transform[T any](r io.Reader, w io.Writer, fn func(*T) (*T, bool, error), opts TransformOpts) error {
+	d := NewDecoder(r).Opts(opts.Decode)
+	e := NewEncoder(w).Opts(opts.Encode)
+	for {
+		var row T
+		if err := d.DecodeNext(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		out, keep, err := fn(&row)
+		if err != nil {
+			return err
+		}
+		if !keep || out == nil {
+			continue
+		}
+		if err := e.EncodeNext(*out); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
@@ -0,0 +1,41 @@
+package csvstruct
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	type row struct {
+		Name  string
+		Email string
+		Age   int
+	}
+	s := "Name,Email,Age\n alice ,ALICE@EXAMPLE.COM,30\nbob,,25\ncarol,carol@example.com,41\n"
+	var buf bytes.Buffer
+	err := Transform(strings.NewReader(s), &buf, func(r *row) (*row, bool, error) {
+		if r.Email == "" {
+			return nil, false, nil
+		}
+		r.Name = strings.TrimSpace(r.Name)
+		r.Email = strings.ToLower(r.Email)
+		return r, true, nil
+	}, TransformOpts{Encode: EncodeOpts{Comma: ';'}})
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	want := "Name;Email;Age\nalice;alice@example.com;30\ncarol;carol@example.com;41\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Transform: got %q, want %q", got, want)
+	}
+
+	errStop := errors.New("stop")
+	err = Transform(strings.NewReader(s), &buf, func(r *row) (*row, bool, error) {
+		return nil, false, errStop
+	}, TransformOpts{})
+	if err != errStop {
+		t.Errorf("Transform: got error %v, want %v", err, errStop)
+	}
+}
@@ -0,0 +1,145 @@
+package csvstruct
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	unitsMu sync.RWMutex
+	units   = map[string]map[string]float64{
+		"bytes": {
+			"B": 1, "KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12, "PB": 1e15,
+			"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40, "PiB": 1 << 50,
+		},
+		"duration": {
+			"ns": 1, "us": 1e3, "µs": 1e3, "ms": 1e6, "s": 1e9, "m": 60e9, "h": 3600e9,
+		},
+	}
+)
+
+// RegisterUnit registers the suffixes of a unit, and the multiple of the
+// unit's base each stands for. Cells of integer and float fields tagged with
+// the unit, e.g. `csv:"size,unit=bytes"`, may then be written with a suffix,
+// like "3.5GB", and decode to the value in the base unit. Cells without a
+// suffix are in the base unit. Suffixes are matched exactly if possible, and
+// otherwise ignoring case.
+//
+// The units "bytes", with decimal and binary suffixes from "B" to "PiB", and
+// "duration", in nanoseconds with the suffixes of time.ParseDuration, are
+// registered by default. Durations may also combine suffixes, like "1h30m".
+func RegisterUnit(name string, suffixes map[string]float64) {
+	unitsMu.Lock()
+	defer unitsMu.Unlock()
+	units[name] = suffixes
+}
+
+func registeredUnit(name string) map[string]float64 {
+	if name == "" {
+		return nil
+	}
+	unitsMu.RLock()
+	defer unitsMu.RUnlock()
+	return units[name]
+}
+
+// parseUnit returns the exact value, in the base unit, of the cell s
+// written in the given unit, with the suffixes in table.
+func parseUnit(unit string, table map[string]float64, s string) (*big.Rat, bool) {
+	s = strings.TrimSpace(s)
+	if r, ok := parseDecimal(s); ok {
+		return r, true
+	}
+	// Prefer exact matches, then the longest suffix, so that "ms" isn't
+	// taken for "s".
+	for _, fold := range []bool{false, true} {
+		best := ""
+		var value *big.Rat
+		for suffix, mult := range table {
+			if len(suffix) <= len(best) || len(suffix) > len(s) {
+				continue
+			}
+			tail := s[len(s)-len(suffix):]
+			if tail != suffix && !(fold && strings.EqualFold(tail, suffix)) {
+				continue
+			}
+			r, ok := parseDecimal(strings.TrimSpace(s[:len(s)-len(suffix)]))
+			if !ok {
+				continue
+			}
+			m := new(big.Rat)
+			if m.SetFloat64(mult) == nil {
+				continue
+			}
+			best, value = suffix, r.Mul(r, m)
+		}
+		if value != nil {
+			return value, true
+		}
+	}
+	if unit == "duration" {
+		if d, err := time.ParseDuration(s); err == nil {
+			return new(big.Rat).SetInt64(int64(d)), true
+		}
+	}
+	return nil, false
+}
+
+// setUnitField sets the integer or float field vf, described by f, to the
+// value of strv in the unit of f. Other fields are set as usual.
+func (d *decoder) setUnitField(vf reflect.Value, f field, table map[string]float64, strv string) error {
+	t := vf.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !vf.CanSet() || registeredDecoder(t) != nil || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return d.setField(vf, f.name, strv, f.omitempty)
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float64:
+	default:
+		return d.setField(vf, f.name, strv, f.omitempty)
+	}
+	if d.opts.Numbers == StrictNumbers && strings.HasPrefix(strv, "+") {
+		return fmt.Errorf("error decoding: %w", syntaxError("ParseUnit", strv))
+	}
+	r, ok := parseUnit(f.unit, table, strv)
+	if !ok {
+		return fmt.Errorf("error decoding: %q is not a valid %s value", strv, f.unit)
+	}
+	if vf.Kind() == reflect.Ptr {
+		if vf.IsNil() {
+			vf.Set(reflect.New(t))
+		}
+		vf = vf.Elem()
+	}
+	if t.Kind() == reflect.Float64 {
+		fv, _ := r.Float64()
+		vf.SetFloat(fv)
+		return nil
+	}
+	i, ok := d.round(r)
+	if !ok {
+		return fmt.Errorf("error decoding: %q is not a whole number of the base %s unit", strv, f.unit)
+	}
+	bits := t.Bits()
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := i.Uint64(); i.IsUint64() && (bits == 64 || u>>bits == 0) {
+			vf.SetUint(u)
+			return nil
+		}
+	default:
+		if v := i.Int64(); i.IsInt64() && v<<(64-bits)>>(64-bits) == v {
+			vf.SetInt(v)
+			return nil
+		}
+	}
+	return d.numberError(rangeError("ParseUnit", strv), t, f.name, strv)
+}
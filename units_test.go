@@ -0,0 +1,68 @@
+package csvstruct
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecode_Units(t *testing.T) {
+	type row struct {
+		Size    int64         `csv:"size,unit=bytes"`
+		Latency time.Duration `csv:"latency,unit=duration"`
+		Secs    *float64      `csv:"secs,unit=duration,omitempty"`
+		Speed   float64       `csv:"speed,unit=km/h"`
+	}
+	for _, c := range []struct {
+		in      string
+		want    row
+		wantErr bool
+	}{
+		{"3.5GB,250ms,1s,12.5", row{3500000000, 250 * time.Millisecond, nil, 12.5}, false},
+		{"2KiB,1h30m,,1", row{2048, 90 * time.Minute, nil, 1}, false},
+		{"10 mb,1.5us,,1", row{10000000, 1500, nil, 1}, false},
+		{"512,3,,1", row{512, 3, nil, 1}, false},
+		{"1.5B,1s,,1", row{}, true},
+		{"3XB,1s,,1", row{}, true},
+		{"10EB,1s,,1", row{}, true},
+		{"1,1s,,12km/h", row{}, true},
+	} {
+		in := "size,latency,secs,speed\n" + c.in + "\n"
+		d := NewDecoder(strings.NewReader(in))
+		var got row
+		err := d.DecodeNext(&got)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("DecodeNext(%q): got %+v, want error", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DecodeNext(%q): %v", c.in, err)
+			continue
+		}
+		got.Secs = nil
+		if got != c.want {
+			t.Errorf("DecodeNext(%q): got %+v, want %+v", c.in, got, c.want)
+		}
+	}
+
+	var r row
+	d := NewDecoder(strings.NewReader("secs\n2s\n"))
+	if err := d.DecodeNext(&r); err != nil || r.Secs == nil || *r.Secs != 2e9 {
+		t.Errorf("DecodeNext: got %v, %v", r.Secs, err)
+	}
+
+	RegisterUnit("distance", map[string]float64{"m": 1, "km": 1000})
+	type trip struct {
+		Length uint16 `csv:"length,unit=distance"`
+	}
+	var tr trip
+	d = NewDecoder(strings.NewReader("length\n1.2km\n70km\n")).Opts(DecodeOpts{Rounding: RoundHalfUp})
+	if err := d.DecodeNext(&tr); err != nil || tr.Length != 1200 {
+		t.Errorf("DecodeNext: got %v, %v", tr, err)
+	}
+	if err := d.DecodeNext(&tr); err == nil {
+		t.Errorf("DecodeNext(70km): expected overflow")
+	}
+}
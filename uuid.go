@@ -0,0 +1,74 @@
+package csvstruct
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// isUUID reports whether t has the shape of a UUID, [16]byte, as do
+// github.com/google/uuid.UUID and similar types.
+func isUUID(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Len() == 16 && t.Elem().Kind() == reflect.Uint8
+}
+
+// formatUUID formats the UUID in vf in its canonical form, e.g.
+// "f47ac10b-58cc-4372-a567-0e02b2c3d479".
+func formatUUID(vf reflect.Value) string {
+	var b [16]byte
+	reflect.Copy(reflect.ValueOf(&b).Elem(), vf)
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	hex.Encode(s[9:13], b[4:6])
+	hex.Encode(s[14:18], b[6:8])
+	hex.Encode(s[19:23], b[8:10])
+	hex.Encode(s[24:], b[10:])
+	s[8], s[13], s[18], s[23] = '-', '-', '-', '-'
+	return string(s[:])
+}
+
+// parseUUID parses s into the UUID vf. It accepts the canonical form in
+// either case, optionally braced or prefixed with "urn:uuid:", and 32 hex
+// digits without hyphens. An empty cell is the zero UUID.
+func parseUUID(vf reflect.Value, s string) error {
+	if s == "" {
+		vf.Set(reflect.Zero(vf.Type()))
+		return nil
+	}
+	in := s
+	if strings.HasPrefix(strings.ToLower(s), "urn:uuid:") {
+		s = s[len("urn:uuid:"):]
+	} else if len(s) == 38 && s[0] == '{' && s[37] == '}' {
+		s = s[1:37]
+	}
+	if len(s) == 36 {
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return fmt.Errorf("error decoding: invalid UUID %q", in)
+		}
+		s = s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	}
+	var b [16]byte
+	if len(s) != 32 {
+		return fmt.Errorf("error decoding: invalid UUID %q", in)
+	}
+	if _, err := hex.Decode(b[:], []byte(s)); err != nil {
+		return fmt.Errorf("error decoding: invalid UUID %q", in)
+	}
+	reflect.Copy(vf, reflect.ValueOf(b[:]))
+	return nil
+}
+
+// styleUUID applies the braced and upper tag options to a formatted UUID.
+func (f field) styleUUID(s string) string {
+	if s == "" {
+		return s
+	}
+	if f.upper {
+		s = strings.ToUpper(s)
+	}
+	if f.braced {
+		s = "{" + s + "}"
+	}
+	return s
+}
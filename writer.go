@@ -0,0 +1,418 @@
+package csvstruct
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// QuotePolicy describes when an Encoder quotes cells.
+type QuotePolicy int
+
+const (
+	QuoteMinimal    QuotePolicy = iota // quote cells that need it (the default)
+	QuoteAll                           // quote every cell
+	QuoteNever                         // never quote; see EncodeOpts.Unquotable
+	QuoteNonNumeric                    // quote all cells but those of numeric and bool columns
+)
+
+// NewlinePolicy describes how an Encoder writes line breaks within cells.
+type NewlinePolicy int
+
+const (
+	KeepNewlines   NewlinePolicy = iota // write line breaks as they are (the default)
+	LFNewlines                          // convert CRLF and CR to LF
+	EscapeNewlines                      // replace each line break with the two characters \n
+	SpaceNewlines                       // replace each line break with a space
+)
+
+var newlineReplacers = map[NewlinePolicy]*strings.Replacer{
+	LFNewlines:     strings.NewReplacer("\r\n", "\n", "\r", "\n"),
+	EscapeNewlines: strings.NewReplacer("\r\n", `\n`, "\r", `\n`, "\n", `\n`),
+	SpaceNewlines:  strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " "),
+}
+
+// ControlPolicy describes how an Encoder writes control characters, other
+// than tabs and line breaks, within cells.
+type ControlPolicy int
+
+const (
+	KeepControl    ControlPolicy = iota // write control characters as they are (the default)
+	StripControl                        // remove control characters
+	EscapeControl                       // replace each with an escape like \x07
+	ReplaceControl                      // replace each with EncodeOpts.ControlReplacement
+)
+
+// AlignPolicy describes whether an Encoder pads columns to equal widths.
+type AlignPolicy int
+
+const (
+	NoAlign        AlignPolicy = iota // don't pad cells (the default)
+	AlignDelimited                    // pad cells before the delimiter
+	AlignSpaces                       // pad cells and separate them with spaces instead of the delimiter
+)
+
+// writer writes CSV records, like csv.Writer, with control over quoting.
+type writer struct {
+	Comma   rune
+	UseCRLF bool
+	Quote   QuotePolicy
+
+	// Replacement replaces characters that would need quoting when Quote
+	// is QuoteNever. If empty, such cells are an error.
+	Replacement string
+
+	// Numeric marks the columns of data records that QuoteNonNumeric
+	// doesn't quote.
+	Numeric []bool
+
+	Newlines NewlinePolicy
+
+	// TrailingComma writes the delimiter after the last field of each
+	// record, too.
+	TrailingComma bool
+
+	Control            ControlPolicy
+	ControlReplacement string
+
+	// Charset is the encoding of the output, UTF8, Latin1 or Windows1252.
+	// Unmappable replaces runes it can't represent. If empty, cells
+	// containing them are an error.
+	Charset    Charset
+	Unmappable string
+
+	// Align buffers records until WriteAligned, which pads them to equal
+	// column widths.
+	Align   AlignPolicy
+	aligned [][]string
+
+	// Trailer, if set, counts and checksums the records written by
+	// WriteData, as they will be read back.
+	Trailer *trailer
+
+	w *bufio.Writer
+}
+
+// newWriter returns a writer writing to w through a buffer of size bytes,
+// or of the default size if size is zero.
+func newWriter(w io.Writer, size int) *writer {
+	return &writer{Comma: ',', w: bufio.NewWriterSize(w, size)}
+}
+
+// unquotableError reports a cell that can't be written with QuoteNever.
+type unquotableError struct {
+	field int
+	char  rune
+}
+
+func (e *unquotableError) Error() string {
+	return fmt.Sprintf("field %d contains %q, which can't be written without quotes", e.field, e.char)
+}
+
+// unmappableError reports a cell containing a rune the output Charset
+// can't represent.
+type unmappableError struct {
+	field int
+	char  rune
+}
+
+func (e *unmappableError) Error() string {
+	return fmt.Sprintf("field %d contains %q, which can't be encoded in the output charset", e.field, e.char)
+}
+
+// Write writes a single record, followed by a line terminator.
+func (w *writer) Write(record []string) error {
+	return w.write(record, false)
+}
+
+// WriteData writes a data record, adding it to Trailer if set.
+func (w *writer) WriteData(record []string) error {
+	return w.write(record, true)
+}
+
+func (w *writer) write(record []string, data bool) error {
+	record = w.clean(record)
+	if w.Quote == QuoteNever {
+		copied := false
+		for n, field := range record {
+			i := w.unquotable(field)
+			if i < 0 {
+				continue
+			}
+			if w.Replacement == "" {
+				r, _ := utf8.DecodeRuneInString(field[i:])
+				return &unquotableError{n, r}
+			}
+			if !copied {
+				record = append([]string(nil), record...)
+				copied = true
+			}
+			record[n] = w.replace(field)
+		}
+	}
+	// Render every cell before writing anything, so an unmappable cell
+	// leaves no partial record behind. Quoting is decided on the UTF-8
+	// text.
+	cells := make([]string, len(record))
+	for n, field := range record {
+		if w.needsQuotes(field, data && n < len(w.Numeric) && w.Numeric[n]) {
+			field = w.quote(field)
+		}
+		if w.Charset != UTF8 {
+			f, err := w.encode(field)
+			if err != nil {
+				err.field = n
+				return err
+			}
+			field = f
+		}
+		cells[n] = field
+	}
+	if data && w.Trailer != nil {
+		w.Trailer.add(record)
+	}
+	if w.Align != NoAlign {
+		w.aligned = append(w.aligned, cells)
+		return nil
+	}
+	return w.writeCells(cells, nil)
+}
+
+// quote returns field in quotes, with quotes doubled and line breaks
+// written with the configured terminator.
+func (w *writer) quote(field string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for len(field) > 0 {
+		i := strings.IndexAny(field, "\"\r\n")
+		if i < 0 {
+			i = len(field)
+		}
+		b.WriteString(field[:i])
+		field = field[i:]
+		if len(field) > 0 {
+			switch field[0] {
+			case '"':
+				b.WriteString(`""`)
+			case '\r':
+				if !w.UseCRLF {
+					b.WriteByte('\r')
+				}
+			case '\n':
+				if w.UseCRLF {
+					b.WriteString("\r\n")
+				} else {
+					b.WriteByte('\n')
+				}
+			}
+			field = field[1:]
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// writeCells writes rendered cells, followed by a line terminator. If
+// widths is set, each cell but the last is padded with spaces to the width
+// of its column; the last is padded too if TrailingComma is set.
+func (w *writer) writeCells(cells []string, widths []int) error {
+	sep := string(w.Comma)
+	if w.Align == AlignSpaces {
+		sep = "  "
+	} else if w.Charset != UTF8 {
+		sep, _ = w.encode(sep)
+	}
+	for n, cell := range cells {
+		if n > 0 {
+			w.w.WriteString(sep)
+		}
+		w.w.WriteString(cell)
+		if (n < len(cells)-1 || w.TrailingComma) && n < len(widths) {
+			for pad := widths[n] - w.width(cell); pad > 0; pad-- {
+				w.w.WriteByte(' ')
+			}
+		}
+	}
+	if w.TrailingComma {
+		w.w.WriteString(sep)
+	}
+	var err error
+	if w.UseCRLF {
+		_, err = w.w.WriteString("\r\n")
+	} else {
+		err = w.w.WriteByte('\n')
+	}
+	return err
+}
+
+// width returns the number of characters in a rendered cell.
+func (w *writer) width(cell string) int {
+	if w.Charset != UTF8 {
+		return len(cell)
+	}
+	return utf8.RuneCountInString(cell)
+}
+
+// WriteAligned writes the records buffered by Align, padded to the widths
+// of their columns.
+func (w *writer) WriteAligned() error {
+	var widths []int
+	for _, cells := range w.aligned {
+		if len(cells) == 1 {
+			// Don't let comment lines, such as those of
+			// EncodeOpts.Provenance, widen the first column.
+			continue
+		}
+		for n, cell := range cells {
+			if n == len(widths) {
+				widths = append(widths, 0)
+			}
+			if c := w.width(cell); c > widths[n] {
+				widths[n] = c
+			}
+		}
+	}
+	for _, cells := range w.aligned {
+		if err := w.writeCells(cells, widths); err != nil {
+			return err
+		}
+	}
+	w.aligned = nil
+	return nil
+}
+
+// clean applies the Newlines and Control policies to the fields of record,
+// returning a copy if any changed.
+func (w *writer) clean(record []string) []string {
+	nl := newlineReplacers[w.Newlines]
+	if nl == nil && w.Control == KeepControl {
+		return record
+	}
+	copied := false
+	for n, field := range record {
+		f := field
+		if nl != nil {
+			f = nl.Replace(f)
+		}
+		if w.Control != KeepControl {
+			f = w.cleanControl(f)
+		}
+		if f == field {
+			continue
+		}
+		if !copied {
+			// Don't modify the caller's record.
+			record = append([]string(nil), record...)
+			copied = true
+		}
+		record[n] = f
+	}
+	return record
+}
+
+// isControl reports whether r is a control character other than a tab or
+// line break.
+func isControl(r rune) bool {
+	return r != '\t' && r != '\r' && r != '\n' && unicode.IsControl(r)
+}
+
+// cleanControl strips, escapes or replaces the control characters in field
+// according to the Control policy.
+func (w *writer) cleanControl(field string) string {
+	if strings.IndexFunc(field, isControl) < 0 {
+		return field
+	}
+	var b strings.Builder
+	for _, r := range field {
+		if !isControl(r) {
+			b.WriteRune(r)
+			continue
+		}
+		switch w.Control {
+		case EscapeControl:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case ReplaceControl:
+			b.WriteString(w.ControlReplacement)
+		}
+	}
+	return b.String()
+}
+
+// encode transcodes field from UTF-8 to Charset. The field of a returned
+// error is left for the caller to set.
+func (w *writer) encode(field string) (string, *unmappableError) {
+	var b strings.Builder
+	for _, r := range field {
+		if c, ok := encodeRune(w.Charset, r); ok {
+			b.WriteByte(c)
+			continue
+		}
+		if w.Unmappable == "" {
+			return "", &unmappableError{char: r}
+		}
+		for _, r := range w.Unmappable {
+			c, _ := encodeRune(w.Charset, r)
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *writer) Flush() {
+	w.w.Flush()
+}
+
+// Error reports any error from a previous Write or Flush.
+func (w *writer) Error() error {
+	_, err := w.w.Write(nil)
+	return err
+}
+
+// needsQuotes reports whether field, in a numeric column or not, must be
+// quoted, following the rules of csv.Writer under QuoteMinimal.
+func (w *writer) needsQuotes(field string, numeric bool) bool {
+	switch w.Quote {
+	case QuoteAll:
+		return true
+	case QuoteNever:
+		return false
+	case QuoteNonNumeric:
+		// Readers convert unquoted cells to numbers, which an empty
+		// cell isn't.
+		if !numeric || field == "" {
+			return true
+		}
+	}
+	if field == "" {
+		return false
+	}
+	if field == `\.` || w.unquotable(field) >= 0 {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
+
+// unquotable returns the index of the first character in field that can
+// only be written inside quotes, or -1.
+func (w *writer) unquotable(field string) int {
+	return strings.IndexFunc(field, func(r rune) bool {
+		return r == w.Comma || r == '"' || r == '\r' || r == '\n'
+	})
+}
+
+// replace replaces the characters of field that can only be written inside
+// quotes with Replacement. A CRLF pair is replaced once.
+func (w *writer) replace(field string) string {
+	return strings.NewReplacer(
+		string(w.Comma), w.Replacement,
+		`"`, w.Replacement,
+		"\r\n", w.Replacement,
+		"\r", w.Replacement,
+		"\n", w.Replacement,
+	).Replace(field)
+}